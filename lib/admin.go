@@ -0,0 +1,22 @@
+package ent
+
+import (
+	"time"
+)
+
+// BucketUsage describes the files stored in a Bucket.
+type BucketUsage struct {
+	Tenant string  `json:"tenant,omitempty"`
+	Bucket *Bucket `json:"bucket"`
+	Files  int     `json:"files"`
+	Size   int64   `json:"size"`
+}
+
+// Upload describes a file stored in a Bucket.
+type Upload struct {
+	Tenant  string    `json:"tenant,omitempty"`
+	Bucket  string    `json:"bucket"`
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
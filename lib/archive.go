@@ -0,0 +1,22 @@
+package ent
+
+import (
+	"time"
+)
+
+// A Manifest describes the contents of a bucket archive. It is stored as the
+// last entry of every archive and carries the checksums used to verify a
+// restore.
+type Manifest struct {
+	Bucket  *Bucket        `json:"bucket"`
+	Created time.Time      `json:"created"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile carries the metadata of a single object in a bucket archive.
+type ManifestFile struct {
+	Key          string    `json:"key"`
+	LastModified time.Time `json:"lastModified"`
+	SHA1         string    `json:"sha1"`
+	Size         int64     `json:"size"`
+}
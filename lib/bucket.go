@@ -9,6 +9,14 @@ import (
 type Bucket struct {
 	Name  string `json:"name"`
 	Owner Owner  `json:"owner"`
+
+	// Quota limits the total size of all files in the bucket in bytes. No
+	// limit applies if it is zero.
+	Quota int64 `json:"quota,omitempty"`
+
+	// Retention keeps files from being replaced or deleted for the given
+	// number of seconds after they were stored.
+	Retention int64 `json:"retention,omitempty"`
 }
 
 // NewBucket returns a new Bucket given a name and an Owner.
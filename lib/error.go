@@ -1,32 +1,205 @@
 package ent
 
 import (
+	"context"
 	"errors"
 )
 
+// Kind classifies an error independent of the concrete condition which caused
+// it. Callers decide on the reaction to an error, like the HTTP status it is
+// answered with, solely based on its Kind.
+type Kind uint8
+
+// Kinds of errors returned by Ent.
+const (
+	KindUnknown Kind = iota
+	KindInvalid
+	KindNotFound
+	KindConflict
+	KindUnavailable
+	KindQuotaExceeded
+	KindUnsupported
+	KindUnauthorized
+	KindForbidden
+)
+
+var kindNames = map[Kind]string{
+	KindUnknown:       "unknown",
+	KindInvalid:       "invalid",
+	KindNotFound:      "not found",
+	KindConflict:      "conflict",
+	KindUnavailable:   "unavailable",
+	KindQuotaExceeded: "quota exceeded",
+	KindUnsupported:   "unsupported",
+	KindUnauthorized:  "unauthorized",
+	KindForbidden:     "forbidden",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Error is the typed error returned by Ent. It carries the Kind of the failure
+// and optionally wraps the error which caused it.
+type Error struct {
+	Kind Kind
+	Msg  string
+	Err  error
+}
+
+// NewError returns an Error of the given Kind with msg as its description.
+func NewError(kind Kind, msg string) *Error {
+	return &Error{
+		Kind: kind,
+		Msg:  msg,
+	}
+}
+
+// Wrap returns an Error of the given Kind wrapping err. It returns nil if err
+// is nil.
+func Wrap(kind Kind, msg string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		Kind: kind,
+		Msg:  msg,
+		Err:  err,
+	}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Msg
+	case e.Msg == "":
+		return e.Err.Error()
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error wrapped by e.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the Kind of the outermost Error in the chain of err. Expired
+// or cancelled contexts are reported as KindUnavailable.
+func KindOf(err error) Kind {
+	var e *Error
+	switch {
+	case err == nil:
+		return KindUnknown
+	case errors.As(err, &e):
+		return e.Kind
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		return KindUnavailable
+	}
+	return KindUnknown
+}
+
 // Error codes returned by Ent for missing entities.
 var (
-	ErrBucketNotFound = errors.New("bucket not found")
-	ErrFileNotFound   = errors.New("file not found")
-	ErrInvalidParam   = errors.New("invalid param")
+	ErrBucketNotFound = NewError(KindNotFound, "bucket not found")
+	ErrFileNotFound   = NewError(KindNotFound, "file not found")
+	ErrInvalidParam   = NewError(KindInvalid, "invalid param")
+	ErrInvalidForm    = NewError(KindInvalid, "invalid form")
+	ErrInvalidKey     = NewError(KindInvalid, "invalid key")
+)
+
+// Error codes returned by Ent for bucket administration.
+var (
+	ErrBucketExists              = NewError(KindConflict, "bucket exists")
+	ErrInvalidBucketName         = NewError(KindInvalid, "invalid bucket name")
+	ErrBucketCreationUnsupported = NewError(KindUnsupported, "bucket creation not supported")
+)
+
+// Error codes returned by Ent for rejected requests.
+var (
+	ErrQuotaExceeded       = NewError(KindQuotaExceeded, "quota exceeded")
+	ErrInsufficientStorage = NewError(KindQuotaExceeded, "insufficient disk space")
+	ErrUnauthorized        = NewError(KindUnauthorized, "unauthorized")
+	ErrUploadTimeout       = NewError(KindUnavailable, "upload timed out")
+	ErrOverloaded          = NewError(KindUnavailable, "too many concurrent requests")
+	ErrForbidden           = NewError(KindForbidden, "forbidden")
+)
+
+// Error codes returned by Ent when fetching objects from remote URLs or the
+// origin it caches.
+var (
+	ErrFetchDisabled   = NewError(KindUnsupported, "fetch disabled")
+	ErrInvalidFetchURL = NewError(KindInvalid, "invalid fetch url")
+	ErrFetchForbidden  = NewError(KindForbidden, "fetch destination not allowed")
+	ErrOriginMismatch  = NewError(KindUnavailable, "origin content does not match its hash")
+)
+
+// Error codes returned by Ent for retained files.
+var (
+	ErrRetained             = NewError(KindForbidden, "file is retained")
+	ErrRetentionShortened   = NewError(KindConflict, "retention can only be extended")
+	ErrRetentionUnsupported = NewError(KindUnsupported, "retention not supported")
+)
+
+// Error codes returned by Ent for resumable uploads.
+var (
+	ErrUploadNotFound     = NewError(KindNotFound, "upload not found")
+	ErrUploadOffset       = NewError(KindConflict, "upload offset mismatch")
+	ErrUploadBusy         = NewError(KindConflict, "upload in progress")
+	ErrUploadsUnsupported = NewError(KindUnsupported, "resumable uploads not supported")
+)
+
+// Error codes returned by Ent for retried uploads.
+var (
+	ErrIdempotencyBusy     = NewError(KindConflict, "upload with idempotency key in progress")
+	ErrIdempotencyMismatch = NewError(KindConflict, "idempotency key used for another upload")
+)
+
+// Error codes returned by Ent for snapshot operations.
+var (
+	ErrSnapshotExists       = NewError(KindConflict, "snapshot exists")
+	ErrSnapshotNotFound     = NewError(KindNotFound, "snapshot not found")
+	ErrSnapshotsUnsupported = NewError(KindUnsupported, "snapshots not supported")
+)
+
+// Error codes returned by Ent when restoring a bucket archive.
+var (
+	ErrBucketNotEmpty    = NewError(KindConflict, "bucket not empty")
+	ErrChecksumMismatch  = NewError(KindInvalid, "checksum mismatch")
+	ErrInvalidArchive    = NewError(KindInvalid, "invalid archive")
+	ErrIncompleteArchive = NewError(KindInvalid, "incomplete archive")
 )
 
 // IsBucketNotFound returns a boolean indicating the error is
 // ErrBucketNotFound.
 func IsBucketNotFound(err error) bool {
-	switch err.(type) {
-	case nil:
-		return false
-	}
-	return err == ErrBucketNotFound
+	return errors.Is(err, ErrBucketNotFound)
 }
 
 // IsFileNotFound returns a boolean indicating the error is
 // ErrFileNotFound.
 func IsFileNotFound(err error) bool {
-	switch err.(type) {
-	case nil:
-		return false
-	}
-	return err == ErrFileNotFound
+	return errors.Is(err, ErrFileNotFound)
+}
+
+// IsNotFound returns a boolean indicating the error is of KindNotFound.
+func IsNotFound(err error) bool {
+	return KindOf(err) == KindNotFound
+}
+
+// IsConflict returns a boolean indicating the error is of KindConflict.
+func IsConflict(err error) bool {
+	return KindOf(err) == KindConflict
+}
+
+// IsUnavailable returns a boolean indicating the error is of
+// KindUnavailable.
+func IsUnavailable(err error) bool {
+	return KindOf(err) == KindUnavailable
+}
+
+// IsQuotaExceeded returns a boolean indicating the error is of
+// KindQuotaExceeded.
+func IsQuotaExceeded(err error) bool {
+	return KindOf(err) == KindQuotaExceeded
 }
@@ -1,17 +1,19 @@
 package ent
 
 import (
+	"context"
 	"io"
 	"time"
 )
 
 // A FileSystem implements CRUD operations for a collection of named files
-// namespaced into buckets.
+// namespaced into buckets. Implementations abort operations once the passed
+// context is done and return errors classified by a Kind.
 type FileSystem interface {
-	Create(bucket *Bucket, key string, data io.Reader) (File, error)
-	Delete(bucket *Bucket, key string) error
-	Open(bucket *Bucket, key string) (File, error)
-	List(bucket *Bucket, prefix string, limit uint64, sort SortStrategy) (Files, error)
+	Create(ctx context.Context, bucket *Bucket, key string, data io.Reader) (File, error)
+	Delete(ctx context.Context, bucket *Bucket, key string) error
+	Open(ctx context.Context, bucket *Bucket, key string) (File, error)
+	List(ctx context.Context, bucket *Bucket, prefix string, limit uint64, sort SortStrategy) (Files, error)
 }
 
 // File represents a handle to an open file handle.
@@ -28,3 +30,41 @@ type File interface {
 
 // Files represents group of file
 type Files []File
+
+// A Snapshotter is implemented by FileSystems which are able to capture
+// immutable point-in-time views of a bucket.
+type Snapshotter interface {
+	CreateSnapshot(ctx context.Context, bucket *Bucket, name string) (*Snapshot, error)
+	ListSnapshots(ctx context.Context, bucket *Bucket) ([]*Snapshot, error)
+	OpenSnapshot(ctx context.Context, bucket *Bucket, snapshot, key string) (File, error)
+	ListSnapshot(ctx context.Context, bucket *Bucket, snapshot, prefix string, limit uint64, sort SortStrategy) (Files, error)
+}
+
+// A Walker is implemented by FileSystems which are able to hand out the files
+// of a bucket one by one as they are found, instead of collecting a listing
+// first. The files are passed to fn in no particular order and the walk ends
+// with the first error returned by fn.
+type Walker interface {
+	Walk(ctx context.Context, bucket *Bucket, prefix string, fn func(File) error) error
+}
+
+// A Retainer is implemented by FileSystems which are able to retain files,
+// refusing to replace or delete them before their retention passed. Files are
+// retained by the retention of their bucket after they were stored as well as
+// by retaining them individually.
+type Retainer interface {
+	Retain(ctx context.Context, bucket *Bucket, key string, until time.Time) (*Retention, error)
+	Retention(ctx context.Context, bucket *Bucket, key string) (*Retention, error)
+}
+
+// A Resumer is implemented by FileSystems which are able to receive files in
+// several parts, keeping uploads in progress across failed requests and
+// restarts. Once complete, the data of an upload is read back to be stored as
+// file and the upload is removed.
+type Resumer interface {
+	StartUpload(ctx context.Context, bucket *Bucket, key string) (*UploadSession, error)
+	AppendUpload(ctx context.Context, bucket *Bucket, id string, offset int64, data io.Reader) (*UploadSession, error)
+	Upload(ctx context.Context, bucket *Bucket, id string) (*UploadSession, error)
+	ReadUpload(ctx context.Context, bucket *Bucket, id string) (io.ReadCloser, error)
+	RemoveUpload(ctx context.Context, bucket *Bucket, id string) error
+}
@@ -2,6 +2,7 @@ package ent
 
 import (
 	"encoding/json"
+	"net/http"
 	"time"
 )
 
@@ -9,6 +10,39 @@ const (
 	timeFormat = time.RFC3339Nano
 )
 
+// statusCodes maps the Kind of an error to the status code it is answered
+// with. Errors of any other Kind are answered as internal server errors.
+var statusCodes = map[Kind]int{
+	KindInvalid:       http.StatusBadRequest,
+	KindNotFound:      http.StatusNotFound,
+	KindConflict:      http.StatusConflict,
+	KindUnavailable:   http.StatusServiceUnavailable,
+	KindQuotaExceeded: http.StatusInsufficientStorage,
+	KindUnsupported:   http.StatusNotImplemented,
+	KindUnauthorized:  http.StatusUnauthorized,
+	KindForbidden:     http.StatusForbidden,
+}
+
+// StatusCode returns the HTTP status code errors of Kind k are answered with.
+func StatusCode(k Kind) int {
+	code, ok := statusCodes[k]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return code
+}
+
+// KindOfStatus returns the Kind of the errors answered with the HTTP status
+// code, KindUnknown if there is none.
+func KindOfStatus(code int) Kind {
+	for k, c := range statusCodes {
+		if c == code {
+			return k
+		}
+	}
+	return KindUnknown
+}
+
 // ResponseCreated is used as the intermediate type to craft a response for
 // a successful file upload.
 type ResponseCreated struct {
@@ -16,6 +50,17 @@ type ResponseCreated struct {
 	File     ResponseFile  `json:"file"`
 }
 
+// ResponseCreatedList is used as the intermediate type to craft a response
+// for an upload of several files at once. A file which couldn't be stored is
+// listed in Failed, next to the files stored before it.
+type ResponseCreatedList struct {
+	Count    int                  `json:"count"`
+	Duration time.Duration        `json:"duration"`
+	Bucket   *Bucket              `json:"bucket"`
+	Files    []ResponseFile       `json:"files"`
+	Failed   []ResponseFailedFile `json:"failed,omitempty"`
+}
+
 // ResponseDeleted is used as the intermediate type to craft a response for a
 // successfull file deletion
 type ResponseDeleted struct {
@@ -23,23 +68,116 @@ type ResponseDeleted struct {
 	File     ResponseFile  `json:"file"`
 }
 
+// ResponseDeletedList is used as the intermediate type to craft a response for
+// the deletion of all files with a prefix. Files which couldn't be removed are
+// listed in Failed, next to the files removed before and after them.
+type ResponseDeletedList struct {
+	Count    int                  `json:"count"`
+	DryRun   bool                 `json:"dryRun"`
+	Duration time.Duration        `json:"duration"`
+	Bucket   *Bucket              `json:"bucket"`
+	Files    []ResponseFile       `json:"files"`
+	Failed   []ResponseFailedFile `json:"failed,omitempty"`
+}
+
+// ResponseFailedFile is a file an operation on many files failed for.
+type ResponseFailedFile struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
 // ResponseBucketList is used as the intermediate type to craft a response for
-// the retrieval of all buckets.
+// the retrieval of all buckets. ETag changes with any of the buckets and is
+// also sent as header, so clients polling with If-None-Match are answered
+// with 304 Not Modified while nothing changed.
 type ResponseBucketList struct {
 	Count    int           `json:"count"`
 	Duration time.Duration `json:"duration"`
+	ETag     string        `json:"etag"`
 	Buckets  []*Bucket     `json:"buckets"`
 }
 
 // ResponseFileList is used as the intermediate type to craft a response for
-// the retrieval of all files in a bucket.
+// the retrieval of all files in a bucket. ETag changes with any of the listed
+// files, like the ETag of ResponseBucketList.
 type ResponseFileList struct {
+	Count    int            `json:"count"`
+	Duration time.Duration  `json:"duration"`
+	ETag     string         `json:"etag"`
+	Bucket   *Bucket        `json:"bucket"`
+	Files    []ResponseFile `json:"files"`
+}
+
+// ResponseImported is used as the intermediate type to craft a response for
+// a successful restore of a bucket archive.
+type ResponseImported struct {
 	Count    int            `json:"count"`
 	Duration time.Duration  `json:"duration"`
 	Bucket   *Bucket        `json:"bucket"`
 	Files    []ResponseFile `json:"files"`
 }
 
+// ResponseSnapshot is used as the intermediate type to craft a response for
+// a successful snapshot creation.
+type ResponseSnapshot struct {
+	Duration time.Duration `json:"duration"`
+	Snapshot *Snapshot     `json:"snapshot"`
+}
+
+// ResponseSnapshotList is used as the intermediate type to craft a response
+// for the retrieval of all snapshots of a bucket.
+type ResponseSnapshotList struct {
+	Count     int           `json:"count"`
+	Duration  time.Duration `json:"duration"`
+	Bucket    *Bucket       `json:"bucket"`
+	Snapshots []*Snapshot   `json:"snapshots"`
+}
+
+// ResponseBucket is used as the intermediate type to craft a response for a
+// successful bucket creation.
+type ResponseBucket struct {
+	Duration time.Duration `json:"duration"`
+	Bucket   *Bucket       `json:"bucket"`
+}
+
+// ResponseBucketUsage is used as the intermediate type to craft a response
+// for the retrieval of the usage of all buckets.
+type ResponseBucketUsage struct {
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration"`
+	Buckets  []BucketUsage `json:"buckets"`
+}
+
+// ResponseUploads is used as the intermediate type to craft a response for
+// the retrieval of the most recent uploads.
+type ResponseUploads struct {
+	Count   int      `json:"count"`
+	Uploads []Upload `json:"uploads"`
+}
+
+// ResponseRetention is used as the intermediate type to craft a response for
+// the retention of a file.
+type ResponseRetention struct {
+	Duration  time.Duration `json:"duration"`
+	Retention *Retention    `json:"retention"`
+}
+
+// ResponseUploadSession is used as the intermediate type to craft a response
+// for a resumable upload in progress.
+type ResponseUploadSession struct {
+	Duration time.Duration  `json:"duration"`
+	Upload   *UploadSession `json:"upload"`
+}
+
+// ResponseReady is used as the intermediate type to craft a response for the
+// readiness of ent, which depends on the free space of its disk.
+type ResponseReady struct {
+	Ready     bool  `json:"ready"`
+	Free      int64 `json:"free,omitempty"`
+	Watermark int64 `json:"watermark,omitempty"`
+	Headroom  int64 `json:"headroom,omitempty"`
+}
+
 // ResponseError is used as the intermediate type to craft a response for any
 // kind of error condition in the http path. This includes common error cases
 // like an entity could not be found.
@@ -1,7 +1,18 @@
 package ent
 
+import (
+	"context"
+)
+
 // A Provider implements access to a collection of Buckets.
 type Provider interface {
-	Get(name string) (*Bucket, error)
-	List() ([]*Bucket, error)
+	Get(ctx context.Context, name string) (*Bucket, error)
+	List(ctx context.Context) ([]*Bucket, error)
+}
+
+// A BucketCreator is a Provider which allows new Buckets to be added.
+type BucketCreator interface {
+	Provider
+
+	CreateBucket(ctx context.Context, b *Bucket) error
 }
@@ -0,0 +1,14 @@
+package ent
+
+import (
+	"time"
+)
+
+// A Retention keeps a file from being replaced or deleted until a point in
+// time, for data which has to be preserved unaltered. A zero Until means the
+// file is not retained.
+type Retention struct {
+	Bucket *Bucket   `json:"bucket"`
+	Key    string    `json:"key"`
+	Until  time.Time `json:"until"`
+}
@@ -0,0 +1,13 @@
+package ent
+
+import (
+	"time"
+)
+
+// A Snapshot is a named, immutable view of a bucket at the time it was
+// created.
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Bucket  *Bucket   `json:"bucket"`
+	Created time.Time `json:"created"`
+}
@@ -2,13 +2,22 @@ package ent
 
 import (
 	"sort"
+	"strings"
 )
 
-// SortStrategy implements sorting of Files
+// SortStrategy implements sorting of Files.
 type SortStrategy interface {
 	Sort(file Files)
 }
 
+// Comparer is implemented by SortStrategies able to compare two Files, which
+// lets listings limited to a number of files keep only the first files while
+// listing. Before has to be consistent with the order established by Sort.
+type Comparer interface {
+	// Before reports whether a sorts before b.
+	Before(a, b File) bool
+}
+
 // noOpStrategy doesn't change the order of the files.
 type noOpStrategy struct{}
 
@@ -17,9 +26,21 @@ func NoOpStrategy() SortStrategy {
 	return noOpStrategy{}
 }
 
+// IsNoOp reports whether s keeps the order of the files, so any of them are
+// as good as any others in a limited listing.
+func IsNoOp(s SortStrategy) bool {
+	_, ok := s.(noOpStrategy)
+	return ok
+}
+
 // Sort is a convenience method.
 func (s noOpStrategy) Sort(files Files) {}
 
+// Before is always false as no file sorts before another.
+func (s noOpStrategy) Before(a, b File) bool {
+	return false
+}
+
 // byKey orders Files by its key name.
 type byKey struct {
 	baseSortStrategy
@@ -34,18 +55,18 @@ func ByKeyStrategy(ascending bool) SortStrategy {
 	}
 }
 
+// Before reports whether a sorts before b.
+func (s byKey) Before(a, b File) bool {
+	if s.isAscending {
+		return a.Key() < b.Key()
+	}
+	return a.Key() > b.Key()
+}
+
 // Less reports whether the element with index i should sort before the element
 // with index j.
 func (s byKey) Less(i, j int) bool {
-	var (
-		iKey = s.Files[i].Key()
-		jKey = s.Files[j].Key()
-	)
-
-	if s.isAscending {
-		return iKey < jKey
-	}
-	return iKey >= jKey
+	return s.Before(s.Files[i], s.Files[j])
 }
 
 // Sort is a convenience method.
@@ -69,26 +90,119 @@ func ByLastModifiedStrategy(ascending bool) SortStrategy {
 	}
 }
 
+// Before reports whether a sorts before b.
+func (s byLastModified) Before(a, b File) bool {
+	if s.isAscending {
+		return a.LastModified().Before(b.LastModified())
+	}
+	return a.LastModified().After(b.LastModified())
+}
+
 // Less reports whether the element with index i should sort before the element
 // with index j.
 func (s byLastModified) Less(i, j int) bool {
-	var (
-		iLastModified = s.Files[i].LastModified()
-		jLastModified = s.Files[j].LastModified()
-	)
+	return s.Before(s.Files[i], s.Files[j])
+}
 
+// Sort is a convenience method.
+func (s byLastModified) Sort(files Files) {
+	s.Files = files
+	sort.Sort(s)
+}
+
+// byVersion orders Files by their key name, comparing runs of digits by their
+// numeric value, so v1.9 sorts before v1.10.
+type byVersion struct {
+	baseSortStrategy
+}
+
+// ByVersionStrategy returns a SortStrategy ordering by key name naturally,
+// as suited for versioned artifacts.
+func ByVersionStrategy(ascending bool) SortStrategy {
+	return byVersion{
+		baseSortStrategy: baseSortStrategy{
+			isAscending: ascending,
+		},
+	}
+}
+
+// Before reports whether a sorts before b.
+func (s byVersion) Before(a, b File) bool {
+	c := compareVersions(a.Key(), b.Key())
 	if s.isAscending {
-		return iLastModified.Before(jLastModified)
+		return c < 0
 	}
-	return iLastModified.After(jLastModified)
+	return c > 0
+}
+
+// Less reports whether the element with index i should sort before the element
+// with index j.
+func (s byVersion) Less(i, j int) bool {
+	return s.Before(s.Files[i], s.Files[j])
 }
 
 // Sort is a convenience method.
-func (s byLastModified) Sort(files Files) {
+func (s byVersion) Sort(files Files) {
 	s.Files = files
 	sort.Sort(s)
 }
 
+// compareVersions compares a and b run by run, numerically for runs of digits
+// and lexically otherwise. Keys equal in value, like v01 and v1, are ordered
+// lexically.
+func compareVersions(a, b string) int {
+	x, y := a, b
+
+	for x != "" && y != "" {
+		var rx, ry string
+		rx, x = splitRun(x)
+		ry, y = splitRun(y)
+
+		if isDigit(rx[0]) && isDigit(ry[0]) {
+			nx, ny := strings.TrimLeft(rx, "0"), strings.TrimLeft(ry, "0")
+			if len(nx) != len(ny) {
+				if len(nx) < len(ny) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(nx, ny); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		if c := strings.Compare(rx, ry); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case x == "" && y != "":
+		return -1
+	case x != "" && y == "":
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// splitRun splits s after its leading run of either digits or non-digits.
+func splitRun(s string) (string, string) {
+	digits := isDigit(s[0])
+
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
 type baseSortStrategy struct {
 	Files
 	isAscending bool
@@ -0,0 +1,17 @@
+package ent
+
+import (
+	"time"
+)
+
+// An UploadSession is a resumable upload of a file in progress. Offset is the
+// number of bytes durably received so far, from which the upload is continued
+// after a failed request or a restart.
+type UploadSession struct {
+	ID      string    `json:"id"`
+	Bucket  *Bucket   `json:"bucket"`
+	Key     string    `json:"key"`
+	Offset  int64     `json:"offset"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soundcloud/ent/server"
+)
+
+// listenSpec is an address ent listens on, along with the part of the API it
+// serves there. It is given as the address followed by semicolon separated
+// options:
+//
+//	:8080;role=read;middleware=log,metrics,cors,limit
+//	:8443;role=read+write+admin;cert=/etc/ent/tls.crt;key=/etc/ent/tls.key
+type listenSpec struct {
+	addr     string
+	listener server.Listener
+	certFile string
+	keyFile  string
+}
+
+// listenFlags collects the listeners of repeated -http.listen flags.
+type listenFlags []listenSpec
+
+func (fs *listenFlags) String() string {
+	addrs := []string{}
+	for _, s := range *fs {
+		addrs = append(addrs, s.addr)
+	}
+	return strings.Join(addrs, " ")
+}
+
+func (fs *listenFlags) Set(value string) error {
+	s, err := parseListenSpec(value)
+	if err != nil {
+		return err
+	}
+
+	*fs = append(*fs, s)
+	return nil
+}
+
+// parseListenSpec parses a listener given to -http.listen. Listeners serve
+// all roles unless configured otherwise.
+func parseListenSpec(value string) (listenSpec, error) {
+	parts := strings.Split(value, ";")
+
+	s := listenSpec{
+		addr:     strings.TrimSpace(parts[0]),
+		listener: server.Listener{Role: server.RoleAll},
+	}
+	if s.addr == "" {
+		return s, fmt.Errorf("listener %q has no address", value)
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return s, fmt.Errorf("invalid option %q of listener %s", opt, s.addr)
+		}
+
+		switch k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]); k {
+		case "role":
+			role, err := server.ParseRole(v)
+			if err != nil {
+				return s, fmt.Errorf("listener %s: %s", s.addr, err)
+			}
+			s.listener.Role = role
+		case "middleware":
+			s.listener.Middlewares = strings.Split(v, ",")
+		case "cert":
+			s.certFile = v
+		case "key":
+			s.keyFile = v
+		default:
+			return s, fmt.Errorf("unknown option %q of listener %s", k, s.addr)
+		}
+	}
+
+	if (s.certFile == "") != (s.keyFile == "") {
+		return s, fmt.Errorf("listener %s needs both cert and key for TLS", s.addr)
+	}
+
+	return s, nil
+}
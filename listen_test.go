@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/ent/server"
+)
+
+func TestParseListenSpec(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  listenSpec
+		err   bool
+	}{
+		{
+			value: ":8080",
+			want:  listenSpec{addr: ":8080", listener: server.Listener{Role: server.RoleAll}},
+		},
+		{
+			value: ":8080;role=read;middleware=log,cors",
+			want: listenSpec{addr: ":8080", listener: server.Listener{
+				Role:        server.RoleRead,
+				Middlewares: []string{"log", "cors"},
+			}},
+		},
+		{
+			value: "10.0.0.1:8443; role=read+write+admin; cert=tls.crt; key=tls.key",
+			want: listenSpec{
+				addr:     "10.0.0.1:8443",
+				listener: server.Listener{Role: server.RoleAll},
+				certFile: "tls.crt",
+				keyFile:  "tls.key",
+			},
+		},
+		{value: ";role=read", err: true},
+		{value: ":8080;role=root", err: true},
+		{value: ":8080;cert=tls.crt", err: true},
+		{value: ":8080;port=80", err: true},
+		{value: ":8080;role", err: true},
+	} {
+		got, err := parseListenSpec(test.value)
+		if test.err != (err != nil) {
+			t.Errorf("%q: want error %t, got %v", test.value, test.err, err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%q: want %+v, got %+v", test.value, test.want, got)
+		}
+	}
+}
@@ -1,575 +1,180 @@
 package main
 
 import (
-	"encoding/hex"
-	"encoding/json"
 	"flag"
-	"io"
 	logpkg "log"
-	"math"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gorilla/pat"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/soundcloud/ent/lib"
-	"github.com/streadway/handy/report"
-)
-
-const (
-	keyBucket   = ":bucket"
-	keyBlob     = ":key"
-	routeBucket = `/{bucket}`
-	routeFile   = `/{bucket}/{key:[a-zA-Z0-9\-_\.~\+\/]+}`
-
-	paramLimit  = "limit"
-	paramPrefix = "prefix"
-	paramSort   = "sort"
-
-	orderKey          = "key"
-	orderLastModified = "lastModified"
-	orderAscending    = "+"
-	orderDescending   = "-"
-
-	defaultLimit uint64 = math.MaxUint64
-
-	headerETag         = "ETag"
-	headerSHA1         = "SHA1"
-	headerLastModified = "Last-Modified"
+	"github.com/soundcloud/ent/server"
 )
 
 // Buildtime variables
 var (
-	Program = "ent"
+	Program = server.Program
 	Commit  = "0000000"
 	Version = "0.0.0"
 )
 
-// Telemetry
-var (
-	labelNames = []string{"bucket", "method", "operation", "status"}
-
-	requestDurations = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Namespace: Program,
-			Name:      "requests_duration_nanoseconds",
-			Help:      "Amounts of time ent has spent answering requests in nanoseconds.",
-		},
-		labelNames,
-	)
-	// Note that the summary 'requestDurations' above will result in metrics
-	// 'ent_requests_duration_nanoseconds_count' and
-	// 'ent_requests_duration_nanoseconds_sum', counting the total number of
-	// requests made and summing up the total amount of time ent has spent
-	// to answer requests, respectively.
-	requestBytes = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: Program,
-			Name:      "request_bytes_total",
-			Help:      "Total volume of request payloads emitted in bytes.",
-		},
-		labelNames,
-	)
-	responseBytes = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: Program,
-			Name:      "response_bytes_total",
-			Help:      "Total volume of response payloads emitted in bytes.",
-		},
-		labelNames,
-	)
-
-	log = logpkg.New(os.Stdout, "", logpkg.LstdFlags|logpkg.Lmicroseconds)
-)
+var log = logpkg.New(os.Stdout, "", logpkg.LstdFlags|logpkg.Lmicroseconds)
 
 func main() {
-	var (
-		fsRoot      = flag.String("fs.root", "/tmp", "FileSystem root directory")
-		httpAddress = flag.String("http.addr", ":5555", "HTTP listen address")
-		providerDir = flag.String("provider.dir", "/tmp", "Provider directory with bucket policies")
-	)
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "mount" {
+		err := runMount(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	prometheus.MustRegister(requestDurations)
-	prometheus.MustRegister(requestBytes)
-	prometheus.MustRegister(responseBytes)
+	var listens listenFlags
+	flag.Var(&listens, "http.listen", "Listen address with optional ;role=read+write+admin;middleware=a,b;cert=file;key=file, repeatable for several listeners (replaces http.addr)")
 
 	var (
-		fs = newDiskFS(*fsRoot)
-		r  = pat.New()
+		adminKeys    = flag.String("admin.keys", "", "Comma-separated keys granting access to the admin dashboard at /_admin/ (empty disables)")
+		adminRecent  = flag.Int("admin.uploads", 100, "Number of recent uploads shown on the admin dashboard")
+		fetchHosts   = flag.String("fetch.hosts", "", "Comma-separated hosts uploads may be fetched from, including subdomains for hosts starting with a dot (empty allows all public hosts)")
+		fetchEnable  = flag.Bool("fetch.enable", false, "Allow uploads to be fetched from the URL in the X-Ent-Fetch-URL header")
+		fetchTimeout = flag.Duration("fetch.timeout", 10*time.Minute, "Maximum duration of a fetch from a remote URL")
+		fsRoot       = flag.String("fs.root", "/tmp", "FileSystem root directory")
+		fsWatermark  = flag.Int64("fs.watermark", 0, "Reject uploads while fewer bytes are free on the disk of fs.root (0 disables)")
+		fsMmapMax    = flag.Int64("fs.mmap.maxsize", 0, "Serve files up to this size in bytes from memory mappings (0 disables)")
+		fsMmapCache  = flag.Int64("fs.mmap.cachesize", 64<<20, "Maximum total size in bytes of memory mapped files")
+		httpAddress  = flag.String("http.addr", ":5555", "HTTP listen address")
+		limitDown    = flag.Int("limit.downloads", 0, "Maximum number of concurrent downloads (0 disables)")
+		limitDownB   = flag.Int("limit.downloads.bucket", 0, "Maximum number of concurrent downloads per bucket (0 disables)")
+		limitUp      = flag.Int("limit.uploads", 0, "Maximum number of concurrent uploads (0 disables)")
+		limitUpB     = flag.Int("limit.uploads.bucket", 0, "Maximum number of concurrent uploads per bucket (0 disables)")
+		idemTTL      = flag.Duration("idempotency.ttl", 24*time.Hour, "Duration uploads carrying an Idempotency-Key are remembered for retries (0 disables)")
+		keyMaxLength = flag.Int("key.maxlength", 1024, "Maximum length in bytes of keys of new files (0 disables)")
+		keyPattern   = flag.String("key.pattern", server.DefaultKeyPattern, "Regular expression matching the allowed keys")
+		keyNFC       = flag.Bool("key.nfc", false, "Normalize keys to Unicode NFC")
+		listTTL      = flag.Duration("list.cache.ttl", 0, "Duration listings are cached for (0 disables)")
+		listSize     = flag.Int("list.cache.size", 1000, "Maximum number of cached listings")
+		limitWait    = flag.Duration("limit.wait", 5*time.Second, "Maximum duration requests queue for a free slot when at a concurrency limit")
+		httpHeader   = flag.Duration("http.timeout.header", 10*time.Second, "Maximum duration for reading request headers (0 disables)")
+		httpRead     = flag.Duration("http.timeout.read", 0, "Maximum duration for reading entire requests including bodies (0 disables)")
+		httpWrite    = flag.Duration("http.timeout.write", 0, "Maximum duration for writing responses (0 disables)")
+		httpIdle     = flag.Duration("http.timeout.idle", 2*time.Minute, "Maximum duration keep-alive connections are kept idle (0 disables)")
+		httpChain    = flag.String("http.middleware", strings.Join(server.DefaultMiddlewares, ","), "Comma-separated middlewares wrapping all handlers, outermost first")
+		httpUpload   = flag.Duration("http.timeout.upload", time.Hour, "Maximum duration of uploads and imports (0 disables)")
+		notifyQuota  = flag.Float64("notify.quota", 0.9, "Notify bucket owners once this ratio of a quota is used (0 disables)")
+		notifyFails  = flag.Int("notify.failures", 10, "Notify bucket owners after this many failed uploads within notify.window (0 disables)")
+		notifyWindow = flag.Duration("notify.window", time.Hour, "Window in which failed uploads are counted")
+		notifyEvery  = flag.Duration("notify.interval", 24*time.Hour, "Minimum interval between repeated notifications of a bucket owner")
+		originURL    = flag.String("origin.url", "", "Base URL of an origin ent to cache files missing locally from (empty disables)")
+		originToken  = flag.String("origin.token", "", "Bearer token presented to the origin")
+		originWait   = flag.Duration("origin.timeout", 10*time.Minute, "Maximum duration of a fetch from the origin")
+		originMiss   = flag.Duration("origin.miss.ttl", 30*time.Second, "Duration files missing at the origin are not asked for again (0 disables)")
+		peerList     = flag.String("peers", "", "Comma-separated base URLs of replication peers serving files missing locally (empty disables)")
+		peerTimeout  = flag.Duration("peers.timeout", 10*time.Second, "Maximum duration of a request to a peer")
+		peerMissTTL  = flag.Duration("peers.miss.ttl", 30*time.Second, "Duration files missing on all peers are not asked for again (0 disables)")
+		usageEvery   = flag.Duration("usage.interval", 0, "Interval in which the tracked usage of all buckets is reconciled with the FileSystem (0 disables tracking)")
+		uploadTTL    = flag.Duration("upload.resume.ttl", 24*time.Hour, "Duration resumable uploads are kept without being continued")
+		providerDir  = flag.String("provider.dir", "/tmp", "Provider directory with bucket policies")
+		smtpAddress  = flag.String("smtp.addr", "", "SMTP server address for owner notifications (empty disables)")
+		smtpFrom     = flag.String("smtp.from", "ent@localhost", "Sender address of owner notifications")
+		smtpUser     = flag.String("smtp.user", "", "SMTP username, authenticating with PLAIN if set")
+		smtpPassword = flag.String("smtp.password", "", "SMTP password")
+		tenantDir    = flag.String("tenant.dir", "", "Directory with one subdirectory of configuration and bucket policies per tenant (enables multi-tenancy)")
 	)
+	flag.Parse()
 
-	p, err := newDiskProvider(*providerDir)
-	if err != nil {
-		log.Fatal(err)
+	config := server.Config{
+		ProviderDir:     *providerDir,
+		FSRoot:          *fsRoot,
+		TenantDir:       *tenantDir,
+		MmapMaxSize:     *fsMmapMax,
+		DiskWatermark:   *fsWatermark,
+		KeyMaxLength:    *keyMaxLength,
+		KeyPattern:      *keyPattern,
+		KeyNFC:          *keyNFC,
+		MmapCacheSize:   *fsMmapCache,
+		Fetch:           *fetchEnable,
+		FetchTimeout:    *fetchTimeout,
+		UploadTimeout:   *httpUpload,
+		Uploads:         *limitUp,
+		UploadsBucket:   *limitUpB,
+		Downloads:       *limitDown,
+		DownloadsBucket: *limitDownB,
+		LimitWait:       *limitWait,
+		ListCacheTTL:    *listTTL,
+		ListCacheSize:   *listSize,
+		Origin:          *originURL,
+		OriginToken:     *originToken,
+		OriginTimeout:   *originWait,
+		OriginMissTTL:   *originMiss,
+		PeerTimeout:     *peerTimeout,
+		PeerMissTTL:     *peerMissTTL,
+		AdminUploads:    *adminRecent,
+		SMTPAddr:        *smtpAddress,
+		SMTPFrom:        *smtpFrom,
+		SMTPUser:        *smtpUser,
+		SMTPPassword:    *smtpPassword,
+		NotifyQuota:     *notifyQuota,
+		NotifyFailures:  *notifyFails,
+		NotifyWindow:    *notifyWindow,
+		NotifyInterval:  *notifyEvery,
+		UploadResumeTTL: *uploadTTL,
+		IdempotencyTTL:  *idemTTL,
+		UsageInterval:   *usageEvery,
+		Middlewares:     strings.Split(*httpChain, ","),
 	}
 
-	// GET /metrics
-	r.Handle("/metrics", prometheus.Handler())
-
-	// DELETE /$bucket/$file
-	r.Add(
-		"DELETE",
-		routeFile,
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleDelete",
-				handleDelete(p, fs),
-			),
-		),
-	)
-	// GET /$bucket/$file
-	r.Add(
-		"GET",
-		routeFile,
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleGet",
-				addCORSHeaders(
-					handleGet(p, fs),
-				),
-			),
-		),
-	)
-	// HEAD /$bucket/$file
-	r.Add(
-		"HEAD",
-		routeFile,
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleExists",
-				addCORSHeaders(
-					handleExists(p, fs),
-				),
-			),
-		),
-	)
-	// POST /$bucket/$file
-	r.Add(
-		"POST",
-		routeFile,
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleCreate",
-				addCORSHeaders(
-					handleCreate(p, fs),
-				),
-			),
-		),
-	)
-
-	// GET /$bucket
-	r.Add(
-		"GET",
-		routeBucket,
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleFileList",
-				addCORSHeaders(
-					handleFileList(p, fs),
-				),
-			),
-		),
-	)
-
-	// GET /
-	r.Add(
-		"GET",
-		"/",
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleBucketList",
-				addCORSHeaders(
-					handleBucketList(p),
-				),
-			),
-		),
-	)
-
-	r.Add(
-		"OPTIONS",
-		"/{.*}",
-		report.JSON(
-			os.Stdout,
-			metrics(
-				"handleOptions",
-				addCORSHeaders(
-					handleOptions(),
-				),
-			),
-		),
-	)
-
-	log.Printf("listening on %s", *httpAddress)
-	log.Fatal(http.ListenAndServe(*httpAddress, http.Handler(r)))
-}
-
-func handleCreate(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			bucket = r.URL.Query().Get(":bucket")
-			key    = r.URL.Query().Get(":key")
-			start  = time.Now()
-		)
-		defer r.Body.Close()
-
-		b, err := p.Get(bucket)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		f, err := fs.Create(b, key, r.Body)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		defer f.Close()
-
-		err = writeBlobHeaders(w, f)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-		respondJSON(w, http.StatusCreated, ent.ResponseCreated{
-			Duration: time.Since(start),
-			File: ent.ResponseFile{
-				Key:          key,
-				Bucket:       b,
-				LastModified: f.LastModified(),
-			},
-		})
+	if *adminKeys != "" {
+		config.AdminKeys = strings.Split(*adminKeys, ",")
 	}
-}
-
-func handleDelete(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			bucket = r.URL.Query().Get(keyBucket)
-			key    = r.URL.Query().Get(keyBlob)
-			start  = time.Now()
-		)
-		defer r.Body.Close()
-
-		b, err := p.Get(bucket)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		f, err := fs.Open(b, key)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-		defer f.Close()
-
-		err = fs.Delete(b, key)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
 
-		respondJSON(w, http.StatusOK, ent.ResponseCreated{
-			Duration: time.Since(start),
-			File: ent.ResponseFile{
-				Bucket:       b,
-				Key:          key,
-				LastModified: f.LastModified(),
-			},
-		})
+	if *fetchHosts != "" {
+		config.FetchHosts = strings.Split(*fetchHosts, ",")
 	}
-}
-
-func handleExists(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			bucket = r.URL.Query().Get(keyBucket)
-			key    = r.URL.Query().Get(keyBlob)
-		)
-
-		b, err := p.Get(bucket)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		f, err := fs.Open(b, key)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-		defer f.Close()
 
-		err = writeBlobHeaders(w, f)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
+	if *peerList != "" {
+		config.Peers = strings.Split(*peerList, ",")
 	}
-}
-
-func handleGet(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			bucket = r.URL.Query().Get(keyBucket)
-			key    = r.URL.Query().Get(keyBlob)
-		)
-
-		b, err := p.Get(bucket)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		f, err := fs.Open(b, key)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-		defer f.Close()
-
-		err = writeBlobHeaders(w, f)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
 
-		http.ServeContent(w, r, key, time.Now(), f)
+	if len(listens) == 0 {
+		listens = listenFlags{{
+			addr:     *httpAddress,
+			listener: server.Listener{Role: server.RoleAll},
+		}}
 	}
-}
-
-func handleBucketList(p ent.Provider) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			start = time.Now()
-		)
 
-		bs, err := p.List()
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
+	ls := []server.Listener{}
+	for _, l := range listens {
+		ls = append(ls, l.listener)
+	}
 
-		respondJSON(w, http.StatusOK, ent.ResponseBucketList{
-			Count:    len(bs),
-			Duration: time.Since(start),
-			Buckets:  bs,
-		})
+	hs, err := server.NewHandlers(config, ls...)
+	if err != nil {
+		log.Fatal(err)
 	}
-}
 
-func handleFileList(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			start      = time.Now()
-			limit      = defaultLimit
-			bucket     = r.URL.Query().Get(keyBucket)
-			limitValue = r.URL.Query().Get(paramLimit)
-			prefix     = r.URL.Query().Get(paramPrefix)
-			sortValue  = r.URL.Query().Get(paramSort)
-		)
+	errc := make(chan error, len(listens))
 
-		b, err := p.Get(bucket)
-		if err != nil {
-			respondError(w, r, err)
-			return
+	for i, l := range listens {
+		srv := &http.Server{
+			Addr:              l.addr,
+			Handler:           hs[i],
+			ReadHeaderTimeout: *httpHeader,
+			ReadTimeout:       *httpRead,
+			WriteTimeout:      *httpWrite,
+			IdleTimeout:       *httpIdle,
 		}
 
-		if limitValue != "" {
-			limit, err = strconv.ParseUint(limitValue, 10, 64)
-			if err != nil {
-				respondError(w, r, ent.ErrInvalidParam)
+		go func(l listenSpec) {
+			if l.certFile != "" {
+				log.Printf("listening on %s with TLS as %s", l.addr, l.listener.Role)
+				errc <- srv.ListenAndServeTLS(l.certFile, l.keyFile)
 				return
 			}
-		}
 
-		sortStrategy, err := createSortStrategy(sortValue)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		files, err := fs.List(b, prefix, limit, sortStrategy)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-
-		responseFiles, err := createResponseFiles(files, b)
-		if err != nil {
-			respondError(w, r, err)
-			return
-		}
-		for _, file := range files {
-			defer file.Close()
-		}
-
-		respondJSON(w, http.StatusOK, ent.ResponseFileList{
-			Count:    len(responseFiles),
-			Duration: time.Since(start),
-			Bucket:   b,
-			Files:    responseFiles,
-		})
-	}
-}
-
-func handleOptions() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-}
-
-func addCORSHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Origin")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-func metrics(op string, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var (
-			start = time.Now()
-			rd    = &readerDelegator{ReadCloser: r.Body}
-			rc    = &responseRecorder{ResponseWriter: w}
-		)
-
-		r.Body = rd
-
-		next.ServeHTTP(rc, r)
-
-		d := time.Since(start)
-		labels := map[string]string{
-			"bucket":    r.URL.Query().Get(keyBucket),
-			"method":    strings.ToLower(r.Method),
-			"operation": op,
-			"status":    strconv.Itoa(rc.status),
-		}
-
-		requestBytes.With(labels).Add(float64(rd.BytesRead))
-		requestDurations.With(labels).Observe(float64(d))
-		responseBytes.With(labels).Add(float64(rc.size))
-	})
-}
-
-func respondError(w http.ResponseWriter, r *http.Request, err error) {
-	code := http.StatusInternalServerError
-
-	switch err {
-	case ent.ErrBucketNotFound, ent.ErrFileNotFound:
-		code = http.StatusNotFound
-	case ent.ErrInvalidParam:
-		code = http.StatusBadRequest
-	}
-
-	respondJSON(w, code, ent.ResponseError{
-		Code:        code,
-		Error:       err.Error(),
-		Description: http.StatusText(code),
-	})
-}
-
-func respondJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(payload)
-}
-
-type readerDelegator struct {
-	io.ReadCloser
-	BytesRead int
-}
-
-func (r *readerDelegator) Read(p []byte) (int, error) {
-	n, err := r.ReadCloser.Read(p)
-	r.BytesRead += n
-	return n, err
-}
-
-type responseRecorder struct {
-	http.ResponseWriter
-	status int
-	size   int
-}
-
-func (r *responseRecorder) Write(b []byte) (int, error) {
-	n, err := r.ResponseWriter.Write(b)
-	r.size += n
-	return n, err
-}
-
-func (r *responseRecorder) WriteHeader(code int) {
-	r.status = code
-	r.ResponseWriter.WriteHeader(code)
-}
-
-func createResponseFiles(files ent.Files, bucket *ent.Bucket) ([]ent.ResponseFile, error) {
-	responseFiles := make([]ent.ResponseFile, len(files))
-	for i, file := range files {
-		responseFiles[i] = ent.ResponseFile{
-			Key:          file.Key(),
-			LastModified: file.LastModified(),
-			Bucket:       bucket,
-		}
-	}
-	return responseFiles, nil
-}
-
-func createSortStrategy(value string) (ent.SortStrategy, error) {
-	if value == "" {
-		return ent.NoOpStrategy(), nil
-	}
-	if len(value) == 1 {
-		return nil, ent.ErrInvalidParam
-	}
-
-	var (
-		asc       = true
-		order     = value[:1]
-		criterion = value[1:]
-	)
-
-	// check if the sort param starts the "+" or "-"
-	switch order {
-	case orderAscending:
-		// nothing to do
-	case orderDescending:
-		asc = false
-	default:
-		return nil, ent.ErrInvalidParam
-	}
-
-	switch criterion {
-	case orderKey:
-		return ent.ByKeyStrategy(asc), nil
-	case orderLastModified:
-		return ent.ByLastModifiedStrategy(asc), nil
-	default:
-		return nil, ent.ErrInvalidParam
-	}
-}
-
-func writeBlobHeaders(w http.ResponseWriter, f ent.File) error {
-	h, err := f.Hash()
-	if err != nil {
-		return err
+			log.Printf("listening on %s as %s", l.addr, l.listener.Role)
+			errc <- srv.ListenAndServe()
+		}(l)
 	}
 
-	w.Header().Add(headerETag, hex.EncodeToString(h))
-	w.Header().Add(headerLastModified, f.LastModified().Format(time.RFC3339Nano))
-	return nil
+	log.Fatal(<-errc)
 }
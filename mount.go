@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// runMount serves a bucket of a remote ent as FUSE filesystem until it is
+// unmounted. It implements the mount subcommand:
+//
+//	ent mount -url http://localhost:5555 -bucket bit /mnt/bit
+func runMount(args []string) error {
+	var (
+		flags   = flag.NewFlagSet("mount", flag.ExitOnError)
+		base    = flags.String("url", "http://localhost:5555", "URL of ent, including the tenant if any")
+		bucket  = flags.String("bucket", "", "Bucket to mount")
+		token   = flags.String("token", "", "Key presented as bearer token")
+		timeout = flags.Duration("timeout", time.Minute, "Maximum duration of a request to ent")
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s mount [flags] <mountpoint>\n", Program)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *bucket == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return mountBucket(flags.Arg(0), &remoteBucket{
+		base:   strings.TrimSuffix(*base, "/"),
+		bucket: *bucket,
+		token:  *token,
+		client: &http.Client{Timeout: *timeout},
+	})
+}
+
+// remoteBucket accesses the files of a bucket through the HTTP API of ent.
+type remoteBucket struct {
+	base   string
+	bucket string
+	token  string
+	client *http.Client
+}
+
+// list returns the keys of all files starting with prefix.
+func (b *remoteBucket) list(ctx context.Context, prefix string) ([]string, error) {
+	res, err := b.do(ctx, "GET", "?"+url.Values{"prefix": {prefix}}.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	list := ent.ResponseFileList{}
+
+	err = json.NewDecoder(res.Body).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(list.Files))
+	for i, f := range list.Files {
+		keys[i] = f.Key
+	}
+
+	return keys, nil
+}
+
+// stat returns the size and the time of the last modification of the file
+// stored for key.
+func (b *remoteBucket) stat(ctx context.Context, key string) (int64, time.Time, error) {
+	res, err := b.do(ctx, "HEAD", "/"+escapeKey(key), nil, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	res.Body.Close()
+
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid size of %s: %s", key, err)
+	}
+
+	// A missing modification time is not worth failing for.
+	modified, _ := time.Parse(time.RFC3339Nano, res.Header.Get("Last-Modified"))
+
+	return size, modified, nil
+}
+
+// read returns up to size bytes of the file stored for key from offset on.
+func (b *remoteBucket) read(
+	ctx context.Context,
+	key string,
+	offset int64,
+	size int,
+) ([]byte, error) {
+	h := http.Header{}
+	h.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(size)-1))
+
+	res, err := b.do(ctx, "GET", "/"+escapeKey(key), h, nil)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		// Reading at or beyond the end of the file.
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(io.LimitReader(res.Body, int64(size)))
+}
+
+// write stores size bytes of r for key.
+func (b *remoteBucket) write(ctx context.Context, key string, r io.Reader, size int64) error {
+	res, err := b.do(ctx, "PUT", "/"+escapeKey(key), nil, &sizedBody{Reader: r, size: size})
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// remove deletes the file stored for key.
+func (b *remoteBucket) remove(ctx context.Context, key string) error {
+	res, err := b.do(ctx, "DELETE", "/"+escapeKey(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// errRangeNotSatisfiable is returned for reads beyond the end of a file.
+var errRangeNotSatisfiable = ent.NewError(ent.KindInvalid, "range not satisfiable")
+
+// sizedBody lets the request carry the size of the body as Content-Length.
+type sizedBody struct {
+	io.Reader
+	size int64
+}
+
+func (b *remoteBucket) do(
+	ctx context.Context,
+	method string,
+	path string,
+	header http.Header,
+	body *sizedBody,
+) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = body.Reader
+	}
+
+	req, err := http.NewRequest(method, b.base+"/"+url.PathEscape(b.bucket)+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	if body != nil {
+		req.ContentLength = body.size
+		if body.size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, ent.Wrap(ent.KindUnavailable, "request failed", err)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	return nil, responseError(res)
+}
+
+// responseError reconstructs the error an error response of ent was answered
+// for.
+func responseError(res *http.Response) error {
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return errRangeNotSatisfiable
+	}
+
+	kind := ent.KindOfStatus(res.StatusCode)
+
+	// Responses to HEAD requests come without a body.
+	e := ent.ResponseError{}
+	if json.NewDecoder(res.Body).Decode(&e) != nil || e.Error == "" {
+		e.Error = res.Status
+	}
+
+	for _, err := range []*ent.Error{ent.ErrFileNotFound, ent.ErrBucketNotFound} {
+		if err.Msg == e.Error {
+			return err
+		}
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return ent.ErrFileNotFound
+	}
+
+	return ent.NewError(kind, e.Error)
+}
+
+// escapeKey escapes every segment of key for use in a path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,442 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/soundcloud/ent/lib"
+)
+
+// mountBucket serves b as FUSE filesystem at dir until it is unmounted or an
+// interrupt is received. Keys are mapped to paths, directories only exist as
+// long as files are stored below them.
+func mountBucket(dir string, b *remoteBucket) error {
+	c, err := fuse.Mount(
+		dir,
+		fuse.FSName(b.bucket),
+		fuse.Subtype(Program),
+	)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		err := fuse.Unmount(dir)
+		if err != nil {
+			log.Printf("unmounting %s failed: %s", dir, err)
+		}
+	}()
+
+	log.Printf("serving %s/%s at %s", b.base, b.bucket, dir)
+
+	err = fusefs.Serve(c, &mountFS{b: b})
+	if err != nil {
+		return err
+	}
+
+	<-c.Ready
+	return c.MountError
+}
+
+type mountFS struct {
+	b *remoteBucket
+}
+
+func (m *mountFS) Root() (fusefs.Node, error) {
+	return &mountDir{b: m.b}, nil
+}
+
+// mountDir is a directory, all keys starting with prefix.
+type mountDir struct {
+	b      *remoteBucket
+	prefix string
+}
+
+func (d *mountDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0755
+	return nil
+}
+
+func (d *mountDir) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	key := d.prefix + name
+
+	size, modified, err := d.b.stat(ctx, key)
+	if err == nil {
+		return &mountFile{b: d.b, key: key, size: size, modified: modified}, nil
+	}
+	if !ent.IsFileNotFound(err) {
+		return nil, fuseError(err)
+	}
+
+	keys, err := d.b.list(ctx, key+"/")
+	if err != nil {
+		return nil, fuseError(err)
+	}
+	if len(keys) > 0 {
+		return &mountDir{b: d.b, prefix: key + "/"}, nil
+	}
+
+	return nil, fuse.ENOENT
+}
+
+func (d *mountDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	keys, err := d.b.list(ctx, d.prefix)
+	if err != nil {
+		return nil, fuseError(err)
+	}
+
+	var (
+		dirs    = map[string]bool{}
+		entries = []fuse.Dirent{}
+	)
+
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, d.prefix)
+
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i]
+			if !dirs[name] {
+				dirs[name] = true
+				entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_Dir})
+			}
+			continue
+		}
+
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_File})
+	}
+
+	return entries, nil
+}
+
+// Mkdir succeeds without storing anything, the directory only persists once
+// a file is written into it.
+func (d *mountDir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fusefs.Node, error) {
+	return &mountDir{b: d.b, prefix: d.prefix + req.Name + "/"}, nil
+}
+
+func (d *mountDir) Create(
+	ctx context.Context,
+	req *fuse.CreateRequest,
+	resp *fuse.CreateResponse,
+) (fusefs.Node, fusefs.Handle, error) {
+	f := &mountFile{b: d.b, key: d.prefix + req.Name, modified: time.Now()}
+
+	h, err := newWriteHandle(ctx, f, true)
+	if err != nil {
+		return nil, nil, fuseError(err)
+	}
+
+	// The file is created right away, so it can be looked up while written.
+	err = h.flush(ctx, true)
+	if err != nil {
+		h.Release(ctx, nil)
+		return nil, nil, fuseError(err)
+	}
+
+	f.writer = h
+
+	return f, h, nil
+}
+
+func (d *mountDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	key := d.prefix + req.Name
+
+	if req.Dir {
+		keys, err := d.b.list(ctx, key+"/")
+		if err != nil {
+			return fuseError(err)
+		}
+		if len(keys) > 0 {
+			return fuse.Errno(syscall.ENOTEMPTY)
+		}
+		return nil
+	}
+
+	return fuseError(d.b.remove(ctx, key))
+}
+
+// mountFile is the file stored for key.
+type mountFile struct {
+	b   *remoteBucket
+	key string
+
+	mu       sync.Mutex
+	size     int64
+	modified time.Time
+
+	// writer is the handle the file is currently opened for writing with.
+	writer *writeHandle
+}
+
+func (f *mountFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a.Mode = 0644
+	a.Size = uint64(f.size)
+	a.Mtime = f.modified
+	a.Ctime = f.modified
+
+	return nil
+}
+
+func (f *mountFile) Open(
+	ctx context.Context,
+	req *fuse.OpenRequest,
+	resp *fuse.OpenResponse,
+) (fusefs.Handle, error) {
+	if req.Flags.IsReadOnly() {
+		return &readHandle{f: f}, nil
+	}
+
+	h, err := newWriteHandle(ctx, f, req.Flags&fuse.OpenTruncate != 0)
+	if err != nil {
+		return nil, fuseError(err)
+	}
+
+	f.mu.Lock()
+	f.writer = h
+	f.mu.Unlock()
+
+	return h, nil
+}
+
+// Setattr supports truncation of files which are not open for writing by
+// rewriting them with their new size.
+func (f *mountFile) Setattr(
+	ctx context.Context,
+	req *fuse.SetattrRequest,
+	resp *fuse.SetattrResponse,
+) error {
+	if req.Valid.Size() {
+		f.mu.Lock()
+		writer := f.writer
+		f.mu.Unlock()
+
+		if writer != nil {
+			err := writer.truncate(int64(req.Size))
+			if err != nil {
+				return fuseError(err)
+			}
+		} else {
+			h, err := newWriteHandle(ctx, f, req.Size == 0)
+			if err != nil {
+				return fuseError(err)
+			}
+
+			err = h.truncate(int64(req.Size))
+			if err == nil {
+				err = h.Release(ctx, nil)
+			} else {
+				h.Release(ctx, nil)
+			}
+			if err != nil {
+				return fuseError(err)
+			}
+		}
+	}
+
+	return f.Attr(ctx, &resp.Attr)
+}
+
+func (f *mountFile) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	return nil
+}
+
+// readHandle reads the ranges of a file requested by the kernel.
+type readHandle struct {
+	f *mountFile
+}
+
+func (h *readHandle) Read(
+	ctx context.Context,
+	req *fuse.ReadRequest,
+	resp *fuse.ReadResponse,
+) error {
+	data, err := h.f.b.read(ctx, h.f.key, req.Offset, req.Size)
+	if err != nil {
+		return fuseError(err)
+	}
+	resp.Data = data
+	return nil
+}
+
+// writeHandle buffers the content of a file in a temporary file, which is
+// uploaded as a whole once it is flushed.
+type writeHandle struct {
+	f *mountFile
+
+	mu    sync.Mutex
+	tmp   *os.File
+	dirty bool
+}
+
+// newWriteHandle buffers the content of f, unless it is truncated.
+func newWriteHandle(ctx context.Context, f *mountFile, truncate bool) (*writeHandle, error) {
+	tmp, err := ioutil.TempFile("", Program+"-mount-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmp.Name())
+
+	h := &writeHandle{f: f, tmp: tmp, dirty: truncate}
+
+	if !truncate {
+		f.mu.Lock()
+		size := f.size
+		f.mu.Unlock()
+
+		for offset := int64(0); offset < size; {
+			data, err := f.b.read(ctx, f.key, offset, copyChunk)
+			if err != nil {
+				tmp.Close()
+				return nil, err
+			}
+			if len(data) == 0 {
+				break
+			}
+
+			_, err = tmp.WriteAt(data, offset)
+			if err != nil {
+				tmp.Close()
+				return nil, err
+			}
+			offset += int64(len(data))
+		}
+	}
+
+	return h, nil
+}
+
+// copyChunk is the size of the ranges a file is downloaded in.
+const copyChunk = 4 << 20
+
+func (h *writeHandle) Read(
+	ctx context.Context,
+	req *fuse.ReadRequest,
+	resp *fuse.ReadResponse,
+) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buf := make([]byte, req.Size)
+
+	n, err := h.tmp.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return fuseError(err)
+	}
+	resp.Data = buf[:n]
+
+	return nil
+}
+
+func (h *writeHandle) Write(
+	ctx context.Context,
+	req *fuse.WriteRequest,
+	resp *fuse.WriteResponse,
+) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n, err := h.tmp.WriteAt(req.Data, req.Offset)
+	if err != nil {
+		return fuseError(err)
+	}
+	resp.Size = n
+	h.dirty = true
+
+	return nil
+}
+
+func (h *writeHandle) truncate(size int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirty = true
+
+	return h.tmp.Truncate(size)
+}
+
+func (h *writeHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	return fuseError(h.flush(ctx, false))
+}
+
+func (h *writeHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	err := h.flush(ctx, false)
+	h.tmp.Close()
+
+	h.f.mu.Lock()
+	if h.f.writer == h {
+		h.f.writer = nil
+	}
+	h.f.mu.Unlock()
+
+	return fuseError(err)
+}
+
+// flush uploads the content of the file if it was changed or force is set.
+func (h *writeHandle) flush(ctx context.Context, force bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.dirty && !force {
+		return nil
+	}
+
+	stat, err := h.tmp.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = h.f.b.write(ctx, h.f.key, io.NewSectionReader(h.tmp, 0, stat.Size()), stat.Size())
+	if err != nil {
+		return err
+	}
+	h.dirty = false
+
+	h.f.mu.Lock()
+	h.f.size = stat.Size()
+	h.f.modified = time.Now()
+	h.f.mu.Unlock()
+
+	return nil
+}
+
+// fuseError translates the errors of ent into errnos reported to the
+// kernel.
+func fuseError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch ent.KindOf(err) {
+	case ent.KindNotFound:
+		return fuse.ENOENT
+	case ent.KindUnauthorized, ent.KindForbidden:
+		return fuse.EPERM
+	case ent.KindQuotaExceeded:
+		return fuse.Errno(syscall.ENOSPC)
+	case ent.KindInvalid:
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	log.Printf("mount: %s", err)
+
+	return fuse.EIO
+}
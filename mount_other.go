@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"github.com/soundcloud/ent/lib"
+)
+
+// mountBucket is not supported on platforms without FUSE.
+func mountBucket(dir string, b *remoteBucket) error {
+	return ent.NewError(ent.KindUnsupported, "mount not supported on this platform")
+}
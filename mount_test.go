@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/ent/lib"
+	"github.com/soundcloud/ent/server"
+)
+
+func TestRemoteBucket(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-remote-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("mounted", ent.Owner{})
+	)
+
+	policy, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, b.Name+".entpolicy"), policy, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := server.NewServer(server.Config{
+		ProviderDir: tmp,
+		FSRoot:      filepath.Join(tmp, "fs"),
+		Keys:        []string{"key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	rb := &remoteBucket{
+		base:   ts.URL,
+		bucket: b.Name,
+		token:  "key",
+		client: http.DefaultClient,
+	}
+
+	content := "0123456789"
+
+	err = rb.write(ctx, "dir/file.txt", strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, _, err := rb.stat(ctx, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int64(len(content)), size; want != got {
+		t.Errorf("want size %d, got %d", want, got)
+	}
+
+	keys, err := rb.list(ctx, "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"dir/file.txt"}, keys; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	for _, test := range []struct {
+		offset int64
+		size   int
+		want   string
+	}{
+		{0, 4, "0123"},
+		{8, 4, "89"},
+		{10, 4, ""},
+	} {
+		data, err := rb.read(ctx, "dir/file.txt", test.offset, test.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data); test.want != got {
+			t.Errorf("read %d at %d: want %q, got %q", test.size, test.offset, test.want, got)
+		}
+	}
+
+	err = rb.remove(ctx, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = rb.stat(ctx, "dir/file.txt")
+	if !ent.IsFileNotFound(err) {
+		t.Errorf("want %v, got %v", ent.ErrFileNotFound, err)
+	}
+
+	rb.token = "wrong"
+
+	_, err = rb.list(ctx, "")
+	if want, got := ent.KindUnauthorized, ent.KindOf(err); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
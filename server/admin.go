@@ -0,0 +1,573 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	routeAdmin        = "/_admin/"
+	routeAdminBuckets = "/_admin/api/buckets"
+	routeAdminDelete  = "/_admin/api/delete"
+	routeAdminUploads = "/_admin/api/uploads"
+
+	paramTenant = "tenant"
+)
+
+// A namespace is a collection of buckets and the FileSystem their files are
+// stored in. Without tenants there is a single namespace without name.
+type namespace struct {
+	name string
+	keys []string
+	p    ent.Provider
+	fs   ent.FileSystem
+}
+
+// registerAdminRoutes adds the admin dashboard and the API backing it for
+// the buckets of all spaces to the listeners of RoleAdmin. The dashboard
+// shows the replication to ps, if any, and the resumable uploads expiring
+// after resumeTTL next to the buckets. All routes require one of keys to be
+// presented, so they must not be registered without keys.
+func registerAdminRoutes(
+	ls listeners,
+	keys []string,
+	spaces []namespace,
+	uploads *uploadLog,
+	ps *peers,
+	resumeTTL time.Duration,
+) {
+	routes := []Route{
+		{Method: "GET", Path: routeAdminBuckets, Op: "handleAdminBucketList", Handler: handleAdminBucketList(spaces)},
+		{Method: "POST", Path: routeAdminBuckets, Op: "handleAdminCreateBucket", Handler: handleAdminCreateBucket(spaces)},
+		{Method: "POST", Path: routeAdminDelete, Op: "handleAdminDelete", Handler: handleAdminDelete(spaces)},
+		{Method: "GET", Path: routeAdminUploads, Op: "handleAdminUploads", Handler: handleAdminUploads(uploads)},
+		{Method: "GET", Path: routeAdmin, Op: "handleAdminDashboard", Handler: handleAdminDashboard(spaces, uploads, ps, resumeTTL)},
+	}
+
+	for i := range routes {
+		routes[i].Keys = keys
+		routes[i].role = RoleAdmin
+	}
+
+	ls.register(routes...)
+}
+
+func handleAdminBucketList(spaces []namespace) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		usage, err := bucketUsage(r.Context(), spaces, true)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseBucketUsage{
+			Count:    len(usage),
+			Duration: time.Since(start),
+			Buckets:  knownUsage(usage),
+		})
+	}
+}
+
+// adminBucketRequest is the payload of a bucket creation, either as JSON or
+// as form of the dashboard.
+type adminBucketRequest struct {
+	Tenant string    `json:"tenant"`
+	Name   string    `json:"name"`
+	Owner  ent.Owner `json:"owner"`
+}
+
+func handleAdminCreateBucket(spaces []namespace) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer r.Body.Close()
+
+		req := adminBucketRequest{}
+
+		if isJSON(r) {
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				respondError(w, r, ent.ErrInvalidParam)
+				return
+			}
+		} else {
+			err := checkOrigin(r)
+			if err != nil {
+				respondError(w, r, err)
+				return
+			}
+
+			req.Tenant = r.PostFormValue(paramTenant)
+			req.Name = r.PostFormValue("name")
+			req.Owner.Email = mail.Address{
+				Name:    r.PostFormValue("owner_name"),
+				Address: r.PostFormValue("owner_email"),
+			}
+		}
+
+		if req.Owner.Email.Address == "" {
+			respondError(w, r, ent.ErrInvalidParam)
+			return
+		}
+
+		ns, err := findNamespace(spaces, req.Tenant)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		bc, ok := ns.p.(ent.BucketCreator)
+		if !ok {
+			respondError(w, r, ent.ErrBucketCreationUnsupported)
+			return
+		}
+
+		b := ent.NewBucket(req.Name, req.Owner)
+
+		err = bc.CreateBucket(r.Context(), b)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		if redirectToDashboard(w, r) {
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, ent.ResponseBucket{
+			Duration: time.Since(start),
+			Bucket:   b,
+		})
+	}
+}
+
+func handleAdminDelete(spaces []namespace) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer r.Body.Close()
+
+		var req struct {
+			Tenant string `json:"tenant"`
+			Bucket string `json:"bucket"`
+			Key    string `json:"key"`
+		}
+
+		if isJSON(r) {
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				respondError(w, r, ent.ErrInvalidParam)
+				return
+			}
+		} else {
+			err := checkOrigin(r)
+			if err != nil {
+				respondError(w, r, err)
+				return
+			}
+
+			req.Tenant = r.PostFormValue(paramTenant)
+			req.Bucket = r.PostFormValue("bucket")
+			req.Key = r.PostFormValue("key")
+		}
+
+		err := validKey(req.Key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		ns, err := findNamespace(spaces, req.Tenant)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		b, err := ns.p.Get(r.Context(), req.Bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		err = ns.fs.Delete(r.Context(), b, req.Key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		if redirectToDashboard(w, r) {
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseDeleted{
+			Duration: time.Since(start),
+			File: ent.ResponseFile{
+				Bucket: b,
+				Key:    req.Key,
+			},
+		})
+	}
+}
+
+func handleAdminUploads(uploads *uploadLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		us := uploads.list()
+
+		respondJSON(w, http.StatusOK, ent.ResponseUploads{
+			Count:   len(us),
+			Uploads: us,
+		})
+	}
+}
+
+func handleAdminDashboard(
+	spaces []namespace,
+	uploads *uploadLog,
+	ps *peers,
+	resumeTTL time.Duration,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Buckets are only listed by the API, loading the dashboard mustn't
+		// read the whole disk while usage isn't tracked.
+		usage, err := bucketUsage(r.Context(), spaces, false)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		var (
+			tenants = []string{}
+			pending = []pendingUsage{}
+		)
+		for _, ns := range spaces {
+			tenants = append(tenants, ns.name)
+
+			if rc, ok := recovererOf(ns.fs); ok {
+				_, total := rc.pendingUploads("")
+				pending = append(pending, pendingUsage{Tenant: ns.name, Size: total})
+			}
+		}
+
+		var (
+			peerURLs   []string
+			peerMisses int
+		)
+		if ps != nil {
+			peerURLs = ps.urls
+			peerMisses = ps.missingCount()
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		err = dashboardTemplate.Execute(w, struct {
+			Tenants    []string
+			Buckets    []trackedUsage
+			Uploads    []ent.Upload
+			Peers      []string
+			PeerMisses int
+			Pending    []pendingUsage
+			ResumeTTL  time.Duration
+		}{
+			Tenants:    tenants,
+			Buckets:    usage,
+			Uploads:    uploads.list(),
+			Peers:      peerURLs,
+			PeerMisses: peerMisses,
+			Pending:    pending,
+			ResumeTTL:  resumeTTL,
+		})
+		if err != nil {
+			log.Printf("rendering dashboard failed: %s", err)
+		}
+	}
+}
+
+// trackedUsage is the usage of a bucket, which is unknown unless it is
+// tracked by a usageFS or the bucket was listed.
+type trackedUsage struct {
+	ent.BucketUsage
+	Known bool
+}
+
+// pendingUsage is the size of the data received by the resumable uploads of
+// a tenant in progress.
+type pendingUsage struct {
+	Tenant string
+	Size   int64
+}
+
+// bucketUsage returns the number and total size of the files of all buckets
+// of spaces, as tracked by their usageFS or, if list is set, by listing them
+// otherwise.
+func bucketUsage(ctx context.Context, spaces []namespace, list bool) ([]trackedUsage, error) {
+	usage := []trackedUsage{}
+
+	for _, ns := range spaces {
+		bs, err := ns.p.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(bs, func(i, j int) bool { return bs[i].Name < bs[j].Name })
+
+		tracked, ok := usageOf(ns.fs)
+
+		for _, b := range bs {
+			if ok {
+				if u, scanned := tracked.usage(b); scanned {
+					usage = append(usage, trackedUsage{BucketUsage: u, Known: true})
+					continue
+				}
+			}
+
+			u := trackedUsage{
+				BucketUsage: ent.BucketUsage{
+					Tenant: ns.name,
+					Bucket: b,
+				},
+				Known: list,
+			}
+
+			if list {
+				files, err := ns.fs.List(ctx, b, "", defaultLimit, ent.NoOpStrategy())
+				if err != nil {
+					return nil, err
+				}
+
+				u.Files = len(files)
+
+				for i, f := range files {
+					size, err := fileSize(f)
+					f.Close()
+					if err != nil {
+						for _, f := range files[i+1:] {
+							f.Close()
+						}
+						return nil, err
+					}
+					u.Size += size
+				}
+			}
+
+			usage = append(usage, u)
+		}
+	}
+
+	return usage, nil
+}
+
+// knownUsage returns the usage of the buckets whose usage is known.
+func knownUsage(usage []trackedUsage) []ent.BucketUsage {
+	known := []ent.BucketUsage{}
+	for _, u := range usage {
+		if u.Known {
+			known = append(known, u.BucketUsage)
+		}
+	}
+	return known
+}
+
+func findNamespace(spaces []namespace, name string) (namespace, error) {
+	for _, ns := range spaces {
+		if ns.name == name {
+			return ns, nil
+		}
+	}
+	return namespace{}, ent.ErrInvalidParam
+}
+
+// isJSON reports if the body of r is JSON rather than a form.
+func isJSON(r *http.Request) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == "application/json"
+}
+
+// checkOrigin rejects forms posted from other sites, which browsers would
+// send along with the credentials of the admin.
+func checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return ent.ErrForbidden
+	}
+
+	return nil
+}
+
+// redirectToDashboard sends browsers, which submitted a form of the
+// dashboard, back to it.
+func redirectToDashboard(w http.ResponseWriter, r *http.Request) bool {
+	if !wantsHTML(r) {
+		return false
+	}
+
+	http.Redirect(w, r, routeAdmin, http.StatusSeeOther)
+	return true
+}
+
+// uploadLog keeps the most recent uploads in a ring buffer.
+type uploadLog struct {
+	mu      sync.Mutex
+	uploads []ent.Upload
+	next    int
+	full    bool
+}
+
+func newUploadLog(size int) *uploadLog {
+	return &uploadLog{
+		uploads: make([]ent.Upload, size),
+	}
+}
+
+func (l *uploadLog) add(u ent.Upload) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.uploads) == 0 {
+		return
+	}
+
+	l.uploads[l.next] = u
+	l.next = (l.next + 1) % len(l.uploads)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the uploads, the most recent first.
+func (l *uploadLog) list() []ent.Upload {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.uploads)
+	}
+
+	us := make([]ent.Upload, 0, n)
+	for i := 1; i <= n; i++ {
+		us = append(us, l.uploads[(l.next-i+len(l.uploads))%len(l.uploads)])
+	}
+
+	return us
+}
+
+// recordFS adds all files stored in the FileSystem of a namespace to an
+// uploadLog.
+type recordFS struct {
+	ent.FileSystem
+
+	tenant  string
+	uploads *uploadLog
+}
+
+func (fs *recordFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *recordFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	f, err := fs.FileSystem.Create(ctx, bucket, key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := fileSize(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fs.uploads.add(ent.Upload{
+		Tenant:  fs.tenant,
+		Bucket:  bucket.Name,
+		Key:     key,
+		Size:    size,
+		Created: time.Now(),
+	})
+
+	return f, nil
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ent admin</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.2em 1em; text-align: left; }
+td.num { text-align: right; }
+form { margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<h1>ent admin</h1>
+
+<h2>Buckets</h2>
+<table>
+<tr><th>Tenant</th><th>Bucket</th><th>Owner</th><th>Files</th><th>Size</th><th>Quota</th></tr>
+{{range .Buckets}}<tr><td>{{.Tenant}}</td><td>{{.Bucket.Name}}</td><td>{{.Bucket.Owner.Email.Address}}</td>{{if .Known}}<td class="num">{{.Files}}</td><td class="num">{{.Size}}</td>{{else}}<td class="num">unknown</td><td class="num">unknown</td>{{end}}<td class="num">{{if .Bucket.Quota}}{{.Bucket.Quota}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Replication</h2>
+{{if .Peers}}<table>
+<tr><th>Peer</th></tr>
+{{range .Peers}}<tr><td>{{.}}</td></tr>
+{{end}}</table>
+<p>{{.PeerMisses}} keys missing on all peers are not asked for again yet.</p>
+{{else}}<p>No peers configured.</p>
+{{end}}
+<h2>Resumable uploads</h2>
+<table>
+<tr><th>Tenant</th><th>Pending</th></tr>
+{{range .Pending}}<tr><td>{{.Tenant}}</td><td class="num">{{.Size}}</td></tr>
+{{end}}</table>
+<p>{{if .ResumeTTL}}Uploads not continued within {{.ResumeTTL}} are removed.{{else}}Uploads are never removed.{{end}}</p>
+
+<h2>Create bucket</h2>
+<form method="post" action="/_admin/api/buckets">
+{{if index .Tenants 0}}<select name="tenant">{{range .Tenants}}<option>{{.}}</option>{{end}}</select>{{end}}
+<input name="name" placeholder="name" required>
+<input name="owner_name" placeholder="owner name">
+<input name="owner_email" type="email" placeholder="owner email" required>
+<button type="submit">Create</button>
+</form>
+
+<h2>Delete key</h2>
+<form method="post" action="/_admin/api/delete">
+{{if index .Tenants 0}}<select name="tenant">{{range .Tenants}}<option>{{.}}</option>{{end}}</select>{{end}}
+<input name="bucket" placeholder="bucket" required>
+<input name="key" placeholder="key" required>
+<button type="submit">Delete</button>
+</form>
+
+<h2>Recent uploads</h2>
+<table>
+<tr><th>Time</th><th>Tenant</th><th>Bucket</th><th>Key</th><th>Size</th></tr>
+{{range .Uploads}}<tr><td>{{.Created.Format "2006-01-02 15:04:05 MST"}}</td><td>{{.Tenant}}</td><td>{{.Bucket}}</td><td>{{.Key}}</td><td class="num">{{.Size}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestAdmin(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	p, err := newDiskProvider(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		uploads = newUploadLog(10)
+		fs      = &recordFS{FileSystem: newDiskFS(tmp), uploads: uploads}
+		r       = pat.New()
+	)
+
+	registerAdminRoutes(
+		testListeners(t, r),
+		[]string{"secret"},
+		[]namespace{{p: p, fs: fs}},
+		uploads,
+		newPeers([]string{"http://peer.example"}, time.Second, time.Minute),
+		time.Hour,
+	)
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(httptest.NewRequest("GET", routeAdminBuckets, nil))
+	if want, got := http.StatusUnauthorized, w.Code; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	for name, want := range map[string]int{
+		"assets": http.StatusCreated,
+		"_admin": http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest("POST", routeAdminBuckets, strings.NewReader(
+			`{"name": "`+name+`", "owner": {"email": {"address": "team@bucket.io"}}}`,
+		))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/json")
+
+		if got := do(req).Code; want != got {
+			t.Errorf("%s: want %d, got %d", name, want, got)
+		}
+	}
+
+	// Buckets created through the admin are served right away and persist.
+	b, err := p.Get(context.Background(), "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp + "/assets" + policyExt); err != nil {
+		t.Error(err)
+	}
+
+	f, err := fs.Create(context.Background(), b, "logo.png", bytes.NewBufferString("logo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	req := httptest.NewRequest("GET", routeAdminBuckets, nil)
+	req.SetBasicAuth("admin", "secret")
+
+	resp := ent.ResponseBucketUsage{}
+	err = json.NewDecoder(do(req).Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, resp.Count; want != got {
+		t.Fatalf("want %d buckets, got %d", want, got)
+	}
+	if want, got := int64(4), resp.Buckets[0].Size; want != got {
+		t.Errorf("want size %d, got %d", want, got)
+	}
+
+	req = httptest.NewRequest("GET", routeAdmin, nil)
+	req.SetBasicAuth("admin", "secret")
+
+	// Without tracked usage the dashboard doesn't list the buckets.
+	w = do(req)
+	for _, want := range []string{"assets", "team@bucket.io", "logo.png", "unknown", "http://peer.example", "1h0m0s"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("dashboard misses %s", want)
+		}
+	}
+
+	// Keys escaping the bucket are rejected.
+	req = httptest.NewRequest("POST", routeAdminDelete, strings.NewReader(
+		`{"bucket": "assets", "key": "../assets`+policyExt+`"}`,
+	))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+
+	if want, got := http.StatusBadRequest, do(req).Code; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if _, err := os.Stat(tmp + "/assets" + policyExt); err != nil {
+		t.Error(err)
+	}
+
+	form := url.Values{"bucket": {"assets"}, "key": {"logo.png"}}.Encode()
+
+	// Forms posted by other sites are rejected.
+	req = httptest.NewRequest("POST", routeAdminDelete, strings.NewReader(form))
+	req.SetBasicAuth("admin", "secret")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://evil.example")
+
+	if want, got := http.StatusForbidden, do(req).Code; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	req = httptest.NewRequest("POST", routeAdminDelete, strings.NewReader(form))
+	req.SetBasicAuth("admin", "secret")
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if want, got := http.StatusSeeOther, do(req).Code; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if _, err := fs.Open(context.Background(), b, "logo.png"); !ent.IsFileNotFound(err) {
+		t.Errorf("want %v, got %v", ent.ErrFileNotFound, err)
+	}
+}
+
+func TestUploadLog(t *testing.T) {
+	l := newUploadLog(2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		l.add(ent.Upload{Key: key})
+	}
+
+	us := l.list()
+	if want, got := 2, len(us); want != got {
+		t.Fatalf("want %d uploads, got %d", want, got)
+	}
+	if want, got := "c", us[0].Key; want != got {
+		t.Errorf("want most recent %s, got %s", want, got)
+	}
+	if want, got := "b", us[1].Key; want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	routeExport = "/_export/{bucket}"
+	routeImport = "/_import/{bucket}"
+
+	archiveManifest = "manifest.json"
+	archiveObjects  = "objects/"
+)
+
+func handleExport(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bucket := r.URL.Query().Get(keyBucket)
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		var (
+			tw = tar.NewWriter(w)
+			m  = &ent.Manifest{
+				Bucket:  b,
+				Created: time.Now(),
+				Files:   []ent.ManifestFile{},
+			}
+			started = false
+		)
+
+		begin := func() {
+			if started {
+				return
+			}
+			started = true
+
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.Header().Set(
+				"Content-Disposition",
+				fmt.Sprintf("attachment; filename=%q", b.Name+".tar"),
+			)
+			w.WriteHeader(http.StatusOK)
+		}
+
+		// Every file is archived as soon as it is found and closed before
+		// the next one, its checksum is computed while it is written.
+		write := func(f ent.File) error {
+			defer f.Close()
+
+			size, err := fileSize(f)
+			if ent.IsFileNotFound(err) {
+				// Removed since it was found.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			begin()
+
+			mf, err := writeObject(tw, f, size)
+			if err != nil {
+				return err
+			}
+			m.Files = append(m.Files, mf)
+
+			return nil
+		}
+
+		if wk, ok := walkerOf(fs); ok {
+			err = wk.Walk(r.Context(), b, "", write)
+		} else {
+			err = writeFiles(r.Context(), fs, b, "", "", defaultLimit, ent.NoOpStrategy(), write)
+		}
+		if err != nil && !started {
+			respondError(w, r, err)
+			return
+		}
+		begin()
+
+		// Once the archive started streaming the status is already sent, the
+		// client detects a broken export by the truncated tar stream without
+		// a manifest.
+		if err == nil {
+			err = writeManifest(tw, m)
+		}
+		if err != nil {
+			log.Printf("export of %s failed: %s", b.Name, err)
+		}
+	}
+}
+
+func handleImport(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		existing, err := fs.List(r.Context(), b, "", 1, ent.NoOpStrategy())
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		for _, file := range existing {
+			file.Close()
+		}
+		if len(existing) > 0 {
+			respondError(w, r, ent.ErrBucketNotEmpty)
+			return
+		}
+
+		responseFiles, err := readArchive(r.Context(), fs, b, r.Body)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, ent.ResponseImported{
+			Count:    len(responseFiles),
+			Duration: time.Since(start),
+			Bucket:   b,
+			Files:    responseFiles,
+		})
+	}
+}
+
+// writeObject writes f of size to the archive and returns its entry of the manifest.
+func writeObject(tw *tar.Writer, f ent.File, size int64) (ent.ManifestFile, error) {
+	mf := ent.ManifestFile{
+		Key:          f.Key(),
+		LastModified: f.LastModified(),
+		Size:         size,
+	}
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:    archiveObjects + mf.Key,
+		Mode:    0644,
+		Size:    mf.Size,
+		ModTime: mf.LastModified,
+	})
+	if err != nil {
+		return ent.ManifestFile{}, err
+	}
+
+	h := sha1.New()
+
+	_, err = copyBuffer(
+		"handleExport",
+		io.MultiWriter(tw, h),
+		io.LimitReader(f, mf.Size),
+		mf.Size,
+	)
+	if err != nil {
+		return ent.ManifestFile{}, fmt.Errorf("archiving %s: %w", mf.Key, err)
+	}
+
+	mf.SHA1 = hex.EncodeToString(h.Sum(nil))
+
+	return mf, nil
+}
+
+// writeManifest ends the archive with the manifest of all objects written.
+func writeManifest(tw *tar.Writer, m *ent.Manifest) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    archiveManifest,
+		Mode:    0644,
+		Size:    int64(len(raw)),
+		ModTime: m.Created,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(raw)
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// readArchive restores all objects of the archive read from r into the
+// bucket. The objects are staged in temporary files and verified against the
+// checksums recorded in the manifest, which is expected either first or, as
+// written by exports, last. Only a complete and valid archive is stored in
+// the bucket, so a broken archive never leaves a partially restored bucket
+// behind.
+func readArchive(
+	ctx context.Context,
+	fs ent.FileSystem,
+	b *ent.Bucket,
+	r io.Reader,
+) ([]ent.ResponseFile, error) {
+	dir, err := ioutil.TempDir("", pendingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	objects, err := stageArchive(ctx, dir, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// An archive exceeding the quotas is refused as a whole instead of
+	// failing halfway through storing it.
+	if q, ok := quotaOf(fs); ok {
+		size := int64(0)
+		for _, o := range objects {
+			size += o.size
+		}
+
+		q.mu.Lock()
+		fits := q.fits(b, size)
+		q.mu.Unlock()
+
+		if !fits {
+			return nil, ent.ErrQuotaExceeded
+		}
+	}
+
+	responseFiles := []ent.ResponseFile{}
+
+	for _, o := range objects {
+		f, err := os.Open(o.path)
+		if err == nil {
+			var file ent.File
+			file, err = fs.Create(ctx, b, o.key, &sizedReader{
+				Reader: f,
+				op:     "handleImport",
+				size:   o.size,
+			})
+			f.Close()
+			if err == nil {
+				responseFiles = append(responseFiles, ent.ResponseFile{
+					Key:          o.key,
+					LastModified: file.LastModified(),
+					Bucket:       b,
+				})
+				file.Close()
+			}
+		}
+		if err != nil {
+			recordAbort("handleImport", err)
+			rollbackArchive(fs, b, responseFiles)
+			return nil, err
+		}
+	}
+
+	return responseFiles, nil
+}
+
+// stagedObject is an object of an archive stored in a temporary file.
+type stagedObject struct {
+	key  string
+	path string
+	sha1 string
+	size int64
+}
+
+// stageArchive stores the objects of the archive read from r in dir and
+// verifies them against its manifest.
+func stageArchive(ctx context.Context, dir string, r io.Reader) ([]stagedObject, error) {
+	var (
+		tr       = tar.NewReader(r)
+		manifest *ent.Manifest
+		objects  = []stagedObject{}
+		seen     = map[string]bool{}
+	)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ent.ErrInvalidArchive
+		}
+
+		if hdr.Name == archiveManifest {
+			if manifest != nil {
+				return nil, ent.ErrInvalidArchive
+			}
+			manifest = &ent.Manifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return nil, ent.ErrInvalidArchive
+			}
+			continue
+		}
+
+		key := strings.TrimPrefix(hdr.Name, archiveObjects)
+		if key == hdr.Name || seen[key] {
+			return nil, ent.ErrInvalidArchive
+		}
+		seen[key] = true
+
+		// Entry names aren't cleaned like URL paths, so objects/../x
+		// would escape the bucket.
+		err = validKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		o, err := stageObject(ctx, dir, key, tr, hdr.Size)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+
+	if manifest == nil {
+		return nil, ent.ErrInvalidArchive
+	}
+
+	pending := map[string]ent.ManifestFile{}
+	for _, mf := range manifest.Files {
+		pending[mf.Key] = mf
+	}
+
+	for _, o := range objects {
+		mf, ok := pending[o.key]
+		if !ok {
+			return nil, ent.ErrInvalidArchive
+		}
+		delete(pending, o.key)
+
+		if o.sha1 != mf.SHA1 {
+			return nil, ent.ErrChecksumMismatch
+		}
+	}
+
+	if len(pending) > 0 {
+		return nil, ent.ErrIncompleteArchive
+	}
+
+	return objects, nil
+}
+
+// stageObject stores the object of key read from r in a temporary file in
+// dir.
+func stageObject(
+	ctx context.Context,
+	dir string,
+	key string,
+	r io.Reader,
+	size int64,
+) (stagedObject, error) {
+	tmp, err := ioutil.TempFile(dir, pendingPrefix)
+	if err != nil {
+		return stagedObject{}, err
+	}
+	defer tmp.Close()
+
+	h := sha1.New()
+
+	n, err := io.Copy(&ctxWriter{ctx: ctx, w: io.MultiWriter(tmp, h)}, &sizedReader{
+		Reader: r,
+		op:     "handleImport",
+		size:   size,
+	})
+	if err != nil {
+		recordAbort("handleImport", err)
+		return stagedObject{}, err
+	}
+
+	return stagedObject{
+		key:  key,
+		path: tmp.Name(),
+		sha1: hex.EncodeToString(h.Sum(nil)),
+		size: n,
+	}, tmp.Close()
+}
+
+// rollbackArchive removes the files of an import failing while storing the
+// verified objects.
+func rollbackArchive(fs ent.FileSystem, b *ent.Bucket, files []ent.ResponseFile) {
+	for _, f := range files {
+		// The rollback has to happen even if the request was cancelled.
+		err := fs.Delete(context.Background(), b, f.Key)
+		if err != nil {
+			log.Printf("rollback of %s/%s failed: %s", b.Name, f.Key, err)
+		}
+	}
+}
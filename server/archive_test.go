@@ -0,0 +1,322 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestHandleExportImport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		src  = ent.NewBucket("src", ent.Owner{})
+		dst  = ent.NewBucket("dst", ent.Owner{})
+		fs   = newDiskFS(tmp)
+		p    = newMockProvider(src, dst)
+		r    = pat.New()
+		keys = []string{"a.txt", "nested/b.txt", "nested/deeper/c.txt"}
+	)
+
+	for _, key := range keys {
+		f, err := fs.Create(context.Background(), src, key, bytes.NewBufferString("content of "+key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	r.Get(routeExport, handleExport(p, fs))
+	r.Post(routeImport, handleImport(p, fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/_export/%s", ts.URL, src.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	// The manifest is written last, once every object was archived.
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	if want, got := len(keys)+1, len(names); want != got {
+		t.Fatalf("want %d entries, got %d", want, got)
+	}
+	if want, got := archiveManifest, names[len(names)-1]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	res, err = http.Post(
+		fmt.Sprintf("%s/_import/%s", ts.URL, dst.Name),
+		"application/x-tar",
+		bytes.NewReader(archive),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	resp := ent.ResponseImported{}
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := len(keys), resp.Count; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	for _, key := range keys {
+		f, err := fs.Open(context.Background(), dst, key)
+		if err != nil {
+			t.Fatalf("open %s: %s", key, err)
+		}
+		raw, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "content of "+key, string(raw); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+
+	// A second restore must not clobber the now populated bucket.
+	res, err = http.Post(
+		fmt.Sprintf("%s/_import/%s", ts.URL, dst.Name),
+		"application/x-tar",
+		bytes.NewReader(archive),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if want, got := http.StatusConflict, res.StatusCode; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
+
+func TestReadArchiveChecksumMismatch(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-archive-mismatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b   = ent.NewBucket("restore", ent.Owner{})
+		fs  = newDiskFS(tmp)
+		buf = &bytes.Buffer{}
+		tw  = tar.NewWriter(buf)
+		m   = ent.Manifest{
+			Bucket: b,
+			Files: []ent.ManifestFile{
+				{Key: "broken", SHA1: "0000000000000000000000000000000000000000", Size: 4},
+			},
+		}
+	)
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{archiveManifest, raw},
+		{archiveObjects + "broken", []byte("data")},
+	} {
+		err = tw.WriteHeader(&tar.Header{
+			Name: entry.name,
+			Mode: 0644,
+			Size: int64(len(entry.data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write(entry.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readArchive(context.Background(), fs, b, buf)
+	if want, got := ent.ErrChecksumMismatch, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if _, err := fs.Open(context.Background(), b, "broken"); !ent.IsFileNotFound(err) {
+		t.Errorf("want %v, got %v", ent.ErrFileNotFound, err)
+	}
+}
+
+func TestReadArchiveTraversal(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-archive-traversal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b    = ent.NewBucket("restore", ent.Owner{})
+		fs   = newDiskFS(filepath.Join(tmp, "root"))
+		data = []byte("data")
+		sum  = sha1.Sum(data)
+	)
+
+	for _, key := range []string{"../../escaped", "nested/../../escaped", "/escaped", "a//b"} {
+		var (
+			buf = &bytes.Buffer{}
+			tw  = tar.NewWriter(buf)
+			m   = ent.Manifest{
+				Bucket: b,
+				Files:  []ent.ManifestFile{{Key: key, SHA1: hex.EncodeToString(sum[:]), Size: 4}},
+			}
+		)
+
+		raw, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, entry := range []struct {
+			name string
+			data []byte
+		}{
+			{archiveManifest, raw},
+			{archiveObjects + key, data},
+		} {
+			err = tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tw.Write(entry.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = tw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = readArchive(context.Background(), fs, b, buf)
+		if !errors.Is(err, ent.ErrInvalidKey) {
+			t.Errorf("%s: want %v, got %v", key, ent.ErrInvalidKey, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(tmp, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("want no file outside the bucket, got %v", err)
+	}
+}
+
+func TestReadArchiveRetainedBucket(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-archive-retained")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b    = ent.NewBucket("restore", ent.Owner{})
+		fs   = newDiskFS(tmp)
+		buf  = &bytes.Buffer{}
+		tw   = tar.NewWriter(buf)
+		data = []byte("data")
+		sum  = sha1.Sum(data)
+		m    = ent.Manifest{
+			Bucket: b,
+			Files: []ent.ManifestFile{
+				{Key: "valid", SHA1: hex.EncodeToString(sum[:]), Size: 4},
+				{Key: "broken", SHA1: "0000000000000000000000000000000000000000", Size: 4},
+			},
+		}
+	)
+	// Files of the bucket can't be removed once stored.
+	b.Retention = 3600
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{archiveObjects + "valid", data},
+		{archiveObjects + "broken", data},
+		{archiveManifest, raw},
+	} {
+		err = tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write(entry.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readArchive(context.Background(), fs, b, buf)
+	if want, got := ent.ErrChecksumMismatch, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	for _, key := range []string{"valid", "broken"} {
+		if _, err := fs.Open(context.Background(), b, key); !ent.IsFileNotFound(err) {
+			t.Errorf("%s: want %v, got %v", key, ent.ErrFileNotFound, err)
+		}
+	}
+}
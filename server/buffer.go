@@ -0,0 +1,154 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const bufferAlignment = 4096
+
+// bufferClasses maps object sizes to the size of the buffer used to copy
+// them. Tiny objects don't need to pin large buffers while multi-GB streams
+// benefit from fewer, larger reads and writes. Objects of unknown size use the
+// same buffer size as io.Copy does.
+var bufferClasses = []struct {
+	limit int64
+	size  int
+	pool  *sync.Pool
+}{
+	{limit: 64 << 10, size: 4 << 10},
+	{limit: 8 << 20, size: 32 << 10},
+	{limit: 256 << 20, size: 256 << 10},
+	{limit: -1, size: 1 << 20},
+}
+
+var (
+	copyLabelNames = []string{"operation", "buffer"}
+
+	copyBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Program,
+			Name:      "copy_bytes_total",
+			Help:      "Total volume of object data copied in bytes.",
+		},
+		copyLabelNames,
+	)
+	copyDurations = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace: Program,
+			Name:      "copy_duration_nanoseconds",
+			Help:      "Amounts of time spent copying object data in nanoseconds.",
+		},
+		copyLabelNames,
+	)
+)
+
+func init() {
+	for i := range bufferClasses {
+		size := bufferClasses[i].size
+		bufferClasses[i].pool = &sync.Pool{
+			New: func() interface{} {
+				// Pointers are pooled, as putting a slice back would
+				// allocate its header every time.
+				buf := alignedBuffer(size)
+				return &buf
+			},
+		}
+	}
+}
+
+// bufferClass returns the index into bufferClasses for an object of the given
+// size. A negative size denotes an unknown size.
+func bufferClass(size int64) int {
+	if size < 0 {
+		return 1
+	}
+	for i, c := range bufferClasses {
+		if c.limit < 0 || size < c.limit {
+			return i
+		}
+	}
+	return len(bufferClasses) - 1
+}
+
+// alignedBuffer returns a buffer of the given size whose first byte is aligned
+// to page boundaries.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+bufferAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (bufferAlignment - 1)); rem != 0 {
+		offset = bufferAlignment - rem
+	}
+	return buf[offset : offset+size]
+}
+
+// copyBuffer copies from src to dst with a pooled buffer sized for an object
+// of size bytes and records the effect under the given operation.
+func copyBuffer(op string, dst io.Writer, src io.Reader, size int64) (int64, error) {
+	var (
+		c     = bufferClass(size)
+		buf   = bufferClasses[c].pool.Get().(*[]byte)
+		start = time.Now()
+	)
+	defer bufferClasses[c].pool.Put(buf)
+
+	// Hide io.ReaderFrom and io.WriterTo, otherwise io.CopyBuffer bypasses
+	// the buffer.
+	n, err := io.CopyBuffer(writerOnly{dst}, readerOnly{src}, *buf)
+
+	labels := prometheus.Labels{
+		"operation": op,
+		"buffer":    strconv.Itoa(bufferClasses[c].size),
+	}
+	copyBytes.With(labels).Add(float64(n))
+	copyDurations.With(labels).Observe(float64(time.Since(start)))
+
+	return n, err
+}
+
+// sizedReader passes the expected size of its data on to io.Copy by
+// implementing io.WriterTo.
+type sizedReader struct {
+	io.Reader
+	op   string
+	size int64
+}
+
+// Size returns the expected size of the data or -1 if it is unknown.
+func (r *sizedReader) Size() int64 {
+	return r.size
+}
+
+func (r *sizedReader) WriteTo(w io.Writer) (int64, error) {
+	return copyBuffer(r.op, w, r.Reader, r.size)
+}
+
+// sizedResponseWriter passes the size of the served object on to the copy
+// performed by http.ServeContent by implementing io.ReaderFrom.
+type sizedResponseWriter struct {
+	http.ResponseWriter
+	op   string
+	size int64
+}
+
+func (w *sizedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return copyBuffer(w.op, w.ResponseWriter, r, w.size)
+}
+
+func (w *sizedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type readerOnly struct {
+	io.Reader
+}
+
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+func TestBufferClass(t *testing.T) {
+	for _, input := range []struct {
+		size int64
+		want int
+	}{
+		{-1, 32 << 10},
+		{0, 4 << 10},
+		{1 << 10, 4 << 10},
+		{64 << 10, 32 << 10},
+		{100 << 20, 256 << 10},
+		{4 << 30, 1 << 20},
+	} {
+		if got := bufferClasses[bufferClass(input.size)].size; input.want != got {
+			t.Errorf("size %d: want buffer of %d, got %d", input.size, input.want, got)
+		}
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, size := range []int{4 << 10, 32 << 10, 1 << 20} {
+		buf := alignedBuffer(size)
+
+		if want, got := size, len(buf); want != got {
+			t.Errorf("want length %d, got %d", want, got)
+		}
+		if addr := uintptr(unsafe.Pointer(&buf[0])); addr%bufferAlignment != 0 {
+			t.Errorf("buffer of %d not aligned: %x", size, addr)
+		}
+	}
+}
+
+func TestCopyBuffer(t *testing.T) {
+	var (
+		src = bytes.Repeat([]byte("ent"), 100000)
+		dst = &bytes.Buffer{}
+	)
+
+	n, err := copyBuffer("test", dst, bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := int64(len(src)), n; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if !bytes.Equal(src, dst.Bytes()) {
+		t.Errorf("copied data differs")
+	}
+}
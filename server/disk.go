@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	routeReady = "/readyz"
+
+	// diskRefreshInterval is the interval the disk gauges are refreshed in,
+	// so they can be alerted on without anything asking for the free space.
+	diskRefreshInterval = 15 * time.Second
+)
+
+var (
+	errDiskFreeUnsupported = errors.New("measuring free disk space not supported on this platform")
+
+	diskFreeBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Program,
+			Name:      "disk_free_bytes",
+			Help:      "Bytes available on the filesystem of the FileSystem root.",
+		},
+	)
+	diskHeadroomBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Program,
+			Name:      "disk_headroom_bytes",
+			Help:      "Bytes available above the disk watermark, negative once below it.",
+		},
+	)
+)
+
+// handleReady reports if ent accepts uploads, which it doesn't once the free
+// space of its disk fell below the watermark wm. Without watermark ent is
+// always ready.
+func handleReady(wm *watermark) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wm == nil {
+			respondJSON(w, http.StatusOK, ent.ResponseReady{Ready: true})
+			return
+		}
+
+		free, headroom, err := wm.headroom()
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		code := http.StatusOK
+		if headroom < 0 {
+			code = http.StatusServiceUnavailable
+		}
+
+		respondJSON(w, code, ent.ResponseReady{
+			Ready:     headroom >= 0,
+			Free:      free,
+			Watermark: wm.min,
+			Headroom:  headroom,
+		})
+	}
+}
+
+// watermark protects the disk holding root from running full, rejecting
+// uploads once less than min bytes are left.
+type watermark struct {
+	root string
+	min  int64
+}
+
+// newWatermark keeps min bytes free on the disk holding root. It fails if the
+// free space can't be measured.
+func newWatermark(root string, min int64) (*watermark, error) {
+	wm := &watermark{root: root, min: min}
+
+	_, _, err := wm.headroom()
+	if err != nil {
+		return nil, err
+	}
+
+	return wm, nil
+}
+
+// run refreshes the disk gauges right away and every interval after, until
+// ctx is done.
+func (wm *watermark) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		_, _, err := wm.headroom()
+		if err != nil {
+			log.Printf("measuring free disk space failed: %s", err)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// headroom returns the free space of the disk and how much of it is above the
+// watermark.
+func (wm *watermark) headroom() (int64, int64, error) {
+	free, err := diskFree(wm.root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	diskFreeBytes.Set(float64(free))
+	diskHeadroomBytes.Set(float64(free - wm.min))
+
+	return free, free - wm.min, nil
+}
+
+// check fails with ent.ErrInsufficientStorage if storing n more bytes leaves
+// less free space than the watermark. Negative sizes are unknown and only
+// checked against the current free space.
+func (wm *watermark) check(n int64) error {
+	_, headroom, err := wm.headroom()
+	if err != nil {
+		return err
+	}
+
+	if n < 0 {
+		n = 0
+	}
+	if headroom-n < 0 {
+		return ent.ErrInsufficientStorage
+	}
+
+	return nil
+}
+
+// watermarkFS rejects new files while the disk is filled beyond its
+// watermark. Reads and deletes, which free space, are always passed on.
+type watermarkFS struct {
+	ent.FileSystem
+	wm *watermark
+}
+
+func (fs *watermarkFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *watermarkFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	size := int64(-1)
+	if s, ok := data.(sizer); ok {
+		size = s.Size()
+	}
+
+	err := fs.wm.check(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.FileSystem.Create(ctx, bucket, key, data)
+}
+
+// watermarkOf returns the watermark of the first watermarkFS in the chain of
+// FileSystems wrapped by fs.
+func watermarkOf(fs ent.FileSystem) (*watermark, bool) {
+	for {
+		if w, ok := fs.(*watermarkFS); ok {
+			return w.wm, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package server
+
+func diskFree(path string) (int64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,21 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package server
+
+import (
+	"syscall"
+)
+
+// diskFree returns the number of bytes available to unprivileged users on
+// the filesystem holding path.
+func diskFree(path string) (int64, error) {
+	st := syscall.Statfs_t{}
+
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
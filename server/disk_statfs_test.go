@@ -0,0 +1,160 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestWatermarkFS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-watermark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	free, err := diskFree(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("disk", ent.Owner{})
+	)
+
+	for _, test := range []struct {
+		min  int64
+		size int64
+		err  error
+	}{
+		{min: 1, size: -1, err: nil},
+		{min: 1, size: 4, err: nil},
+		{min: free + 1<<30, size: -1, err: ent.ErrInsufficientStorage},
+		{min: free / 2, size: free, err: ent.ErrInsufficientStorage},
+	} {
+		wm, err := newWatermark(tmp, test.min)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs := &watermarkFS{FileSystem: newDiskFS(tmp), wm: wm}
+
+		f, err := fs.Create(ctx, b, "file", &sizedReader{Reader: strings.NewReader("data"), size: test.size})
+		if !errors.Is(err, test.err) {
+			t.Errorf("min %d, size %d: want %v, got %v", test.min, test.size, test.err, err)
+		}
+		if err == nil {
+			f.Close()
+		}
+
+		if got, ok := watermarkOf(fs); !ok || got != wm {
+			t.Errorf("want watermark of the watermarkFS, got %v", got)
+		}
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	free, err := diskFree(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		min   int64
+		code  int
+		ready bool
+	}{
+		{min: 0, code: http.StatusOK, ready: true},
+		{min: 1, code: http.StatusOK, ready: true},
+		{min: free + 1<<30, code: http.StatusServiceUnavailable, ready: false},
+	} {
+		var wm *watermark
+		if test.min > 0 {
+			wm, err = newWatermark(tmp, test.min)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		handleReady(wm).ServeHTTP(w, httptest.NewRequest("GET", routeReady, nil))
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("min %d: want code %d, got %d", test.min, want, got)
+		}
+
+		resp := ent.ResponseReady{}
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := test.ready, resp.Ready; want != got {
+			t.Errorf("min %d: want ready %t, got %t", test.min, want, got)
+		}
+	}
+}
+
+func TestWatermarkRun(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-watermark-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	wm, err := newWatermark(tmp, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan struct{})
+	)
+
+	// The gauges are refreshed without anything asking for the free space.
+	diskFreeBytes.Set(-1)
+
+	go func() {
+		wm.run(ctx, time.Hour)
+		close(done)
+	}()
+
+	for {
+		m := &dto.Metric{}
+		err := diskFreeBytes.Write(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.GetGauge().GetValue() > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("want run stopped once its context is done")
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const headerIfNoneMatch = "If-None-Match"
+
+// listETag returns a weak ETag of the listed entries v, which changes
+// whenever an entry is added, removed or modified. It is weak as listings
+// carrying the same entries differ in their duration.
+func listETag(v interface{}) (string, error) {
+	h := sha1.New()
+
+	err := json.NewEncoder(h).Encode(v)
+	if err != nil {
+		return "", err
+	}
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
+
+// notModified sets etag on the response and reports if the If-None-Match
+// header of r matches it, in which case the client already has the listing
+// and is answered with 304 Not Modified.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set(headerETag, etag)
+
+	for _, tag := range strings.Split(r.Header.Get(headerIfNoneMatch), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
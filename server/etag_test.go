@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestHandleFileListETag(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-etag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("poll", ent.Owner{})
+		fs = newDiskFS(tmp)
+		r  = pat.New()
+	)
+
+	create := func(key string) {
+		f, err := fs.Create(context.Background(), b, key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	r.Get(routeBucket, handleFileList(newMockProvider(b), fs))
+
+	list := func(etag string) (int, string) {
+		req := httptest.NewRequest("GET", "/poll?prefix=a/", nil)
+		if etag != "" {
+			req.Header.Set(headerIfNoneMatch, etag)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code == http.StatusOK {
+			resp := ent.ResponseFileList{}
+			err := json.NewDecoder(w.Body).Decode(&resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := w.Header().Get(headerETag), resp.ETag; want != got {
+				t.Errorf("want body ETag %s, got %s", want, got)
+			}
+		}
+		if w.Code == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("want empty body, got %q", w.Body.String())
+		}
+
+		return w.Code, w.Header().Get(headerETag)
+	}
+
+	create("a/1")
+
+	code, etag := list("")
+	if code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("want 200 with weak ETag, got %d with %q", code, etag)
+	}
+
+	for _, match := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		if code, _ := list(match); code != http.StatusNotModified {
+			t.Errorf("If-None-Match %s: want code %d, got %d", match, http.StatusNotModified, code)
+		}
+	}
+
+	create("b/1")
+
+	if code, got := list(etag); code != http.StatusNotModified || got != etag {
+		t.Errorf("want unchanged listing of other prefix, got %d with %s", code, got)
+	}
+
+	create("a/2")
+
+	code, changed := list(etag)
+	if code != http.StatusOK || changed == etag {
+		t.Errorf("want new listing after change, got %d with %s", code, changed)
+	}
+}
+
+func TestHandleBucketListETag(t *testing.T) {
+	var (
+		bs = []*ent.Bucket{}
+		r  = pat.New()
+	)
+
+	for i := 0; i < 8; i++ {
+		bs = append(bs, ent.NewBucket(fmt.Sprintf("poll-%d", i), ent.Owner{}))
+	}
+
+	r.Get("/", handleBucketList(newMockProvider(bs...)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	etag := w.Header().Get(headerETag)
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("want 200 with ETag, got %d with %q", w.Code, etag)
+	}
+
+	// Buckets are listed in no particular order by providers.
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(headerIfNoneMatch, etag)
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if want, got := http.StatusNotModified, w.Code; want != got {
+			t.Fatalf("want code %d, got %d", want, got)
+		}
+	}
+}
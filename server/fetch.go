@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const headerFetchURL = "X-Ent-Fetch-URL"
+
+// maxFetchRedirects is the number of redirects followed by a fetch.
+const maxFetchRedirects = 10
+
+var (
+	// reservedNets are the ranges not routable on the internet, which the
+	// methods of net.IP don't cover: "this network" of RFC 1122 and the
+	// carrier-grade NAT range of RFC 6598.
+	reservedNets = []*net.IPNet{
+		{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	}
+
+	// nat64Prefix is the well-known prefix of RFC 6052, whose addresses are
+	// translated to the IPv4 address in their last 32 bits.
+	nat64Prefix = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+)
+
+// newFetchClient returns the client fetching uploads from remote URLs within
+// timeout. It only connects to public addresses, checked when dialing, so
+// neither redirects nor DNS answers changing between lookups reach internal
+// services like cloud metadata endpoints. If hosts are given, only URLs of
+// these hosts are fetched, or of their subdomains for hosts starting with a
+// dot.
+func newFetchClient(timeout time.Duration, hosts []string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublic,
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &allowHosts{RoundTripper: transport, hosts: hosts},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// dialPublic refuses connections to loopback, private, link-local and other
+// non-public addresses.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ent.ErrFetchForbidden, host)
+	}
+
+	return nil
+}
+
+// publicIP reports if ip is routable on the internet. IPv4 addresses reached
+// through NAT64 or mapped to IPv6 are checked as IPv4 addresses.
+func publicIP(ip net.IP) bool {
+	if nat64Prefix.Contains(ip) {
+		return publicIP(ip[12:16])
+	}
+
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast())
+}
+
+// allowHosts passes on the requests, including those following redirects,
+// to hosts only. All hosts are allowed if there are none.
+type allowHosts struct {
+	http.RoundTripper
+	hosts []string
+}
+
+func (t *allowHosts) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.allowed(req.URL.Hostname()) {
+		return nil, fmt.Errorf("%w: %s", ent.ErrFetchForbidden, req.URL.Hostname())
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func (t *allowHosts) allowed(host string) bool {
+	if len(t.hosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, h := range t.hosts {
+		h = strings.ToLower(h)
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchRemote lets ent download the object itself if the request carries the
+// X-Ent-Fetch-URL header instead of a body. The response of the remote is
+// handed to next as the request body, which avoids the round trip through
+// the client when mirroring third-party assets. If client is nil fetching is
+// disabled. Use newFetchClient, so the client can't be pointed at internal
+// services.
+func fetchRemote(client *http.Client, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.Header.Get(headerFetchURL)
+		if raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if client == nil {
+			respondError(w, r, ent.ErrFetchDisabled)
+			return
+		}
+
+		if r.ContentLength != 0 {
+			respondError(w, r, ent.ErrInvalidParam)
+			return
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			respondError(w, r, ent.ErrInvalidFetchURL)
+			return
+		}
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			respondError(w, r, ent.ErrInvalidFetchURL)
+			return
+		}
+
+		res, err := client.Do(req.WithContext(r.Context()))
+		if errors.Is(err, ent.ErrFetchForbidden) {
+			respondError(w, r, ent.ErrFetchForbidden)
+			return
+		}
+		if err != nil {
+			respondError(w, r, ent.Wrap(ent.KindUnavailable, "fetch failed", err))
+			return
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			respondError(w, r, ent.NewError(
+				ent.KindUnavailable,
+				fmt.Sprintf("fetch failed: remote responded %s", res.Status),
+			))
+			return
+		}
+
+		r.Body.Close()
+		r.Body = res.Body
+		r.ContentLength = res.ContentLength
+
+		next.ServeHTTP(w, r)
+	})
+}
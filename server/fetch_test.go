@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestFetchRemote(t *testing.T) {
+	content := []byte("third-party asset")
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/asset" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer origin.Close()
+
+	var (
+		b  = ent.NewBucket("fetch", ent.Owner{})
+		fs = newMockFileSystem()
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	r.Add("POST", "/disabled"+routeFile, fetchRemote(nil, handleCreate(p, fs)))
+	r.Add("POST", routeFile, fetchRemote(http.DefaultClient, handleCreate(p, fs)))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, input := range []struct {
+		path string
+		url  string
+		code int
+	}{
+		{"/fetch/asset", origin.URL + "/asset", http.StatusCreated},
+		{"/fetch/missing", origin.URL + "/missing", http.StatusServiceUnavailable},
+		{"/fetch/local", "file:///etc/passwd", http.StatusBadRequest},
+		{"/disabled/fetch/asset", origin.URL + "/asset", http.StatusNotImplemented},
+	} {
+		req, err := http.NewRequest("POST", ts.URL+input.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(headerFetchURL, input.url)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if want, got := input.code, res.StatusCode; want != got {
+			t.Errorf("%s: want %d, got %d", input.url, want, got)
+		}
+	}
+
+	f, ok := fs.files[fmt.Sprintf("%s/%s", b.Name, "asset")]
+	if !ok {
+		t.Fatal("fetched asset not stored")
+	}
+
+	h, err := f.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := sha1.Sum(content), h; hex.EncodeToString(want[:]) != hex.EncodeToString(got) {
+		t.Errorf("want %x, got %x", want, got)
+	}
+}
+
+func TestFetchRemoteInternal(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer origin.Close()
+
+	var (
+		b  = ent.NewBucket("fetch", ent.Owner{})
+		fs = newMockFileSystem()
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	r.Add("POST", "/allowed"+routeFile, fetchRemote(newFetchClient(time.Second, []string{".example.com"}), handleCreate(p, fs)))
+	r.Add("POST", routeFile, fetchRemote(newFetchClient(time.Second, nil), handleCreate(p, fs)))
+
+	for _, input := range []struct {
+		path string
+		url  string
+	}{
+		{"/fetch/asset", origin.URL + "/asset"},
+		{"/fetch/metadata", "http://169.254.169.254/latest/meta-data/"},
+		{"/allowed/fetch/asset", origin.URL + "/asset"},
+	} {
+		req := httptest.NewRequest("POST", input.path, nil)
+		req.Header.Set(headerFetchURL, input.url)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if want, got := http.StatusForbidden, w.Code; want != got {
+			t.Errorf("%s: want %d, got %d", input.url, want, got)
+		}
+	}
+
+	if len(fs.files) != 0 {
+		t.Errorf("want nothing fetched, got %d files", len(fs.files))
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for _, test := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"0.1.2.3", false},
+		{"64:ff9b::5db8:d822", true},
+		{"64:ff9b::10.0.0.1", false},
+		{"64:ff9b::127.0.0.1", false},
+		{"64:ff9b::169.254.169.254", false},
+	} {
+		if want, got := test.public, publicIP(net.ParseIP(test.ip)); want != got {
+			t.Errorf("%s: want public %t, got %t", test.ip, want, got)
+		}
+
+		address := net.JoinHostPort(test.ip, "80")
+		if want, got := test.public, dialPublic("tcp", address, nil) == nil; want != got {
+			t.Errorf("%s: want dial allowed %t, got %t", address, want, got)
+		}
+	}
+
+	hosts := &allowHosts{hosts: []string{"cdn.example.com", ".assets.example.org"}}
+	for host, want := range map[string]bool{
+		"cdn.example.com":       true,
+		"CDN.example.com":       true,
+		"a.assets.example.org":  true,
+		"example.com":           false,
+		"evilcdn.example.com":   false,
+		"assets.example.org.io": false,
+	} {
+		if got := hosts.allowed(host); want != got {
+			t.Errorf("%s: want allowed %t, got %t", host, want, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// acceptForm stores the files of multipart/form-data requests, as posted by
+// browser forms, below the requested key, so web frontends can upload to ent
+// without any script. Each file part is stored under its file name, other
+// parts are ignored. If a part fails, the files stored before it are
+// reported along with the failure, as they stay stored. All other requests
+// are passed on to next.
+func acceptForm(p ent.Provider, fs ent.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			prefix = strings.TrimSuffix(r.URL.Query().Get(keyBlob), "/") + "/"
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			respondError(w, r, ent.ErrInvalidForm)
+			return
+		}
+
+		created := []ent.ResponseFile{}
+
+		fail := func(key string, err error) {
+			if len(created) == 0 {
+				respondError(w, r, err)
+				return
+			}
+
+			respondJSON(w, ent.StatusCode(ent.KindOf(err)), ent.ResponseCreatedList{
+				Count:    len(created),
+				Duration: time.Since(start),
+				Bucket:   b,
+				Files:    created,
+				Failed: []ent.ResponseFailedFile{{
+					Key:   key,
+					Error: err.Error(),
+				}},
+			})
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fail("", ent.ErrInvalidForm)
+				return
+			}
+
+			// FileName is reduced to the base name, so no part escapes the
+			// prefix.
+			name := part.FileName()
+			if name == "" {
+				part.Close()
+				continue
+			}
+			if name == "." || name == ".." || name == "/" {
+				part.Close()
+				fail(prefix+name, ent.ErrInvalidForm)
+				return
+			}
+
+			key, err := checkKey(fs, prefix+name)
+			if err != nil {
+				part.Close()
+				fail(prefix+name, err)
+				return
+			}
+
+			f, err := fs.Create(r.Context(), b, key, &sizedReader{
+				Reader: part,
+				op:     "handleCreate",
+				size:   -1,
+			})
+			part.Close()
+			if err != nil {
+				recordAbort("handleCreate", err)
+				fail(key, err)
+				return
+			}
+
+			created = append(created, ent.ResponseFile{
+				Key:          key,
+				Bucket:       b,
+				LastModified: f.LastModified(),
+			})
+			f.Close()
+		}
+
+		if len(created) == 0 {
+			respondError(w, r, ent.ErrInvalidForm)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, ent.ResponseCreatedList{
+			Count:    len(created),
+			Duration: time.Since(start),
+			Bucket:   b,
+			Files:    created,
+		})
+	})
+}
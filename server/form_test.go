@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestAcceptForm(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-form")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("form", ent.Owner{})
+		fs = newDiskFS(tmp)
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	r.Add("POST", routeFile, acceptForm(p, fs, handleCreate(p, fs)))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	var (
+		body = &bytes.Buffer{}
+		mw   = multipart.NewWriter(body)
+	)
+
+	err = mw.WriteField("comment", "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "../b.txt"} {
+		part, err := mw.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("content of " + name))
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/form/uploads/", mw.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	resp := ent.ResponseCreatedList{}
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for _, f := range resp.Files {
+		keys = append(keys, f.Key)
+	}
+	if want, got := []string{"uploads/a.txt", "uploads/b.txt"}, keys; !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	f, err := fs.Open(context.Background(), b, "uploads/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "content of ../b.txt", string(data); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// Files stored before a failing part are reported along with the failure.
+	body.Reset()
+	mw = multipart.NewWriter(body)
+	for _, name := range []string{"ok.txt", ".."} {
+		part, err := mw.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("content of " + name))
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = http.Post(ts.URL+"/form/partial/", mw.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if want, got := http.StatusBadRequest, res.StatusCode; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	resp = ent.ResponseCreatedList{}
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(resp.Files); want != got || resp.Files[0].Key != "partial/ok.txt" {
+		t.Errorf("want %d stored file partial/ok.txt, got %v", want, resp.Files)
+	}
+	if want, got := 1, len(resp.Failed); want != got || resp.Failed[0].Key != "partial/.." {
+		t.Errorf("want %d failed file partial/.., got %v", want, resp.Failed)
+	}
+
+	// Forms without files are rejected, other bodies are stored as they are.
+	res, err = http.Post(ts.URL+"/form/empty", "multipart/form-data; boundary=x", strings.NewReader("--x--\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want, got := http.StatusBadRequest, res.StatusCode; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	res, err = http.Post(ts.URL+"/form/plain", "text/plain", strings.NewReader("plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
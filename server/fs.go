@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// pendingPrefix is the name prefix of temporary files of operations in
+// progress.
+const pendingPrefix = "pending-"
+
+// unwrapper is implemented by FileSystems adding behaviour to the
+// FileSystem they wrap.
+type unwrapper interface {
+	Unwrap() ent.FileSystem
+}
+
+type diskFS struct {
+	mmap *mmapCache
+	root string
+
+	// locks serialise snapshots and retentions with the renames and removals
+	// of stored files per bucket, so a snapshot never captures a bucket
+	// halfway through an operation, without holding up other buckets.
+	locksMu sync.Mutex
+	locks   map[string]*sync.RWMutex
+
+	// uploads holds the IDs of resumable uploads currently written or read,
+	// pending the bytes received by resumable uploads per bucket.
+	uploadsMu sync.Mutex
+	uploads   map[string]bool
+	pending   map[string]int64
+}
+
+// diskFSOption configures optional behaviour of the diskFS.
+type diskFSOption func(*diskFS)
+
+// withMmap serves small files from the memory mappings of c. The cache can
+// be shared by several diskFS.
+func withMmap(c *mmapCache) diskFSOption {
+	return func(fs *diskFS) {
+		fs.mmap = c
+	}
+}
+
+func newDiskFS(root string, opts ...diskFSOption) ent.FileSystem {
+	fs := &diskFS{
+		root: root,
+	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs
+}
+
+// lock returns the lock of the bucket.
+func (fs *diskFS) lock(bucket *ent.Bucket) *sync.RWMutex {
+	fs.locksMu.Lock()
+	defer fs.locksMu.Unlock()
+
+	if fs.locks == nil {
+		fs.locks = map[string]*sync.RWMutex{}
+	}
+
+	mu, ok := fs.locks[bucket.Name]
+	if !ok {
+		mu = &sync.RWMutex{}
+		fs.locks[bucket.Name] = mu
+	}
+
+	return mu
+}
+
+func (fs *diskFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	r io.Reader,
+) (ent.File, error) {
+	dst, err := pathForFile(fs, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retained files are refused before their replacement is uploaded and
+	// checked again before it is put in place.
+	mu := fs.lock(bucket)
+	mu.RLock()
+	err = fs.checkRetention(bucket, key)
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Join(fs.root, bucket.Name), pendingPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The partial file of an aborted upload is released right away instead
+	// of being left behind.
+	stored := false
+	defer func() {
+		if !stored {
+			os.Remove(tmp.Name())
+		}
+	}()
+	defer tmp.Close()
+
+	f := newFile(tmp, key)
+
+	_, err = io.Copy(&ctxWriter{ctx: ctx, w: f}, r)
+	if err != nil {
+		return nil, fmt.Errorf("storing failed: %w", err)
+	}
+
+	mu.RLock()
+	err = fs.checkRetention(bucket, key)
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+		if err != nil {
+			err = fmt.Errorf("rename failed: %s", err)
+		}
+	}
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	stored = true
+
+	if fs.mmap != nil {
+		fs.mmap.invalidate(dst)
+	}
+
+	f.File, err = os.Open(dst)
+	if err != nil {
+		return nil, fmt.Errorf("open failed: %s", err)
+	}
+
+	stat, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	f.lastModified = stat.ModTime()
+
+	return f, nil
+}
+
+func (fs *diskFS) Delete(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) error {
+	p, err := pathForFile(fs, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ent.ErrFileNotFound
+		}
+		return err
+	}
+
+	mu := fs.lock(bucket)
+	mu.RLock()
+	err = fs.checkRetention(bucket, key)
+	if err == nil {
+		err = os.Remove(p)
+		if err != nil {
+			err = fmt.Errorf("removal failed: %s", err)
+		} else {
+			// The retention of the file expired and is of no use anymore.
+			if rp, err := pathForRetention(fs, bucket, key); err == nil {
+				os.Remove(rp)
+			}
+		}
+	}
+	mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if fs.mmap != nil {
+		fs.mmap.invalidate(p)
+	}
+
+	return nil
+}
+
+func (fs *diskFS) Open(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) (ent.File, error) {
+	path, err := pathForFile(fs, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ent.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	// If the requested key only matches a sub-directory and not a file we handle
+	// it as if no file was found.
+	if stat.IsDir() {
+		return nil, ent.ErrFileNotFound
+	}
+
+	if fs.mmap != nil && fs.mmap.eligible(stat.Size()) {
+		m, err := fs.mmap.get(path, stat)
+		if err == nil {
+			return newMappedFile(m, key), nil
+		}
+		if err != errMmapUnsupported {
+			log.Printf("mapping %s failed: %s", path, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file might have been replaced since the path was inspected, its
+	// own modification time is the one matching its content.
+	stat, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	adviseReadahead(f, stat.Size())
+
+	file := newFile(f, key)
+	file.lastModified = stat.ModTime()
+
+	return file, nil
+}
+
+func (fs *diskFS) List(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	return listDir(ctx, filepath.Join(fs.root, bucket.Name), prefix, limit, sortStrategy)
+}
+
+// Walk passes the files with keys starting with prefix to fn as they are
+// found.
+func (fs *diskFS) Walk(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	prefix string,
+	fn func(ent.File) error,
+) error {
+	return walkDir(ctx, filepath.Join(fs.root, bucket.Name), prefix, fn)
+}
+
+type file struct {
+	hash         hash.Hash
+	hashed       int64
+	key          string
+	lastModified time.Time
+
+	*os.File
+}
+
+func newFile(f *os.File, key string) *file {
+	return &file{
+		hash:   sha1.New(),
+		hashed: 0,
+		key:    key,
+		File:   f,
+	}
+}
+
+func (f *file) Key() string {
+	return f.key
+}
+
+func (f *file) LastModified() time.Time {
+	return f.lastModified
+}
+
+func (f *file) Hash() ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if f.hashed == fi.Size() {
+		return f.hash.Sum(nil), nil
+	}
+
+	f.hash.Reset()
+	f.hashed = 0
+
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := io.Copy(f.hash, f)
+	if err != nil {
+		return nil, err
+	}
+
+	f.hashed += int64(n)
+
+	return f.hash.Sum(nil), nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.hash.Write(p)
+	if err != nil {
+		return n, err
+	}
+	f.hashed += int64(n)
+
+	return f.File.Write(p)
+}
+
+// pathForFile returns the path of the file of key, failing with
+// ent.ErrInvalidKey if it isn't inside the directory of its bucket.
+func pathForFile(fs *diskFS, bucket *ent.Bucket, key string) (string, error) {
+	return joinKey(filepath.Join(fs.root, bucket.Name), key)
+}
+
+// joinKey returns the path of key below dir, failing with ent.ErrInvalidKey
+// if the key would escape dir or name dir itself. Every path built from a
+// key is checked, so no entry point can reach files outside a bucket.
+func joinKey(dir, key string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: escapes its bucket", ent.ErrInvalidKey)
+	}
+	return path, nil
+}
+
+// ctxWriter stops writing to w once ctx is done.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
@@ -1,8 +1,10 @@
-package main
+package server
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
@@ -36,7 +38,7 @@ func TestDiskFSCreate(t *testing.T) {
 
 	tr := io.TeeReader(r, h)
 
-	_, err = fs.Create(b, filepath.Base(testFile), tr)
+	_, err = fs.Create(context.Background(), b, filepath.Base(testFile), tr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,7 +49,7 @@ func TestDiskFSCreate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	f, err := fs.Open(b, filepath.Base(testFile))
+	f, err := fs.Open(context.Background(), b, filepath.Base(testFile))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,7 +92,7 @@ func TestDiskFSDelete(t *testing.T) {
 	}
 	defer r.Close()
 
-	f, err := fs.Create(b, key, r)
+	f, err := fs.Create(context.Background(), b, key, r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -100,7 +102,7 @@ func TestDiskFSDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = fs.Delete(b, f.Key())
+	err = fs.Delete(context.Background(), b, f.Key())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,6 +113,37 @@ func TestDiskFSDelete(t *testing.T) {
 	}
 }
 
+func TestDiskFSCreateAborted(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "diskfs-create-aborted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("create-aborted", ent.Owner{})
+		fs = newDiskFS(tmp)
+	)
+
+	_, err = fs.Create(
+		context.Background(),
+		b,
+		"aborted",
+		io.MultiReader(strings.NewReader("partial"), errorReader{io.ErrUnexpectedEOF}),
+	)
+	if want, got := io.ErrUnexpectedEOF, err; !errors.Is(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(tmp, b.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(infos); want != got {
+		t.Errorf("want %d files left, got %d", want, got)
+	}
+}
+
 func TestDiskFSDeleteFileNotFound(t *testing.T) {
 	tmp, err := ioutil.TempDir("", "diskfs-delete-notfound")
 	if err != nil {
@@ -123,7 +156,7 @@ func TestDiskFSDeleteFileNotFound(t *testing.T) {
 		fs = newDiskFS(tmp)
 	)
 
-	err = fs.Delete(b, "non-exisiting-file")
+	err = fs.Delete(context.Background(), b, "non-exisiting-file")
 
 	if want, got := ent.ErrFileNotFound, err; want != got {
 		t.Errorf("want %v, got %v", want, got)
@@ -152,17 +185,75 @@ func TestDiskFSFileNotFound(t *testing.T) {
 		fs = newDiskFS(tmp)
 	)
 
-	_, err = fs.Open(b, "non-existing.file")
+	_, err = fs.Open(context.Background(), b, "non-existing.file")
 	if !ent.IsFileNotFound(err) {
 		t.Errorf("expected %s when opening missing file got %s", ent.ErrFileNotFound, err)
 	}
 
-	_, err = fs.Open(b, filepath.Base(dir))
+	_, err = fs.Open(context.Background(), b, filepath.Base(dir))
 	if !ent.IsFileNotFound(err) {
 		t.Errorf("expected %s when opening missing file got %s", ent.ErrFileNotFound, err)
 	}
 }
 
+func TestDiskFSEscape(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "diskfs-escape")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = os.MkdirAll(filepath.Join(tmp, "bucket"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, "secret"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("bucket", ent.Owner{})
+		fs  = newDiskFS(tmp)
+	)
+
+	rt, ok := retainerOf(fs)
+	if !ok {
+		t.Fatal("want diskFS to retain files")
+	}
+
+	for _, key := range []string{"../secret", "a/../../secret", ".", ""} {
+		_, err := fs.Open(ctx, b, key)
+		if !errors.Is(err, ent.ErrInvalidKey) {
+			t.Errorf("open %q: want %s, got %v", key, ent.ErrInvalidKey, err)
+		}
+
+		err = fs.Delete(ctx, b, key)
+		if !errors.Is(err, ent.ErrInvalidKey) {
+			t.Errorf("delete %q: want %s, got %v", key, ent.ErrInvalidKey, err)
+		}
+
+		_, err = fs.Create(ctx, b, key, strings.NewReader("replaced"))
+		if !errors.Is(err, ent.ErrInvalidKey) {
+			t.Errorf("create %q: want %s, got %v", key, ent.ErrInvalidKey, err)
+		}
+
+		_, err = rt.Retain(ctx, b, key, time.Now().Add(time.Hour))
+		if !errors.Is(err, ent.ErrInvalidKey) {
+			t.Errorf("retain %q: want %s, got %v", key, ent.ErrInvalidKey, err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tmp, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "secret", string(data); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
 func TestDiskFSList(t *testing.T) {
 	var (
 		tempFiles = []string{
@@ -246,7 +337,7 @@ func TestDiskFSList(t *testing.T) {
 		emptyBucket = ent.NewBucket("notCreatedDir", ent.Owner{})
 	)
 
-	all, err := fs.List(emptyBucket, "", 12, ent.NoOpStrategy())
+	all, err := fs.List(context.Background(), emptyBucket, "", 12, ent.NoOpStrategy())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -259,7 +350,7 @@ func TestDiskFSList(t *testing.T) {
 	}
 
 	for _, input := range listTestEntries {
-		all, err := fs.List(b, input.prefix, input.limit, ent.NoOpStrategy())
+		all, err := fs.List(context.Background(), b, input.prefix, input.limit, ent.NoOpStrategy())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -286,7 +377,7 @@ func TestDiskFSList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	all, err = fs.List(b, "", defaultLimit, strategy)
+	all, err = fs.List(context.Background(), b, "", defaultLimit, strategy)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -305,7 +396,7 @@ func TestDiskFSList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	all, err = fs.List(b, "", defaultLimit, strategy)
+	all, err = fs.List(context.Background(), b, "", defaultLimit, strategy)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -326,7 +417,7 @@ func TestDiskFSList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	all, err = fs.List(b, "", defaultLimit, strategy)
+	all, err = fs.List(context.Background(), b, "", defaultLimit, strategy)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -347,7 +438,7 @@ func TestDiskFSList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	all, err = fs.List(b, "", defaultLimit, strategy)
+	all, err = fs.List(context.Background(), b, "", defaultLimit, strategy)
 	if err != nil {
 		t.Fatal(err)
 	}
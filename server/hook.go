@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// A Hook is told about the files stored, removed and served by ent, so
+// services embedding it can index, scan or bill them without wrapping the
+// handlers. Hooks are set in Config.Hooks and called synchronously in their
+// order, so long running work should be handed off.
+type Hook interface {
+	// BeforeCreate is called before a file is stored. The size is -1 if it
+	// isn't announced by the client and the hash is not known yet. An error
+	// rejects the upload and is returned to the client, which is answered
+	// with the status of its ent.Kind if it is an *ent.Error.
+	BeforeCreate(ctx context.Context, e HookEvent) error
+
+	// AfterCreate is called after a file was stored.
+	AfterCreate(ctx context.Context, e HookEvent)
+
+	// AfterDelete is called after a file was removed, with the size it
+	// had.
+	AfterDelete(ctx context.Context, e HookEvent)
+
+	// AfterGet is called after a file, or a range of it, was served
+	// completely by GET /{bucket}/{key}. Answers to conditional requests
+	// without a body and downloads aborted by the client don't count.
+	AfterGet(ctx context.Context, e HookEvent)
+}
+
+// HookEvent describes the file a Hook is called for.
+type HookEvent struct {
+	// Tenant is the name of the tenant of the bucket, empty without
+	// tenants.
+	Tenant string
+	Bucket *ent.Bucket
+	Key    string
+	Size   int64
+
+	hash *lazyHash
+}
+
+// Hash returns the SHA1 of the file. It is only computed once a hook asks
+// for it, as that may read the whole file, and has to be asked for during
+// the call of the hook. It is nil before a file is stored and after it was
+// removed.
+func (e HookEvent) Hash() ([]byte, error) {
+	if e.hash == nil {
+		return nil, nil
+	}
+	return e.hash.get()
+}
+
+// lazyHash computes the hash of f on the first call of get, shared by all
+// hooks called for the same event.
+type lazyHash struct {
+	f    ent.File
+	once sync.Once
+	sum  []byte
+	err  error
+}
+
+func (h *lazyHash) get() ([]byte, error) {
+	h.once.Do(func() {
+		h.sum, h.err = h.f.Hash()
+	})
+	return h.sum, h.err
+}
+
+// NopHook implements all methods of Hook doing nothing, so Hooks interested
+// in some events only can embed it.
+type NopHook struct{}
+
+// BeforeCreate accepts all files.
+func (NopHook) BeforeCreate(context.Context, HookEvent) error { return nil }
+
+// AfterCreate does nothing.
+func (NopHook) AfterCreate(context.Context, HookEvent) {}
+
+// AfterDelete does nothing.
+func (NopHook) AfterDelete(context.Context, HookEvent) {}
+
+// AfterGet does nothing.
+func (NopHook) AfterGet(context.Context, HookEvent) {}
+
+// hookFS calls hooks for the files created and deleted in the FileSystem it
+// wraps. Files served are reported by handleGet through afterGet.
+type hookFS struct {
+	ent.FileSystem
+	tenant string
+	hooks  []Hook
+}
+
+func (fs *hookFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *hookFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	e := HookEvent{Tenant: fs.tenant, Bucket: bucket, Key: key, Size: -1}
+	if s, ok := data.(sizer); ok {
+		e.Size = s.Size()
+	}
+
+	for _, h := range fs.hooks {
+		err := h.BeforeCreate(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := fs.FileSystem.Create(ctx, bucket, key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err = fs.event(bucket, key, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	for _, h := range fs.hooks {
+		h.AfterCreate(ctx, e)
+	}
+
+	return f, nil
+}
+
+func (fs *hookFS) Delete(ctx context.Context, bucket *ent.Bucket, key string) error {
+	// The file is opened for its size only, its data is gone by the time
+	// the hooks are called.
+	f, err := fs.FileSystem.Open(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	size, err := fileSize(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	e := HookEvent{Tenant: fs.tenant, Bucket: bucket, Key: key, Size: size}
+
+	err = fs.FileSystem.Delete(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range fs.hooks {
+		h.AfterDelete(ctx, e)
+	}
+
+	return nil
+}
+
+// afterGet calls the hooks for f served from bucket.
+func (fs *hookFS) afterGet(ctx context.Context, bucket *ent.Bucket, key string, f ent.File) {
+	e, err := fs.event(bucket, key, f)
+	if err != nil {
+		log.Printf("calling hooks for %s/%s failed: %s", bucket.Name, key, err)
+		return
+	}
+
+	for _, h := range fs.hooks {
+		h.AfterGet(ctx, e)
+	}
+}
+
+// event describes f stored for key in bucket, which has to stay open while
+// the hooks are called.
+func (fs *hookFS) event(bucket *ent.Bucket, key string, f ent.File) (HookEvent, error) {
+	size, err := fileSize(f)
+	if err != nil {
+		return HookEvent{}, err
+	}
+
+	return HookEvent{
+		Tenant: fs.tenant,
+		Bucket: bucket,
+		Key:    key,
+		Size:   size,
+		hash:   &lazyHash{f: f},
+	}, nil
+}
+
+// hooksOf returns the first hookFS in the chain of FileSystems wrapped by fs.
+func hooksOf(fs ent.FileSystem) (*hookFS, bool) {
+	for {
+		if h, ok := fs.(*hookFS); ok {
+			return h, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
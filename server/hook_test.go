@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+type recordHook struct {
+	NopHook
+	events []string
+}
+
+func (h *recordHook) BeforeCreate(ctx context.Context, e HookEvent) error {
+	h.record("before", e)
+	if strings.HasPrefix(e.Key, "infected/") {
+		return ent.ErrForbidden
+	}
+	return nil
+}
+
+func (h *recordHook) AfterCreate(ctx context.Context, e HookEvent) { h.record("create", e) }
+func (h *recordHook) AfterDelete(ctx context.Context, e HookEvent) { h.record("delete", e) }
+func (h *recordHook) AfterGet(ctx context.Context, e HookEvent)    { h.record("get", e) }
+
+func (h *recordHook) record(op string, e HookEvent) {
+	hash, err := e.Hash()
+	if err != nil {
+		h.events = append(h.events, fmt.Sprintf("%s hash failed: %s", op, err))
+		return
+	}
+	h.events = append(h.events, fmt.Sprintf("%s %s %s/%s %d %x", op, e.Tenant, e.Bucket.Name, e.Key, e.Size, hash))
+}
+
+func TestHookFS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b    = ent.NewBucket("hooked", ent.Owner{})
+		p    = newMockProvider(b)
+		hook = &recordHook{}
+		fs   = &hookFS{FileSystem: newDiskFS(tmp), tenant: "acme", hooks: []Hook{hook}}
+		r    = pat.New()
+		hash = sha1.Sum([]byte("data"))
+	)
+
+	r.Get(routeFile, handleGet(p, fs))
+	r.Post(routeFile, handleCreate(p, fs))
+	r.Delete(routeFile, handleDelete(p, fs))
+
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"POST", "/hooked/file", http.StatusCreated},
+		{"GET", "/hooked/file", http.StatusOK},
+		{"DELETE", "/hooked/file", http.StatusOK},
+		{"POST", "/hooked/infected/file", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader("data")))
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("%s %s: want code %d, got %d", test.method, test.path, want, got)
+		}
+	}
+
+	want := []string{
+		"before acme hooked/file 4 ",
+		fmt.Sprintf("create acme hooked/file 4 %x", hash),
+		fmt.Sprintf("get acme hooked/file 4 %x", hash),
+		"delete acme hooked/file 4 ",
+		"before acme hooked/infected/file 4 ",
+	}
+	if got := hook.events; !reflect.DeepEqual(want, got) {
+		t.Errorf("want events\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	if _, err := fs.Open(context.Background(), b, "infected/file"); !ent.IsFileNotFound(err) {
+		t.Errorf("want rejected file not stored, got %v", err)
+	}
+}
+
+// brokenWriter fails all writes, like a client gone away.
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestHookAfterGetServed(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b    = ent.NewBucket("hooked", ent.Owner{})
+		p    = newMockProvider(b)
+		hook = &recordHook{}
+		fs   = &hookFS{FileSystem: newDiskFS(tmp), tenant: "acme", hooks: []Hook{hook}}
+		r    = pat.New()
+	)
+
+	r.Get(routeFile, handleGet(p, fs))
+
+	f, err := fs.FileSystem.Create(context.Background(), b, "file", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/hooked/file", nil))
+	etag := w.Header().Get(headerETag)
+
+	for _, test := range []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-Match", `"other"`, http.StatusPreconditionFailed},
+		{"Range", "bytes=1-2", http.StatusPartialContent},
+	} {
+		req := httptest.NewRequest("GET", "/hooked/file", nil)
+		req.Header.Set(test.header, test.value)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("%s: want code %d, got %d", test.header, want, got)
+		}
+	}
+
+	r.ServeHTTP(brokenWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/hooked/file", nil))
+
+	if want, got := 2, len(hook.events); want != got {
+		t.Errorf("want %d served events for the full and the ranged download, got %d:\n%s", want, got, strings.Join(hook.events, "\n"))
+	}
+}
+
+// hashCountingFile counts the calls of Hash.
+type hashCountingFile struct {
+	*mockFile
+	hashes int
+}
+
+func (f *hashCountingFile) Hash() ([]byte, error) {
+	f.hashes++
+	return f.mockFile.Hash()
+}
+
+func TestHookEventHash(t *testing.T) {
+	var (
+		b  = ent.NewBucket("hooked", ent.Owner{})
+		f  = &hashCountingFile{mockFile: newMockFile([]byte("data"))}
+		fs = &hookFS{hooks: []Hook{NopHook{}}}
+	)
+
+	fs.afterGet(context.Background(), b, "file", f)
+	if want, got := 0, f.hashes; want != got {
+		t.Errorf("want %d hashes without hooks asking, got %d", want, got)
+	}
+
+	fs.hooks = []Hook{&recordHook{}, &recordHook{}}
+
+	fs.afterGet(context.Background(), b, "file", f)
+	if want, got := 1, f.hashes; want != got {
+		t.Errorf("want %d hash shared by all hooks, got %d", want, got)
+	}
+}
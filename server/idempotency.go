@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	headerIdempotencyKey      = "Idempotency-Key"
+	headerIdempotencyReplayed = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeys bounds the number of remembered uploads per namespace.
+const maxIdempotencyKeys = 10000
+
+var idempotentRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Program,
+		Name:      "idempotent_requests_total",
+		Help:      "Total number of uploads carrying an Idempotency-Key by result.",
+	},
+	[]string{"result"},
+)
+
+// idempotency remembers the responses of uploads carrying an
+// Idempotency-Key, so retries of an upload which was already stored are
+// answered with the original response instead of storing it again.
+type idempotency struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentUpload
+}
+
+// idempotentUpload is a remembered upload. Until it is done, the upload is
+// still in progress.
+type idempotentUpload struct {
+	key      string
+	fetchURL string
+	done     bool
+	expires  time.Time
+
+	sha1   string
+	code   int
+	header http.Header
+	body   []byte
+
+	// size and modified identify the stored file, which was replaced or
+	// deleted once they changed.
+	size     int64
+	modified time.Time
+}
+
+// newIdempotency returns an idempotency remembering uploads for ttl. It
+// returns nil if ttl is not positive.
+func newIdempotency(ttl time.Duration) *idempotency {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &idempotency{
+		ttl:     ttl,
+		entries: map[string]*idempotentUpload{},
+	}
+}
+
+// idempotent answers POST and PUT requests carrying an Idempotency-Key,
+// which were already answered by next for the same key and content, with the
+// original response, as long as the stored file is unchanged. The content of a retry
+// is identified by its X-Ent-SHA1 header, its X-Ent-Fetch-URL or else by
+// hashing its body, which is never stored. Reusing an Idempotency-Key for
+// another key or content is rejected. If id is nil all requests are passed on
+// to next only.
+func idempotent(id *idempotency, p ent.Provider, fs ent.FileSystem, next http.Handler) http.Handler {
+	if id == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(headerIdempotencyKey)
+		if token == "" || (r.Method != "POST" && r.Method != "PUT") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			entry  = bucket + "/" + token
+		)
+
+		up, created := id.start(entry, key, r.Header.Get(headerFetchURL))
+		if !created && up.done && !up.stored(r, p, fs, bucket) {
+			// The file was replaced or deleted since, so the original
+			// response is stale and the upload is stored again.
+			idempotentRequests.WithLabelValues("changed").Inc()
+			id.forget(entry, up)
+			up, created = id.start(entry, key, r.Header.Get(headerFetchURL))
+		}
+
+		if created {
+			// Uploads ending without being finished, like those of a
+			// panicking next, are forgotten so they can be retried.
+			finished := false
+			defer func() {
+				if !finished {
+					id.forget(entry, up)
+				}
+			}()
+
+			iw := &idempotentWriter{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(iw, r)
+			size, modified, err := up.stat(r, p, fs, bucket)
+			id.finish(entry, up, iw, size, modified, err)
+			finished = true
+			idempotentRequests.WithLabelValues("stored").Inc()
+			return
+		}
+
+		if !up.done {
+			idempotentRequests.WithLabelValues("busy").Inc()
+			respondError(w, r, ent.ErrIdempotencyBusy)
+			return
+		}
+
+		err := up.matches(r, key)
+		if err != nil {
+			idempotentRequests.WithLabelValues("mismatch").Inc()
+			respondError(w, r, err)
+			return
+		}
+
+		idempotentRequests.WithLabelValues("replayed").Inc()
+
+		for k, vs := range up.header {
+			w.Header()[k] = vs
+		}
+		w.Header().Set(headerIdempotencyReplayed, "true")
+		w.WriteHeader(up.code)
+		w.Write(up.body)
+	})
+}
+
+// start returns the upload remembered for entry, or remembers a new upload
+// in progress of key if there is none, reporting if it did.
+func (id *idempotency) start(entry, key, fetchURL string) (*idempotentUpload, bool) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	now := time.Now()
+
+	if up, ok := id.entries[entry]; ok && (!up.done || now.Before(up.expires)) {
+		return up, false
+	}
+
+	if len(id.entries) >= maxIdempotencyKeys {
+		id.evict(now)
+	}
+
+	up := &idempotentUpload{key: key, fetchURL: fetchURL}
+	id.entries[entry] = up
+
+	return up, true
+}
+
+// evict removes all expired uploads, or the one expiring first if none
+// expired. Uploads in progress are kept.
+func (id *idempotency) evict(now time.Time) {
+	var (
+		oldest string
+		first  time.Time
+	)
+
+	for entry, up := range id.entries {
+		if !up.done {
+			continue
+		}
+		if now.After(up.expires) {
+			delete(id.entries, entry)
+			continue
+		}
+		if oldest == "" || up.expires.Before(first) {
+			oldest, first = entry, up.expires
+		}
+	}
+
+	if len(id.entries) >= maxIdempotencyKeys && oldest != "" {
+		delete(id.entries, oldest)
+	}
+}
+
+// finish remembers the response recorded by iw for up, along with the size
+// and modification time of the stored file or the error determining them.
+// Failed uploads and responses not describing a single stored file are
+// forgotten, so they can be retried.
+func (id *idempotency) finish(
+	entry string,
+	up *idempotentUpload,
+	iw *idempotentWriter,
+	size int64,
+	modified time.Time,
+	err error,
+) {
+	sum := iw.Header().Get(headerSHA1)
+
+	if iw.code < 200 || iw.code > 299 || sum == "" || err != nil {
+		id.forget(entry, up)
+		return
+	}
+
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	up.done = true
+	up.expires = time.Now().Add(id.ttl)
+	up.sha1 = sum
+	up.code = iw.code
+	up.body = iw.body.Bytes()
+	up.size = size
+	up.modified = modified
+	up.header = http.Header{}
+	for _, h := range []string{"Content-Type", headerETag, headerSHA1, headerLastModified} {
+		if v := iw.Header().Get(h); v != "" {
+			up.header.Set(h, v)
+		}
+	}
+}
+
+// forget removes up, unless entry was taken over by another upload.
+func (id *idempotency) forget(entry string, up *idempotentUpload) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	if id.entries[entry] == up {
+		delete(id.entries, entry)
+	}
+}
+
+// matches fails with ent.ErrIdempotencyMismatch unless r uploads the same
+// content to the same key as up.
+func (up *idempotentUpload) matches(r *http.Request, key string) error {
+	if key != up.key || r.Header.Get(headerFetchURL) != up.fetchURL {
+		return ent.ErrIdempotencyMismatch
+	}
+	if up.fetchURL != "" {
+		return nil
+	}
+
+	sum := r.Header.Get(headerSHA1)
+	if sum == "" {
+		h := sha1.New()
+
+		_, err := io.Copy(h, r.Body)
+		if err != nil {
+			return ent.Wrap(ent.KindInvalid, "reading body failed", err)
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if !strings.EqualFold(sum, up.sha1) {
+		return ent.ErrIdempotencyMismatch
+	}
+
+	return nil
+}
+
+// stored reports if the file of up is still stored unchanged.
+func (up *idempotentUpload) stored(r *http.Request, p ent.Provider, fs ent.FileSystem, bucket string) bool {
+	size, modified, err := up.stat(r, p, fs, bucket)
+	return err == nil && size == up.size && modified.Equal(up.modified)
+}
+
+// stat returns the size and modification time of the file stored for up,
+// without reading it or pulling it from an origin.
+func (up *idempotentUpload) stat(
+	r *http.Request,
+	p ent.Provider,
+	fs ent.FileSystem,
+	bucket string,
+) (int64, time.Time, error) {
+	b, err := p.Get(r.Context(), bucket)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	f, err := localOf(fs).Open(r.Context(), b, up.key)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer f.Close()
+
+	size, err := fileSize(f)
+	return size, f.LastModified(), err
+}
+
+// idempotentWriter records the response written through it, so it can be
+// replayed.
+type idempotentWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *idempotentWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotentWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestIdempotent(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b       = ent.NewBucket("retry", ent.Owner{})
+		fs      = newDiskFS(tmp)
+		p       = newMockProvider(b)
+		r       = pat.New()
+		creates = 0
+	)
+
+	create := handleCreate(p, fs)
+	handler := idempotent(newIdempotency(time.Hour), p, fs, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			creates++
+			create.ServeHTTP(w, r)
+		},
+	))
+	r.Add("POST", routeFile, handler)
+	r.Add("PUT", routeFile, handler)
+
+	upload := func(method, key, token, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/retry/"+key, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(headerIdempotencyKey, token)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	post := func(key, token, body string, header ...string) *httptest.ResponseRecorder {
+		return upload("POST", key, token, body, header...)
+	}
+
+	first := post("file", "t1", "data")
+	if want, got := http.StatusCreated, first.Code; want != got {
+		t.Fatalf("want code %d, got %d", want, got)
+	}
+
+	for _, test := range []struct {
+		key    string
+		token  string
+		body   string
+		header []string
+		code   int
+		writes int
+	}{
+		{key: "file", token: "t1", body: "data", code: http.StatusCreated, writes: 1},
+		{key: "file", token: "t1", header: []string{headerSHA1, first.Header().Get(headerSHA1)}, code: http.StatusCreated, writes: 1},
+		{key: "file", token: "t1", body: "other", code: http.StatusConflict, writes: 1},
+		{key: "other", token: "t1", body: "data", code: http.StatusConflict, writes: 1},
+		{key: "copy", token: "t2", body: "data", code: http.StatusCreated, writes: 2},
+		{key: "copy", token: "", body: "data", code: http.StatusOK, writes: 3},
+	} {
+		w := post(test.key, test.token, test.body, test.header...)
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("%s %s: want code %d, got %d", test.key, test.token, want, got)
+		}
+		if want, got := test.writes, creates; want != got {
+			t.Errorf("%s %s: want %d writes, got %d", test.key, test.token, want, got)
+		}
+	}
+
+	replay := post("file", "t1", "data")
+	if want, got := first.Body.String(), replay.Body.String(); want != got {
+		t.Errorf("want original response %s, got %s", want, got)
+	}
+	if replay.Header().Get(headerIdempotencyReplayed) == "" {
+		t.Errorf("want %s header", headerIdempotencyReplayed)
+	}
+
+	f, err := fs.Create(context.Background(), b, "file", strings.NewReader("replaced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w := post("file", "t1", "data")
+	if want, got := 4, creates; want != got {
+		t.Errorf("want %d writes as the upload is stored again after the file changed, got %d", want, got)
+	}
+	if w.Header().Get(headerIdempotencyReplayed) != "" {
+		t.Error("want fresh response after the file changed")
+	}
+
+	put := upload("PUT", "put", "t3", "data")
+	if want, got := http.StatusCreated, put.Code; want != got {
+		t.Fatalf("want code %d, got %d", want, got)
+	}
+	put = upload("PUT", "put", "t3", "data")
+	if want, got := 5, creates; want != got {
+		t.Errorf("want %d writes as the PUT is replayed, got %d", want, got)
+	}
+	if put.Header().Get(headerIdempotencyReplayed) == "" {
+		t.Errorf("want %s header for PUT", headerIdempotencyReplayed)
+	}
+}
+
+func TestIdempotentPanic(t *testing.T) {
+	var (
+		b     = ent.NewBucket("retry", ent.Owner{})
+		p     = newMockProvider(b)
+		id    = newIdempotency(time.Hour)
+		calls = 0
+	)
+
+	h := idempotent(id, p, newMockFileSystem(), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			panic("broken")
+		},
+	))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/retry/file?:bucket=retry&:key=file", strings.NewReader("data"))
+		req.Header.Set(headerIdempotencyKey, "t1")
+
+		func() {
+			defer func() { recover() }()
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+
+	if want, got := 2, calls; want != got {
+		t.Errorf("want %d calls as the failed upload is retried, got %d", want, got)
+	}
+	if want, got := 0, len(id.entries); want != got {
+		t.Errorf("want %d remembered uploads, got %d", want, got)
+	}
+}
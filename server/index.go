@@ -0,0 +1,183 @@
+package server
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// indexLimit is the maximum number of files listed for an HTML index, files
+// sorting after them are left out.
+const indexLimit = 1000
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Bucket}}/{{.Dir}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Bucket}}/{{.Dir}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{if not .Dir}}{{.Size}}{{end}}</td><td>{{if not .Dir}}{{.LastModified.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+{{end}}</table>
+{{if .Truncated}}<p>Only the first {{.Limit}} blobs are listed.</p>
+{{end}}</body>
+</html>
+`))
+
+// indexEntry is a file or a directory, a common prefix of several files, in
+// the HTML index of a bucket.
+type indexEntry struct {
+	Name         string
+	Href         string
+	Dir          bool
+	Size         int64
+	LastModified time.Time
+}
+
+// wantsHTML reports if the client of r prefers an HTML page, like browsers
+// navigating to a bucket do.
+func wantsHTML(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, err := mime.ParseMediaType(accept)
+		if err == nil && t == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+// respondIndex renders files of bucket b below dir as HTML page. Files in
+// subdirectories of dir are collapsed to their directory. All links are
+// relative to root, the path of the bucket, and keep the query of r, so a
+// snapshot can be browsed as well. Only the first indexLimit files are listed
+// and all files are closed.
+func respondIndex(
+	w http.ResponseWriter,
+	r *http.Request,
+	b *ent.Bucket,
+	root string,
+	dir string,
+	files ent.Files,
+) {
+	defer func(files ent.Files) {
+		for _, f := range files {
+			f.Close()
+		}
+	}(files)
+
+	var (
+		query     = ""
+		dirs      = map[string]bool{}
+		entries   = []indexEntry{}
+		subdirs   = []indexEntry{}
+		truncated = uint64(len(files)) > indexLimit
+	)
+
+	if truncated {
+		files = files[:indexLimit]
+	}
+
+	if snapshot := r.URL.Query().Get(paramSnapshot); snapshot != "" {
+		query = "?" + url.Values{paramSnapshot: {snapshot}}.Encode()
+	}
+
+	for _, f := range files {
+		name := strings.TrimPrefix(f.Key(), dir)
+
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+			if !dirs[name] {
+				dirs[name] = true
+				subdirs = append(subdirs, indexEntry{
+					Name: name,
+					Href: root + escapeKey(dir+name) + query,
+					Dir:  true,
+				})
+			}
+			continue
+		}
+
+		size, err := fileSize(f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		entries = append(entries, indexEntry{
+			Name:         name,
+			Href:         root + escapeKey(f.Key()) + query,
+			Size:         size,
+			LastModified: f.LastModified(),
+		})
+	}
+
+	sort.Slice(subdirs, func(i, j int) bool { return subdirs[i].Name < subdirs[j].Name })
+
+	parent := ""
+	if dir != "" {
+		parent = root + escapeKey(parentDir(dir)) + query
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	err := indexTemplate.Execute(w, struct {
+		Bucket    string
+		Dir       string
+		Parent    string
+		Entries   []indexEntry
+		Truncated bool
+		Limit     int
+	}{
+		Bucket:    b.Name,
+		Dir:       dir,
+		Parent:    parent,
+		Entries:   append(subdirs, entries...),
+		Truncated: truncated,
+		Limit:     indexLimit,
+	})
+	if err != nil {
+		log.Printf("rendering index of %s failed: %s", b.Name, err)
+	}
+}
+
+// parentDir returns the directory dir is in, with a trailing slash unless it
+// is the root of the bucket.
+func parentDir(dir string) string {
+	dir = strings.TrimSuffix(dir, "/")
+	i := strings.LastIndex(dir, "/")
+	if i < 0 {
+		return ""
+	}
+	return dir[:i+1]
+}
+
+// prefixDir returns the directory a listing of prefix is in.
+func prefixDir(prefix string) string {
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// escapeKey escapes every segment of key for use in a path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
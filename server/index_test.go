@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestIndex(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("browse", ent.Owner{})
+		fs = newDiskFS(tmp)
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	for _, key := range []string{"a.txt", "dir/b c.txt", "dir/sub/c.txt"} {
+		f, err := fs.Create(context.Background(), b, key, bytes.NewBufferString(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	r.Get(routeFile, handleGet(p, fs))
+	r.Get(routeBucket, handleFileList(p, fs))
+
+	for path, want := range map[string][]string{
+		"/browse": {
+			`<a href="/browse/a.txt">a.txt</a>`,
+			`<a href="/browse/dir/">dir/</a>`,
+		},
+		"/browse/dir/": {
+			`<a href="/browse/">../</a>`,
+			`<a href="/browse/dir/b%20c.txt">b c.txt</a>`,
+			`<a href="/browse/dir/sub/">sub/</a>`,
+		},
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if want, got := http.StatusOK, w.Code; want != got {
+			t.Fatalf("%s: want %d, got %d", path, want, got)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("%s: want HTML, got %s", path, ct)
+		}
+
+		for _, link := range want {
+			if !strings.Contains(w.Body.String(), link) {
+				t.Errorf("%s: missing %s in\n%s", path, link, w.Body.String())
+			}
+		}
+	}
+
+	// Clients not asking for HTML keep getting JSON.
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/browse", nil))
+
+	if want, got := "application/json", w.Header().Get("Content-Type"); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestIndexTruncated(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("browse", ent.Owner{})
+		fs = newDiskFS(tmp)
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	for i := 0; i <= indexLimit; i++ {
+		f, err := fs.Create(context.Background(), b, fmt.Sprintf("many/%04d", i), bytes.NewBufferString("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	r.Get(routeFile, handleGet(p, fs))
+
+	req := httptest.NewRequest("GET", "/browse/many/", nil)
+	req.Header.Set("Accept", "text/html")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if want, got := http.StatusOK, w.Code; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	body := w.Body.String()
+	if last := fmt.Sprintf(">%04d<", indexLimit-1); !strings.Contains(body, last) {
+		t.Errorf("want %s listed", last)
+	}
+	if first := fmt.Sprintf(">%04d<", indexLimit); strings.Contains(body, first) {
+		t.Errorf("want %s left out", first)
+	}
+	if want := fmt.Sprintf("Only the first %d blobs are listed.", indexLimit); !strings.Contains(body, want) {
+		t.Errorf("want %q in\n%s", want, body)
+	}
+}
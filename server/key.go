@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/soundcloud/ent/lib"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxKeySegment is the longest segment between two slashes of a key, as most
+// filesystems can't store longer file names.
+const maxKeySegment = 255
+
+// keyPolicy decides which keys are stored. Keys never contain empty, "." or
+// ".." segments, control characters or invalid UTF-8, so they can't escape
+// their bucket or be unrepresentable on disk. On top of that keys can be
+// limited to maxLength bytes and to the characters matched by pattern, and
+// normalized to Unicode NFC, so the same name typed on different systems
+// refers to the same file.
+type keyPolicy struct {
+	maxLength int
+	pattern   *regexp.Regexp
+	nfc       bool
+}
+
+// newKeyPolicy returns a keyPolicy allowing keys of up to maxLength bytes, no
+// limit if 0, consisting of the characters matched by the regular expression
+// pattern, any if empty.
+func newKeyPolicy(maxLength int, pattern string, nfc bool) (*keyPolicy, error) {
+	kp := &keyPolicy{maxLength: maxLength, nfc: nfc}
+
+	if pattern != "" {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid key pattern: %s", err)
+		}
+		kp.pattern = re
+	}
+
+	return kp, nil
+}
+
+// normalize returns key in the form it is stored in.
+func (kp *keyPolicy) normalize(key string) string {
+	if kp.nfc {
+		return norm.NFC.String(key)
+	}
+	return key
+}
+
+// check returns the normalized key, or an error wrapping ent.ErrInvalidKey
+// explaining why key is rejected.
+func (kp *keyPolicy) check(key string) (string, error) {
+	key, err := kp.lookup(key)
+	if err != nil {
+		return "", err
+	}
+
+	if kp.maxLength > 0 && len(key) > kp.maxLength {
+		return "", fmt.Errorf("%w: longer than %d bytes", ent.ErrInvalidKey, kp.maxLength)
+	}
+
+	return key, nil
+}
+
+// lookup returns the normalized key, or an error wrapping ent.ErrInvalidKey
+// if no file can be stored for key. Unlike check it doesn't limit the length,
+// so files stored before the limit was lowered stay accessible.
+func (kp *keyPolicy) lookup(key string) (string, error) {
+	if !utf8.ValidString(key) {
+		return "", fmt.Errorf("%w: not valid UTF-8", ent.ErrInvalidKey)
+	}
+
+	key = kp.normalize(key)
+
+	err := validKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if kp.pattern != nil && !kp.pattern.MatchString(key) {
+		return "", fmt.Errorf("%w: does not match %s", ent.ErrInvalidKey, kp.pattern)
+	}
+
+	return key, nil
+}
+
+// validKey fails with an error wrapping ent.ErrInvalidKey if key could
+// escape its bucket or can't be stored on disk, no matter the keyPolicy. It
+// guards keys which don't arrive in a cleaned URL path, like those of
+// archives and request bodies.
+func validKey(key string) error {
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("%w: not valid UTF-8", ent.ErrInvalidKey)
+	}
+	if key == "" {
+		return fmt.Errorf("%w: empty", ent.ErrInvalidKey)
+	}
+
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: contains control character %U", ent.ErrInvalidKey, r)
+		}
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		switch {
+		case segment == "":
+			return fmt.Errorf("%w: contains empty segment", ent.ErrInvalidKey)
+		case segment == "." || segment == "..":
+			return fmt.Errorf("%w: contains %q segment", ent.ErrInvalidKey, segment)
+		case len(segment) > maxKeySegment:
+			return fmt.Errorf("%w: segment longer than %d bytes", ent.ErrInvalidKey, maxKeySegment)
+		}
+	}
+
+	return nil
+}
+
+// keyPolicyFS applies a keyPolicy to the FileSystem it wraps. New files are
+// only created for valid keys, files are only opened and deleted for keys
+// they could have been stored for and all keys are normalized before being
+// passed on.
+type keyPolicyFS struct {
+	ent.FileSystem
+	kp *keyPolicy
+}
+
+func (fs *keyPolicyFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *keyPolicyFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	key, err := fs.kp.check(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.FileSystem.Create(ctx, bucket, key, data)
+}
+
+func (fs *keyPolicyFS) Open(ctx context.Context, bucket *ent.Bucket, key string) (ent.File, error) {
+	key, err := fs.kp.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.FileSystem.Open(ctx, bucket, key)
+}
+
+func (fs *keyPolicyFS) Delete(ctx context.Context, bucket *ent.Bucket, key string) error {
+	key, err := fs.kp.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	return fs.FileSystem.Delete(ctx, bucket, key)
+}
+
+func (fs *keyPolicyFS) List(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	return fs.FileSystem.List(ctx, bucket, fs.kp.normalize(prefix), limit, sortStrategy)
+}
+
+// checkKey validates and normalizes key with the keyPolicy of the first
+// keyPolicyFS in the chain of FileSystems wrapped by fs, so handlers reject
+// invalid keys before reading any data and respond with the stored key.
+// Without keyPolicyFS key is returned as is.
+func checkKey(fs ent.FileSystem, key string) (string, error) {
+	kp, ok := keyPolicyOf(fs)
+	if !ok {
+		return key, nil
+	}
+	return kp.check(key)
+}
+
+// lookupKey normalizes the key of a stored file with the keyPolicy of the
+// first keyPolicyFS in the chain of FileSystems wrapped by fs, so all layers
+// above it and hooks see the key as stored. Without keyPolicyFS key is
+// returned as is.
+func lookupKey(fs ent.FileSystem, key string) (string, error) {
+	kp, ok := keyPolicyOf(fs)
+	if !ok {
+		return key, nil
+	}
+	return kp.lookup(key)
+}
+
+// keyPolicyOf returns the keyPolicy of the first keyPolicyFS in the chain of
+// FileSystems wrapped by fs.
+func keyPolicyOf(fs ent.FileSystem) (*keyPolicy, bool) {
+	for {
+		if k, ok := fs.(*keyPolicyFS); ok {
+			return k.kp, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
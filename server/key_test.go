@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestKeyPolicyCheck(t *testing.T) {
+	kp, err := newKeyPolicy(16, `[a-z0-9/._\x{e9}]+`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key  string
+		want string
+		err  error
+	}{
+		{key: "a/b/c.txt", want: "a/b/c.txt"},
+		{key: "café", want: "café"},
+		{key: "", err: ent.ErrInvalidKey},
+		{key: "a//b", err: ent.ErrInvalidKey},
+		{key: "/a", err: ent.ErrInvalidKey},
+		{key: "a/", err: ent.ErrInvalidKey},
+		{key: "a/../b", err: ent.ErrInvalidKey},
+		{key: "./a", err: ent.ErrInvalidKey},
+		{key: "a\x00b", err: ent.ErrInvalidKey},
+		{key: "a\nb", err: ent.ErrInvalidKey},
+		{key: "a\xffb", err: ent.ErrInvalidKey},
+		{key: "UPPER", err: ent.ErrInvalidKey},
+		{key: "much/too/long/key", err: ent.ErrInvalidKey},
+	} {
+		got, err := kp.check(test.key)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q: want error %v, got %v", test.key, test.err, err)
+		}
+		if want := test.want; want != got {
+			t.Errorf("%q: want key %q, got %q", test.key, want, got)
+		}
+	}
+
+	kp, err = newKeyPolicy(0, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.check(strings.Repeat("a", maxKeySegment+1)); !errors.Is(err, ent.ErrInvalidKey) {
+		t.Errorf("want %s for overlong segment, got %v", ent.ErrInvalidKey, err)
+	}
+	if got, _ := kp.check("café"); got != "café" {
+		t.Errorf("want key not normalized, got %q", got)
+	}
+
+	if _, err := newKeyPolicy(0, "[", false); err == nil {
+		t.Error("want error for invalid pattern")
+	}
+}
+
+func TestHandleCreateInvalidKey(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	kp, err := newKeyPolicy(0, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		b  = ent.NewBucket("keys", ent.Owner{})
+		fs = &keyPolicyFS{FileSystem: newDiskFS(tmp), kp: kp}
+		r  = pat.New()
+	)
+
+	r.Get(routeFile, handleGet(newMockProvider(b), fs))
+	r.Post(routeFile, handleCreate(newMockProvider(b), fs))
+
+	create := func(path string) (int, ent.ResponseCreated) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("data")))
+
+		resp := ent.ResponseCreated{}
+		if w.Code == http.StatusCreated {
+			err := json.NewDecoder(w.Body).Decode(&resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, resp
+	}
+
+	for _, path := range []string{"/keys/a%01b", "/keys/a%0Ab", "/keys/a%C3"} {
+		if code, _ := create(path); code != http.StatusBadRequest {
+			t.Errorf("%s: want code %d, got %d", path, http.StatusBadRequest, code)
+		}
+	}
+
+	code, resp := create("/keys/cafe%CC%81")
+	if code != http.StatusCreated {
+		t.Fatalf("want code %d, got %d", http.StatusCreated, code)
+	}
+	if want, got := "café", resp.File.Key; want != got {
+		t.Errorf("want key %q, got %q", want, got)
+	}
+
+	for _, path := range []string{"/keys/caf%C3%A9", "/keys/cafe%CC%81"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if want, got := http.StatusOK, w.Code; want != got {
+			t.Errorf("%s: want code %d, got %d", path, want, got)
+		}
+	}
+}
+
+func TestHandleLookupKey(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-key-lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	kp, err := newKeyPolicy(0, DefaultKeyPattern+`|caf\x{e9}`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		b    = ent.NewBucket("keys", ent.Owner{})
+		p    = newMockProvider(b)
+		hook = &recordHook{}
+		fs   = &hookFS{
+			FileSystem: &keyPolicyFS{FileSystem: newDiskFS(tmp), kp: kp},
+			hooks:      []Hook{hook},
+		}
+		r = pat.New()
+	)
+
+	r.Get(routeFile, handleGet(p, fs))
+	r.Post(routeFile, handleCreate(p, fs))
+	r.Delete(routeFile, handleDelete(p, fs))
+
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/keys/a%20b", http.StatusBadRequest},
+		{"DELETE", "/keys/a%01b", http.StatusBadRequest},
+		{"POST", "/keys/caf%C3%A9", http.StatusCreated},
+		{"DELETE", "/keys/cafe%CC%81", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader("data")))
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("%s %s: want code %d, got %d", test.method, test.path, want, got)
+		}
+	}
+
+	// Hooks see the key as stored.
+	if want, got := 3, len(hook.events); want != got {
+		t.Fatalf("want %d events, got %v", want, hook.events)
+	}
+	if want, got := "delete  keys/café 4 ", hook.events[2]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+const headerRetryAfter = "Retry-After"
+
+var limitRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Program,
+		Name:      "limit_rejections_total",
+		Help:      "Total number of requests rejected for exceeding a concurrency limit.",
+	},
+	[]string{"limiter"},
+)
+
+// limiter caps the number of requests served at the same time in total and
+// for every bucket. Requests beyond a cap queue for a free slot up to wait
+// before they are rejected.
+type limiter struct {
+	name   string
+	global chan struct{}
+	bucket int
+	wait   time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucketSlots
+}
+
+// bucketSlots are the slots of a bucket, which are dropped once no request
+// holds or waits for one anymore.
+type bucketSlots struct {
+	slots chan struct{}
+	refs  int
+}
+
+// newLimiter returns a limiter allowing max requests in total and bucket
+// requests per bucket. Either cap is disabled if zero. If both are, no
+// limiter is returned.
+func newLimiter(name string, max, bucket int, wait time.Duration) *limiter {
+	if max <= 0 && bucket <= 0 {
+		return nil
+	}
+
+	l := &limiter{
+		name:    name,
+		bucket:  bucket,
+		wait:    wait,
+		buckets: map[string]*bucketSlots{},
+	}
+
+	if max > 0 {
+		l.global = make(chan struct{}, max)
+	}
+
+	return l
+}
+
+// acquire waits for a slot of bucket and a global one. The returned function
+// gives both back and has to be called once the request is served.
+func (l *limiter) acquire(ctx context.Context, bucket string) (func(), error) {
+	waitCtx, cancel := context.WithTimeout(ctx, l.wait)
+	defer cancel()
+
+	var bs *bucketSlots
+
+	// The slot of the bucket is taken first, so requests queueing for a busy
+	// bucket don't hold global slots needed by other buckets.
+	if l.bucket > 0 {
+		bs = l.ref(bucket)
+
+		if !acquireSlot(waitCtx, bs.slots) {
+			l.unref(bucket)
+			return nil, l.reject(ctx)
+		}
+	}
+
+	if l.global != nil && !acquireSlot(waitCtx, l.global) {
+		if bs != nil {
+			<-bs.slots
+			l.unref(bucket)
+		}
+		return nil, l.reject(ctx)
+	}
+
+	return func() {
+		if l.global != nil {
+			<-l.global
+		}
+		if bs != nil {
+			<-bs.slots
+			l.unref(bucket)
+		}
+	}, nil
+}
+
+func (l *limiter) ref(bucket string) *bucketSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bs, ok := l.buckets[bucket]
+	if !ok {
+		bs = &bucketSlots{slots: make(chan struct{}, l.bucket)}
+		l.buckets[bucket] = bs
+	}
+	bs.refs++
+
+	return bs
+}
+
+func (l *limiter) unref(bucket string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bs := l.buckets[bucket]
+	bs.refs--
+	if bs.refs == 0 {
+		delete(l.buckets, bucket)
+	}
+}
+
+// reject returns the error of a request which didn't get a slot. Requests
+// given up by the client are not counted as rejected.
+func (l *limiter) reject(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	limitRejections.WithLabelValues(l.name).Inc()
+
+	return ent.ErrOverloaded
+}
+
+// acquireSlot takes a free slot of slots if there is one and otherwise waits
+// for one until ctx is done.
+func acquireSlot(ctx context.Context, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// limitConcurrency only passes requests on to next while l has a slot for
+// them. The buckets of different tenants sharing l are told apart by scope.
+// Rejected requests are answered with 503 and asked to retry after the wait
+// of l. If l is nil all requests are passed on.
+func limitConcurrency(l *limiter, scope string, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := l.acquire(r.Context(), scope+"/"+r.URL.Query().Get(keyBucket))
+		if err != nil {
+			retry := int(math.Ceil(l.wait.Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+
+			w.Header().Set(headerRetryAfter, strconv.Itoa(retry))
+			respondError(w, r, err)
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
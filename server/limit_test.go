@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestLimiter(t *testing.T) {
+	var (
+		ctx = context.Background()
+		l   = newLimiter("test", 2, 1, 10*time.Millisecond)
+	)
+
+	releaseA, err := l.acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket is at its limit while others are not.
+	_, err = l.acquire(ctx, "a")
+	if want, got := ent.ErrOverloaded, err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	releaseB, err := l.acquire(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All global slots are taken.
+	_, err = l.acquire(ctx, "c")
+	if want, got := ent.ErrOverloaded, err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	releaseA()
+	releaseB()
+
+	if want, got := 0, len(l.buckets); want != got {
+		t.Errorf("want %d buckets tracked, got %d", want, got)
+	}
+
+	release, err := l.acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestLimiterQueue(t *testing.T) {
+	l := newLimiter("test", 1, 0, time.Second)
+
+	release, err := l.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, release)
+
+	release, err = l.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("want queued request to get a slot, got %v", err)
+	}
+	release()
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	var (
+		l       = newLimiter("test", 0, 1, 10*time.Millisecond)
+		r       = pat.New()
+		started = make(chan struct{})
+		finish  = make(chan struct{})
+	)
+
+	r.Add("GET", routeBucket, limitConcurrency(l, "", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-finish
+		},
+	)))
+
+	go r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/busy", nil))
+	<-started
+	defer close(finish)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/busy", nil))
+
+	if want, got := http.StatusServiceUnavailable, w.Code; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if want, got := "1", w.Header().Get(headerRetryAfter); want != got {
+		t.Errorf("want Retry-After %q, got %q", want, got)
+	}
+}
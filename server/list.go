@@ -0,0 +1,336 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// listConcurrency is the number of directories of a bucket read at the same
+// time while listing it.
+const listConcurrency = 16
+
+// errStopWalk is returned by the functions passed to walkDir to end a walk
+// early without a failure.
+var errStopWalk = errors.New("walk stopped")
+
+// listDir walks the directories of a bucket below bucketDir concurrently
+// and lists the files with keys starting with prefix. For strategies
+// implementing ent.Comparer only the limit files sorting first are kept while
+// walking and an unsorted listing stops as soon as it has enough files. Files
+// are opened once they are used.
+func listDir(
+	ctx context.Context,
+	bucketDir string,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	var (
+		unsorted    = ent.IsNoOp(sortStrategy)
+		cmp, ranked = sortStrategy.(ent.Comparer)
+		top         = &fileHeap{cmp: cmp}
+	)
+
+	err := walkDir(ctx, bucketDir, prefix, func(f ent.File) error {
+		switch {
+		case unsorted || !ranked || limit == defaultLimit:
+			top.files = append(top.files, f)
+		case uint64(len(top.files)) < limit:
+			heap.Push(top, f)
+		case cmp.Before(f, top.files[0]):
+			top.files[0] = f
+			heap.Fix(top, 0)
+		}
+
+		if unsorted && uint64(len(top.files)) >= limit {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+
+	files := top.files
+	sortStrategy.Sort(files)
+
+	if limit < uint64(len(files)) {
+		files = files[:limit]
+	}
+
+	return files, nil
+}
+
+// walkDir walks the directories of a bucket below bucketDir concurrently and
+// passes every file with a key starting with prefix to fn, in no particular
+// order. The walk ends with the first error returned by fn.
+func walkDir(
+	ctx context.Context,
+	bucketDir string,
+	prefix string,
+	fn func(ent.File) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walker{
+		ctx:    ctx,
+		cancel: cancel,
+		prefix: strings.TrimLeft(prefix, "/"),
+		slots:  make(chan struct{}, listConcurrency),
+		files:  make(chan *lazyFile, 1024),
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.dir(bucketDir, "")
+	}()
+	go func() {
+		w.wg.Wait()
+		close(w.files)
+	}()
+
+	var err error
+
+	for f := range w.files {
+		if err != nil {
+			continue
+		}
+
+		err = fn(f)
+		if err != nil {
+			cancel()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return w.err
+}
+
+// walker reads the directories of a bucket concurrently, up to the number of
+// its slots, and sends all files with the prefix to files.
+type walker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	prefix string
+	slots  chan struct{}
+	files  chan *lazyFile
+	wg     sync.WaitGroup
+
+	errOnce sync.Once
+	err     error
+}
+
+// dir lists the directory at path, which holds the keys starting with rel.
+func (w *walker) dir(path, rel string) {
+	if w.ctx.Err() != nil {
+		w.fail(w.ctx.Err())
+		return
+	}
+
+	// Buckets without any files yet have no directory and directories can
+	// be removed while they are walked, both hold no files.
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	for _, e := range entries {
+		var (
+			key  = rel + e.Name()
+			full = filepath.Join(path, e.Name())
+		)
+
+		if e.IsDir() {
+			sub := key + "/"
+
+			// Only directories which can hold keys with the prefix are
+			// walked.
+			if !strings.HasPrefix(sub, w.prefix) && !strings.HasPrefix(w.prefix, sub) {
+				continue
+			}
+
+			select {
+			case w.slots <- struct{}{}:
+				w.wg.Add(1)
+				go func() {
+					defer w.wg.Done()
+					defer func() { <-w.slots }()
+					w.dir(full, sub)
+				}()
+			default:
+				w.dir(full, sub)
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		if rel == "" && strings.HasPrefix(e.Name(), pendingPrefix) {
+			continue
+		}
+
+		info, err := e.Info()
+		if os.IsNotExist(err) {
+			// Removed since the directory was read.
+			continue
+		}
+		if err != nil {
+			w.fail(err)
+			return
+		}
+
+		select {
+		case w.files <- &lazyFile{path: full, key: key, lastModified: info.ModTime(), size: info.Size()}:
+		case <-w.ctx.Done():
+			w.fail(w.ctx.Err())
+			return
+		}
+	}
+}
+
+// fail stops the walk and records err if it is the first failure.
+func (w *walker) fail(err error) {
+	w.errOnce.Do(func() {
+		w.err = err
+		w.cancel()
+	})
+}
+
+// fileHeap keeps the files sorting last according to cmp on top, so they
+// can be dropped once better ones are found.
+type fileHeap struct {
+	files ent.Files
+	cmp   ent.Comparer
+}
+
+func (h *fileHeap) Len() int {
+	return len(h.files)
+}
+
+func (h *fileHeap) Less(i, j int) bool {
+	return h.cmp.Before(h.files[j], h.files[i])
+}
+
+func (h *fileHeap) Swap(i, j int) {
+	h.files[i], h.files[j] = h.files[j], h.files[i]
+}
+
+func (h *fileHeap) Push(x interface{}) {
+	h.files = append(h.files, x.(ent.File))
+}
+
+func (h *fileHeap) Pop() interface{} {
+	f := h.files[len(h.files)-1]
+	h.files = h.files[:len(h.files)-1]
+	return f
+}
+
+// lazyFile is a listed file, which is only opened once its content is
+// accessed. Listings of many files therefore don't hold a descriptor for
+// each of them.
+type lazyFile struct {
+	path         string
+	key          string
+	lastModified time.Time
+	size         int64
+
+	f *file
+}
+
+func (f *lazyFile) open() error {
+	if f.f != nil {
+		return nil
+	}
+
+	fd, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return ent.ErrFileNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	f.f = newFile(fd, f.key)
+	f.f.lastModified = f.lastModified
+
+	return nil
+}
+
+func (f *lazyFile) Key() string {
+	return f.key
+}
+
+func (f *lazyFile) LastModified() time.Time {
+	return f.lastModified
+}
+
+// Size returns the size the file had when it was listed, without opening
+// it.
+func (f *lazyFile) Size() (int64, error) {
+	if f.f != nil {
+		return fileSize(f.f)
+	}
+	return f.size, nil
+}
+
+func (f *lazyFile) Hash() ([]byte, error) {
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f.f.Hash()
+}
+
+func (f *lazyFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.f.Read(p)
+}
+
+func (f *lazyFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.f.Seek(offset, whence)
+}
+
+func (f *lazyFile) Write(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.f.Write(p)
+}
+
+// WriteTo keeps the io.WriterTo of the opened file in use.
+func (f *lazyFile) WriteTo(w io.Writer) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, f.f.File)
+}
+
+func (f *lazyFile) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
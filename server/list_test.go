@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestListDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		now  = time.Now()
+		keys = []string{}
+	)
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("dir%d/sub%d/file%03d", i%7, i%3, i)
+		path := filepath.Join(tmp, key)
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(key), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modified := now.Add(time.Duration(i) * time.Second)
+		err = os.Chtimes(path, modified, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	// Uploads in progress are not listed.
+	err = ioutil.WriteFile(filepath.Join(tmp, pendingPrefix+"123"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := listDir(context.Background(), tmp, "", defaultLimit, ent.ByKeyStrategy(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := len(keys), len(all); want != got {
+		t.Fatalf("want %d files, got %d", want, got)
+	}
+
+	for _, test := range []struct {
+		strategy ent.SortStrategy
+		prefix   string
+		limit    uint64
+	}{
+		{ent.ByKeyStrategy(true), "", 10},
+		{ent.ByKeyStrategy(false), "dir3/", 5},
+		{ent.ByLastModifiedStrategy(false), "", 7},
+		{ent.ByLastModifiedStrategy(true), "dir1/sub2/", 3},
+	} {
+		want := ent.Files{}
+		for _, f := range all {
+			if len(f.Key()) >= len(test.prefix) && f.Key()[:len(test.prefix)] == test.prefix {
+				want = append(want, f)
+			}
+		}
+		test.strategy.Sort(want)
+		want = want[:test.limit]
+
+		got, err := listDir(context.Background(), tmp, test.prefix, test.limit, test.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(want) != len(got) {
+			t.Fatalf("%q: want %d files, got %d", test.prefix, len(want), len(got))
+		}
+		for i := range want {
+			if want[i].Key() != got[i].Key() {
+				t.Errorf("%q: want %s at %d, got %s", test.prefix, want[i].Key(), i, got[i].Key())
+			}
+		}
+	}
+
+	// Strategies without ent.Comparer are sorted after listing all files.
+	sorted, err := listDir(context.Background(), tmp, "", 3, sortOnly{ent.ByKeyStrategy(false)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(ent.Files{}, all...)
+	ent.ByKeyStrategy(false).Sort(want)
+	if want, got := fileKeys(want[:3]), fileKeys(sorted); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	got, err := listDir(context.Background(), tmp, "dir2/", 4, ent.NoOpStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 4, len(got); want != got {
+		t.Fatalf("want %d files, got %d", want, got)
+	}
+
+	for _, f := range got {
+		size, err := fileSize(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := int64(len(f.Key())), size; want != got {
+			t.Errorf("%s: want size %d, got %d", f.Key(), want, got)
+		}
+
+		if lf := f.(*lazyFile); lf.f != nil {
+			t.Errorf("want %s not to be opened", f.Key())
+		}
+	}
+
+	raw, err := ioutil.ReadAll(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := got[0].Key(), string(raw); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	got[0].Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = listDir(ctx, tmp, "", defaultLimit, ent.NoOpStrategy())
+	if want, got := context.Canceled, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+// sortOnly hides all methods of the wrapped strategy but Sort.
+type sortOnly struct {
+	s ent.SortStrategy
+}
+
+func (s sortOnly) Sort(files ent.Files) {
+	s.s.Sort(files)
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+var listCacheRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Program,
+		Name:      "list_cache_requests_total",
+		Help:      "Total number of lookups in the cache of listings.",
+	},
+	[]string{"result"},
+)
+
+// listCacheFS keeps the listings of the wrapped FileSystem for ttl, so
+// repeated listings of the same prefix, like the ones of dashboards, don't
+// walk the FileSystem every time. A listing holds all files with its prefix
+// and is sorted and limited for every request. Listings are dropped once a
+// file with their prefix is created or deleted. At most size listings are
+// kept, least recently used listings are evicted first.
+type listCacheFS struct {
+	ent.FileSystem
+
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[listCacheKey]*list.Element
+
+	// generations counts the changes of every bucket, so listings started
+	// before a change are not cached after it.
+	generations map[string]uint64
+}
+
+type listCacheKey struct {
+	bucket string
+	prefix string
+}
+
+// listCacheEntry is a cached listing.
+type listCacheEntry struct {
+	key     listCacheKey
+	files   []listedFile
+	expires time.Time
+}
+
+// listedFile is the metadata of a file in a cached listing.
+type listedFile struct {
+	key          string
+	lastModified time.Time
+}
+
+func newListCacheFS(fs ent.FileSystem, ttl time.Duration, size int) *listCacheFS {
+	return &listCacheFS{
+		FileSystem:  fs,
+		ttl:         ttl,
+		size:        size,
+		lru:         list.New(),
+		entries:     map[listCacheKey]*list.Element{},
+		generations: map[string]uint64{},
+	}
+}
+
+func (fs *listCacheFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *listCacheFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	f, err := fs.FileSystem.Create(ctx, bucket, key, data)
+
+	// Failed uploads might have replaced or removed the file as well.
+	fs.invalidate(bucket.Name, key)
+
+	return f, err
+}
+
+func (fs *listCacheFS) Delete(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) error {
+	err := fs.FileSystem.Delete(ctx, bucket, key)
+
+	fs.invalidate(bucket.Name, key)
+
+	return err
+}
+
+func (fs *listCacheFS) List(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	key := listCacheKey{bucket: bucket.Name, prefix: prefix}
+
+	listed, generation, ok := fs.get(key)
+	if ok {
+		listCacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		listCacheRequests.WithLabelValues("miss").Inc()
+
+		files, err := fs.FileSystem.List(ctx, bucket, prefix, defaultLimit, ent.NoOpStrategy())
+		if err != nil {
+			return nil, err
+		}
+
+		listed = make([]listedFile, len(files))
+		for i, f := range files {
+			listed[i] = listedFile{key: f.Key(), lastModified: f.LastModified()}
+			f.Close()
+		}
+
+		fs.put(key, listed, generation)
+	}
+
+	files := make(ent.Files, len(listed))
+	for i, l := range listed {
+		files[i] = &cachedFile{
+			ctx:          ctx,
+			fs:           fs.FileSystem,
+			bucket:       bucket,
+			key:          l.key,
+			lastModified: l.lastModified,
+		}
+	}
+
+	sortStrategy.Sort(files)
+
+	if limit < uint64(len(files)) {
+		files = files[:limit]
+	}
+
+	return files, nil
+}
+
+// get returns the cached listing for key if there is one which did not
+// expire yet, along with the current generation of its bucket.
+func (fs *listCacheFS) get(key listCacheKey) ([]listedFile, uint64, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	generation := fs.generations[key.bucket]
+
+	e, ok := fs.entries[key]
+	if !ok {
+		return nil, generation, false
+	}
+
+	entry := e.Value.(*listCacheEntry)
+	if time.Now().After(entry.expires) {
+		fs.lru.Remove(e)
+		delete(fs.entries, key)
+		return nil, generation, false
+	}
+
+	fs.lru.MoveToFront(e)
+
+	return entry.files, generation, true
+}
+
+// put caches files as listing for key unless its bucket changed since the
+// generation the listing was started in.
+func (fs *listCacheFS) put(key listCacheKey, files []listedFile, generation uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.generations[key.bucket] != generation {
+		return
+	}
+
+	if e, ok := fs.entries[key]; ok {
+		fs.lru.Remove(e)
+	}
+
+	fs.entries[key] = fs.lru.PushFront(&listCacheEntry{
+		key:     key,
+		files:   files,
+		expires: time.Now().Add(fs.ttl),
+	})
+
+	for fs.lru.Len() > fs.size {
+		e := fs.lru.Back()
+		fs.lru.Remove(e)
+		delete(fs.entries, e.Value.(*listCacheEntry).key)
+	}
+}
+
+// invalidate drops all listings of bucket which include key.
+func (fs *listCacheFS) invalidate(bucket, key string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.generations[bucket]++
+
+	for k, e := range fs.entries {
+		if k.bucket == bucket && strings.HasPrefix(key, k.prefix) {
+			fs.lru.Remove(e)
+			delete(fs.entries, k)
+		}
+	}
+}
+
+// cachedFile is a file of a cached listing, which is opened once its content
+// is accessed.
+type cachedFile struct {
+	ctx          context.Context
+	fs           ent.FileSystem
+	bucket       *ent.Bucket
+	key          string
+	lastModified time.Time
+
+	f ent.File
+}
+
+func (f *cachedFile) open() error {
+	if f.f != nil {
+		return nil
+	}
+
+	file, err := f.fs.Open(f.ctx, f.bucket, f.key)
+	if err != nil {
+		return err
+	}
+	f.f = file
+
+	return nil
+}
+
+func (f *cachedFile) Key() string {
+	return f.key
+}
+
+func (f *cachedFile) LastModified() time.Time {
+	return f.lastModified
+}
+
+func (f *cachedFile) Hash() ([]byte, error) {
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f.f.Hash()
+}
+
+func (f *cachedFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.f.Read(p)
+}
+
+func (f *cachedFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.f.Seek(offset, whence)
+}
+
+func (f *cachedFile) Write(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.f.Write(p)
+}
+
+func (f *cachedFile) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
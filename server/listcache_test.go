@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestListCacheFS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-listcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx    = context.Background()
+		b      = ent.NewBucket("bit", ent.Owner{})
+		counts = &countingFS{FileSystem: newDiskFS(tmp)}
+		fs     = newListCacheFS(counts, time.Hour, 10)
+	)
+
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		_, err := fs.Create(ctx, b, key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(prefix string, limit uint64, s ent.SortStrategy) []string {
+		files, err := fs.List(ctx, b, prefix, limit, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for _, f := range files {
+			keys = append(keys, f.Key())
+			f.Close()
+		}
+		return keys
+	}
+
+	if want, got := []string{"a/1", "a/2"}, list("a/", defaultLimit, ent.ByKeyStrategy(true)); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := []string{"a/2"}, list("a/", 1, ent.ByKeyStrategy(false)); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := 1, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+
+	// Changes outside of the prefix keep the listing.
+	_, err = fs.Create(ctx, b, "b/2", strings.NewReader("b/2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list("a/", defaultLimit, ent.NoOpStrategy())
+	if want, got := 1, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+
+	_, err = fs.Create(ctx, b, "a/3", strings.NewReader("a/3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"a/1", "a/2", "a/3"}, list("a/", defaultLimit, ent.ByKeyStrategy(true)); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := 2, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+
+	err = fs.Delete(ctx, b, "a/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"a/2", "a/3"}, list("a/", defaultLimit, ent.ByKeyStrategy(true)); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := 3, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+
+	// Files of cached listings are read from the FileSystem.
+	files, err := fs.List(ctx, b, "a/3", defaultLimit, ent.NoOpStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(files); want != got {
+		t.Fatalf("want %d files, got %d", want, got)
+	}
+	data, err := ioutil.ReadAll(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[0].Close()
+	if want, got := "a/3", string(data); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestListCacheFSExpiry(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-listcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx    = context.Background()
+		b      = ent.NewBucket("bit", ent.Owner{})
+		counts = &countingFS{FileSystem: newDiskFS(tmp)}
+		fs     = newListCacheFS(counts, 10*time.Millisecond, 1)
+	)
+
+	for i := 0; i < 2; i++ {
+		_, err := fs.List(ctx, b, "", defaultLimit, ent.NoOpStrategy())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, got := 1, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = fs.List(ctx, b, "", defaultLimit, ent.NoOpStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+
+	// Only size listings are kept.
+	_, err = fs.List(ctx, b, "a/", defaultLimit, ent.NoOpStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.List(ctx, b, "", defaultLimit, ent.NoOpStrategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 4, counts.lists; want != got {
+		t.Errorf("want %d listings of the FileSystem, got %d", want, got)
+	}
+}
+
+// countingFS counts the listings of the wrapped FileSystem.
+type countingFS struct {
+	ent.FileSystem
+	lists int
+}
+
+func (fs *countingFS) List(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	fs.lists++
+	return fs.FileSystem.List(ctx, bucket, prefix, limit, sortStrategy)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/pat"
+)
+
+// A Role is a set of the routes a listener serves.
+type Role uint8
+
+// Roles of listeners, combined to serve several kinds of routes.
+const (
+	// RoleRead serves all GET and HEAD routes of buckets and files, like
+	// downloads, listings and exports, along with /readyz.
+	RoleRead Role = 1 << iota
+	// RoleWrite serves all routes changing buckets and files, like uploads,
+	// deletes, imports and snapshots, along with /readyz.
+	RoleWrite
+	// RoleAdmin serves the admin dashboard at /_admin/ and /metrics.
+	RoleAdmin
+
+	RoleAll = RoleRead | RoleWrite | RoleAdmin
+)
+
+var roleNames = map[string]Role{
+	"read":  RoleRead,
+	"write": RoleWrite,
+	"admin": RoleAdmin,
+	"all":   RoleAll,
+}
+
+// ParseRole returns the Role of the names joined by "+", like "read+write".
+func ParseRole(s string) (Role, error) {
+	var role Role
+
+	for _, name := range strings.Split(s, "+") {
+		r, ok := roleNames[strings.TrimSpace(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown role %q", name)
+		}
+		role |= r
+	}
+
+	return role, nil
+}
+
+func (r Role) String() string {
+	names := []string{}
+	for _, name := range []string{"read", "write", "admin"} {
+		if r&roleNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// A Listener is the part of the API served on one address, so a single ent
+// can serve several network zones, like downloads only to the public and
+// everything internally. Its routes are wrapped by the named Middlewares,
+// Config.Middlewares if empty.
+type Listener struct {
+	Role        Role
+	Middlewares []string
+}
+
+// listener registers the routes allowed by role in r, wrapped by c. auth is
+// set if c contains the "auth" middleware.
+type listener struct {
+	r    *pat.Router
+	c    chain
+	role Role
+	auth bool
+}
+
+// listeners are all listeners routes are registered for.
+type listeners []listener
+
+// register adds all routes to the listeners whose role allows them.
+func (ls listeners) register(routes ...Route) {
+	for _, l := range ls {
+		for _, rt := range routes {
+			if l.role&rt.roles() != 0 {
+				l.c.register(l.r, rt)
+			}
+		}
+	}
+}
+
+// handle adds h for path to the listeners with any of roles, without
+// middlewares.
+func (ls listeners) handle(path string, roles Role, h http.Handler) {
+	for _, l := range ls {
+		if l.role&roles != 0 {
+			l.r.Handle(path, h)
+		}
+	}
+}
+
+// checkAuth fails if any listener would serve routes requiring keys without
+// the "auth" middleware, and so to anyone. Admin routes require adminKeys,
+// all other routes keys.
+func (ls listeners) checkAuth(adminKeys, keys bool) error {
+	for i, l := range ls {
+		if l.auth {
+			continue
+		}
+		if (adminKeys && l.role&RoleAdmin != 0) || (keys && l.role&(RoleRead|RoleWrite) != 0) {
+			return fmt.Errorf("listener %d serves routes requiring keys without the auth middleware", i)
+		}
+	}
+	return nil
+}
+
+// roles returns the roles of the listeners serving rt. Unless set
+// explicitly, routes reading are served by RoleRead and all others by
+// RoleWrite.
+func (rt Route) roles() Role {
+	if rt.role != 0 {
+		return rt.role
+	}
+
+	switch rt.Method {
+	case "GET", "HEAD":
+		return RoleRead
+	default:
+		return RoleWrite
+	}
+}
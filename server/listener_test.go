@@ -0,0 +1,116 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		role  Role
+		err   bool
+	}{
+		{value: "read", role: RoleRead},
+		{value: "read+write", role: RoleRead | RoleWrite},
+		{value: "admin+read", role: RoleAdmin | RoleRead},
+		{value: "all", role: RoleAll},
+		{value: "", err: true},
+		{value: "read+root", err: true},
+	} {
+		role, err := ParseRole(test.value)
+		if test.err != (err != nil) {
+			t.Errorf("%q: want error %t, got %v", test.value, test.err, err)
+		}
+		if want, got := test.role, role; want != got {
+			t.Errorf("%q: want %s, got %s", test.value, want, got)
+		}
+	}
+}
+
+func TestNewHandlers(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = ioutil.WriteFile(filepath.Join(tmp, "zone.entpolicy"), []byte(`{"name":"zone"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewHandlers(Config{ProviderDir: tmp, FSRoot: tmp}, Listener{})
+	if err == nil {
+		t.Error("want error for listener without role")
+	}
+
+	for _, test := range []struct {
+		config   Config
+		listener Listener
+		err      bool
+	}{
+		{Config{AdminKeys: []string{"secret"}}, Listener{Role: RoleAdmin, Middlewares: []string{"log"}}, true},
+		{Config{AdminKeys: []string{"secret"}}, Listener{Role: RoleRead, Middlewares: []string{"log"}}, false},
+		{Config{Keys: []string{"secret"}}, Listener{Role: RoleWrite, Middlewares: []string{"log"}}, true},
+		{Config{Keys: []string{"secret"}}, Listener{Role: RoleAdmin, Middlewares: []string{"log"}}, false},
+		{Config{Keys: []string{"secret"}, Middlewares: []string{"log"}}, Listener{Role: RoleRead}, true},
+		{Config{Keys: []string{"secret"}}, Listener{Role: RoleAll, Middlewares: []string{"log", "auth"}}, false},
+	} {
+		test.config.ProviderDir, test.config.FSRoot = tmp, tmp
+
+		_, err := NewHandlers(test.config, test.listener)
+		if test.err != (err != nil) {
+			t.Errorf("%+v: want error %t, got %v", test.listener, test.err, err)
+		}
+	}
+
+	hs, err := NewHandlers(
+		Config{ProviderDir: tmp, FSRoot: tmp, AdminKeys: []string{"secret"}},
+		Listener{Role: RoleRead},
+		Listener{Role: RoleRead | RoleWrite | RoleAdmin},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, internal := hs[0], hs[1]
+
+	do := func(h http.Handler, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, test := range []struct {
+		h      http.Handler
+		method string
+		path   string
+		code   int
+	}{
+		{internal, "POST", "/zone/file", http.StatusCreated},
+		{public, "GET", "/zone/file", http.StatusOK},
+		{public, "GET", "/zone", http.StatusOK},
+		{public, "POST", "/zone/other", http.StatusNotFound},
+		{public, "DELETE", "/zone/file", http.StatusNotFound},
+		{public, "GET", routeAdminBuckets, http.StatusNotFound},
+		{public, "GET", "/metrics", http.StatusNotFound},
+		{public, "GET", routeReady, http.StatusOK},
+		{public, "OPTIONS", "/zone/file", http.StatusOK},
+		{internal, "GET", routeAdminBuckets, http.StatusOK},
+		{internal, "GET", "/metrics", http.StatusOK},
+		{internal, "DELETE", "/zone/file", http.StatusOK},
+		{public, "GET", "/zone/file", http.StatusNotFound},
+	} {
+		if want, got := test.code, do(test.h, test.method, test.path, "data"); want != got {
+			t.Errorf("%s %s: want code %d, got %d", test.method, test.path, want, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/streadway/handy/report"
+)
+
+// DefaultMiddlewares are the middlewares wrapping the handlers of all routes
+// if not configured otherwise, outermost first.
+var DefaultMiddlewares = []string{"timeout", "log", "metrics", "cors", "auth", "limit"}
+
+// A Route is an endpoint of the API. Next to its handler it carries the
+// settings the middlewares wrapping the handler apply to it.
+type Route struct {
+	Method  string
+	Path    string
+	Op      string
+	Handler http.Handler
+
+	// Keys are required to be presented by callers, if any are given.
+	Keys []string
+	// cors adds CORS headers to the responses of the route.
+	cors bool
+	// limit caps the concurrent requests to the route per scope, if set.
+	limit *limiter
+	scope string
+	// timeout bounds the time to receive uploads, if set.
+	timeout time.Duration
+	// role are the listeners serving the route, derived from Method if
+	// not set.
+	role Role
+}
+
+// A Middleware adds behaviour shared by many routes to the handler next of
+// rt.
+type Middleware func(rt Route, next http.Handler) http.Handler
+
+// middlewares are all middlewares a chain can be assembled from by name.
+var middlewares = map[string]Middleware{
+	"timeout": func(rt Route, next http.Handler) http.Handler {
+		return limitUpload(rt.timeout, next)
+	},
+	"log": func(rt Route, next http.Handler) http.Handler {
+		return logRequests(requestLog, next)
+	},
+	"metrics": func(rt Route, next http.Handler) http.Handler {
+		return metrics(rt.Op, next)
+	},
+	"cors": func(rt Route, next http.Handler) http.Handler {
+		if !rt.cors {
+			return next
+		}
+		return addCORSHeaders(next)
+	},
+	"auth": func(rt Route, next http.Handler) http.Handler {
+		return authenticate(rt.Keys, next)
+	},
+	"limit": func(rt Route, next http.Handler) http.Handler {
+		return limitConcurrency(rt.limit, rt.scope, next)
+	},
+}
+
+// requestLog receives a JSON line for every request passing the "log"
+// middleware.
+var requestLog io.Writer = os.Stdout
+
+// loggedRequest is the context key of the request logRequests passes on.
+type loggedRequest struct{}
+
+// logRequests reports every request handled by next to out. Credentials are
+// never written: the report is built from a copy of the request without the
+// Authorization header, while next still receives the original.
+func logRequests(out io.Writer, next http.Handler) http.Handler {
+	logged := report.JSON(out, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.Context().Value(loggedRequest{}).(*http.Request))
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redacted := r.Clone(context.WithValue(r.Context(), loggedRequest{}, r))
+		redacted.Header.Del("Authorization")
+
+		logged.ServeHTTP(w, redacted)
+	})
+}
+
+// RegisterMiddleware makes m available to Config.Middlewares under name,
+// replacing any middleware registered under the same name before. It has to
+// be called before NewServer, usually from an init function.
+func RegisterMiddleware(name string, m Middleware) {
+	middlewares[name] = m
+}
+
+// A chain is an ordered list of middlewares, outermost first.
+type chain []Middleware
+
+// newChain assembles the chain of the middlewares registered under names.
+func newChain(names ...string) (chain, error) {
+	c := chain{}
+
+	for _, name := range names {
+		m, ok := middlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", name)
+		}
+		c = append(c, m)
+	}
+
+	return c, nil
+}
+
+// then wraps the handler of rt in all middlewares of c.
+func (c chain) then(rt Route) http.Handler {
+	h := rt.Handler
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](rt, h)
+	}
+	return h
+}
+
+// register adds all routes to r, with their handlers wrapped by c.
+func (c chain) register(r *pat.Router, routes ...Route) {
+	for _, rt := range routes {
+		r.Add(rt.Method, rt.Path, c.then(rt))
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+)
+
+func TestChain(t *testing.T) {
+	order := []string{}
+
+	for _, name := range []string{"first", "second"} {
+		name := name
+		RegisterMiddleware(name, func(rt Route, next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+" "+rt.Op)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+	defer delete(middlewares, "first")
+	defer delete(middlewares, "second")
+
+	_, err := newChain("first", "unknown")
+	if err == nil {
+		t.Error("want error for unknown middleware")
+	}
+
+	c, err := newChain("first", "second", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := pat.New()
+	c.register(r, Route{
+		Method: "GET",
+		Path:   "/chained",
+		Op:     "handleChained",
+		Keys:   []string{"secret"},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "handler")
+		}),
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/chained")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if want, got := http.StatusUnauthorized, res.StatusCode; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if want, got := "first handleChained,second handleChained", strings.Join(order, ","); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestLogRedactsCredentials(t *testing.T) {
+	var out bytes.Buffer
+	defer func(w io.Writer) { requestLog = w }(requestLog)
+	requestLog = &out
+
+	c, err := newChain("log", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := pat.New()
+	c.register(r, Route{
+		Method:  "GET",
+		Path:    "/logged",
+		Op:      "handleLogged",
+		Keys:    []string{"secret"},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	})
+
+	for _, header := range []string{"Bearer secret", "Basic YWRtaW46c2VjcmV0"} {
+		req := httptest.NewRequest("GET", "/logged", nil)
+		req.Header.Set("Authorization", header)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if want, got := http.StatusOK, w.Code; want != got {
+			t.Errorf("%s: want code %d, got %d", header, want, got)
+		}
+	}
+
+	if want, got := 2, strings.Count(out.String(), "\n"); want != got {
+		t.Fatalf("want %d log lines, got %d: %s", want, got, out.String())
+	}
+	for _, secret := range []string{"secret", "Bearer", "Basic", "YWRtaW46c2VjcmV0"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("log contains %q: %s", secret, out.String())
+		}
+	}
+}
+
+// testChain returns the chain of the default middlewares.
+func testChain(t *testing.T) chain {
+	c, err := newChain(DefaultMiddlewares...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testListeners returns a single listener of all roles registering routes
+// in r, wrapped by the chain of the default middlewares.
+func testListeners(t *testing.T, r *pat.Router) listeners {
+	return listeners{{r: r, c: testChain(t), role: RoleAll}}
+}
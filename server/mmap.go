@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errMmapUnsupported = errors.New("mmap not supported on this platform")
+	errReadOnly        = errors.New("file is read-only")
+
+	mmapRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Program,
+			Name:      "mmap_cache_requests_total",
+			Help:      "Total number of lookups in the mapping cache for small files.",
+		},
+		[]string{"result"},
+	)
+)
+
+// mmapCache keeps read-only memory mappings of small files around, so
+// repeated reads of hot objects are served from memory without opening,
+// reading and hashing the file again. The cache is bounded by the total size
+// of all mappings, least recently used mappings are evicted first.
+//
+// Mapping files is safe as the diskFS never modifies a stored file in place:
+// new content is written to a temporary file which is renamed over the old
+// one, which leaves existing mappings intact.
+type mmapCache struct {
+	maxFileSize int64
+	maxSize     int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newMmapCache(maxFileSize, maxSize int64) *mmapCache {
+	return &mmapCache{
+		maxFileSize: maxFileSize,
+		maxSize:     maxSize,
+		lru:         list.New(),
+		entries:     map[string]*list.Element{},
+	}
+}
+
+// eligible reports if a file of the given size should be served from a
+// mapping.
+func (c *mmapCache) eligible(size int64) bool {
+	return size > 0 && size <= c.maxFileSize && size <= c.maxSize
+}
+
+// get returns a referenced mapping for the file at path. Callers need to
+// release the mapping once they are done with it.
+func (c *mmapCache) get(path string, stat os.FileInfo) (*mapping, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[path]; ok {
+		m := e.Value.(*mapping)
+		if m.size == stat.Size() && m.modTime.Equal(stat.ModTime()) {
+			mmapRequests.WithLabelValues("hit").Inc()
+			c.lru.MoveToFront(e)
+			m.refs++
+			return m, nil
+		}
+		c.remove(e)
+	}
+
+	mmapRequests.WithLabelValues("miss").Inc()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := mmapFile(f, int(stat.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mapping{
+		cache:   c,
+		data:    data,
+		modTime: stat.ModTime(),
+		path:    path,
+		refs:    1,
+		size:    stat.Size(),
+	}
+
+	c.entries[path] = c.lru.PushFront(m)
+	c.size += m.size
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+
+	return m, nil
+}
+
+// invalidate drops the mapping for path, if any. It is called whenever the
+// file at path is replaced or removed.
+func (c *mmapCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[path]; ok {
+		c.remove(e)
+	}
+}
+
+// remove evicts the mapping in e from the cache. The memory is only unmapped
+// once the last reader released it. Must be called with c.mu held.
+func (c *mmapCache) remove(e *list.Element) {
+	m := c.lru.Remove(e).(*mapping)
+	delete(c.entries, m.path)
+
+	c.size -= m.size
+	m.evicted = true
+
+	if m.refs == 0 {
+		m.unmap()
+	}
+}
+
+func (c *mmapCache) release(m *mapping) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m.refs--
+	if m.refs == 0 && m.evicted {
+		m.unmap()
+	}
+}
+
+type mapping struct {
+	cache   *mmapCache
+	data    []byte
+	modTime time.Time
+	path    string
+	size    int64
+
+	// guarded by cache.mu
+	evicted bool
+	refs    int
+
+	hashOnce sync.Once
+	hash     []byte
+}
+
+func (m *mapping) sum() []byte {
+	m.hashOnce.Do(func() {
+		h := sha1.Sum(m.data)
+		m.hash = h[:]
+	})
+	return m.hash
+}
+
+func (m *mapping) unmap() {
+	if err := munmapFile(m.data); err != nil {
+		log.Printf("munmap failed: %s", err)
+	}
+	m.data = nil
+}
+
+// mappedFile is a read-only File served from a mapping.
+type mappedFile struct {
+	key     string
+	mapping *mapping
+	once    sync.Once
+
+	*bytes.Reader
+}
+
+func newMappedFile(m *mapping, key string) *mappedFile {
+	return &mappedFile{
+		key:     key,
+		mapping: m,
+		Reader:  bytes.NewReader(m.data),
+	}
+}
+
+func (f *mappedFile) Key() string {
+	return f.key
+}
+
+func (f *mappedFile) LastModified() time.Time {
+	return f.mapping.modTime
+}
+
+func (f *mappedFile) Hash() ([]byte, error) {
+	return f.mapping.sum(), nil
+}
+
+func (f *mappedFile) Write(p []byte) (int, error) {
+	return 0, errReadOnly
+}
+
+func (f *mappedFile) Close() error {
+	f.once.Do(func() {
+		// Drop the reference to the memory first, it might get unmapped as
+		// soon as it is released.
+		f.Reader = bytes.NewReader(nil)
+		f.mapping.cache.release(f.mapping)
+	})
+	return nil
+}
@@ -0,0 +1,142 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestDiskFSOpenMmap(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-diskfs-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b     = ent.NewBucket("mmap", ent.Owner{})
+		fs    = newDiskFS(tmp, withMmap(newMmapCache(16, 32)))
+		small = []byte("small file")
+		large = []byte("definitely larger than sixteen bytes")
+	)
+
+	for key, data := range map[string][]byte{"small": small, "large": large} {
+		f, err := fs.Create(context.Background(), b, key, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	f, err := fs.Open(context.Background(), b, "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(*mappedFile); !ok {
+		t.Fatalf("want *mappedFile, got %T", f)
+	}
+
+	raw, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(small, raw) {
+		t.Errorf("want %q, got %q", small, raw)
+	}
+
+	h, err := f.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha1.Sum(small); !reflect.DeepEqual(want[:], h) {
+		t.Errorf("want %x, got %x", want, h)
+	}
+	f.Close()
+
+	f, err = fs.Open(context.Background(), b, "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(*mappedFile); ok {
+		t.Errorf("want file above max size to be opened regularly")
+	}
+	f.Close()
+
+	// Overwriting must not serve the stale mapping.
+	f, err = fs.Create(context.Background(), b, "small", bytes.NewReader([]byte("new content")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = fs.Open(context.Background(), b, "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err = ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "new content", string(raw); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestMmapCacheEviction(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-mmap-eviction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		c     = newMmapCache(8, 16)
+		paths = []string{"one", "two", "three"}
+		held  = []*mapping{}
+	)
+
+	for _, name := range paths {
+		path := tmp + "/" + name
+		err := ioutil.WriteFile(path, []byte("8 bytes!"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := c.get(path, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, m)
+	}
+
+	if want, got := int64(16), c.size; want != got {
+		t.Errorf("want cache size %d, got %d", want, got)
+	}
+
+	if !held[0].evicted {
+		t.Errorf("want least recently used mapping to be evicted")
+	}
+	if held[0].data == nil {
+		t.Errorf("want evicted mapping to stay mapped while referenced")
+	}
+
+	c.release(held[0])
+
+	if held[0].data != nil {
+		t.Errorf("want evicted mapping to be unmapped after release")
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const mimeNDJSON = "application/x-ndjson"
+
+// wantsNDJSON reports if the client accepts listings as newline delimited
+// JSON, which are streamed file by file.
+func wantsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, err := mime.ParseMediaType(accept)
+		if err == nil && t == mimeNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// respondFileStream writes the listing of the files of b as one
+// ent.ResponseFile per line. Unsorted listings of FileSystems implementing
+// ent.Walker are written as the files are found, without holding the whole
+// listing in memory. Failures after the first file was written can't be
+// reported anymore and end the response early.
+func respondFileStream(
+	w http.ResponseWriter,
+	r *http.Request,
+	fs ent.FileSystem,
+	b *ent.Bucket,
+	snapshot string,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) {
+	var (
+		enc     = json.NewEncoder(w)
+		written = uint64(0)
+	)
+
+	write := func(f ent.File) error {
+		defer f.Close()
+
+		if written == 0 {
+			w.Header().Set("Content-Type", mimeNDJSON)
+			w.WriteHeader(http.StatusOK)
+		}
+		written++
+
+		return enc.Encode(ent.ResponseFile{
+			Key:          f.Key(),
+			LastModified: f.LastModified(),
+			Bucket:       b,
+		})
+	}
+
+	var err error
+
+	if wk, ok := walkerOf(fs); ok && snapshot == "" && ent.IsNoOp(sortStrategy) {
+		err = wk.Walk(r.Context(), b, prefix, func(f ent.File) error {
+			if written >= limit {
+				f.Close()
+				return errStopWalk
+			}
+			return write(f)
+		})
+		if err == errStopWalk {
+			err = nil
+		}
+	} else {
+		err = writeFiles(r.Context(), fs, b, snapshot, prefix, limit, sortStrategy, write)
+	}
+
+	switch {
+	case err != nil && written == 0:
+		respondError(w, r, err)
+	case err != nil:
+		log.Printf("streaming listing of %s failed: %s", b.Name, err)
+	case written == 0:
+		w.Header().Set("Content-Type", mimeNDJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeFiles lists the files of b and passes them to write one by one.
+func writeFiles(
+	ctx context.Context,
+	fs ent.FileSystem,
+	b *ent.Bucket,
+	snapshot string,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+	write func(ent.File) error,
+) error {
+	files, err := listFiles(ctx, fs, b, snapshot, prefix, limit, sortStrategy)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range files {
+		err := write(f)
+		if err != nil {
+			for _, f := range files[i+1:] {
+				f.Close()
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkerOf returns the first FileSystem implementing ent.Walker in the chain
+// of FileSystems wrapped by fs. Walks always see the current files, so caches
+// wrapping the walker are skipped.
+func walkerOf(fs ent.FileSystem) (ent.Walker, bool) {
+	for {
+		if wk, ok := fs.(ent.Walker); ok {
+			return wk, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
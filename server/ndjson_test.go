@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestHandleFileListNDJSON(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("stream", ent.Owner{})
+		fs = newDiskFS(tmp)
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		f, err := fs.Create(context.Background(), b, key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	r.Get(routeBucket, handleFileList(p, fs))
+
+	list := func(query string) (int, []string) {
+		req, err := http.NewRequest("GET", "/stream?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", mimeNDJSON)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		if want, got := mimeNDJSON, w.Header().Get("Content-Type"); want != got {
+			t.Errorf("want Content-Type %s, got %s", want, got)
+		}
+
+		keys := []string{}
+		s := bufio.NewScanner(w.Body)
+		for s.Scan() {
+			f := ent.ResponseFile{}
+			err := json.Unmarshal(s.Bytes(), &f)
+			if err != nil {
+				t.Fatalf("invalid line %q: %s", s.Text(), err)
+			}
+			keys = append(keys, f.Key)
+		}
+
+		return w.Code, keys
+	}
+
+	_, keys := list("prefix=a/")
+	sort.Strings(keys)
+	if want := []string{"a/1", "a/2", "a/3"}; !equalStrings(want, keys) {
+		t.Errorf("want %v, got %v", want, keys)
+	}
+
+	_, keys = list("limit=2")
+	if want, got := 2, len(keys); want != got {
+		t.Errorf("want %d files, got %d", want, got)
+	}
+
+	_, keys = list("sort=-key&limit=3")
+	if want := []string{"b/1", "a/3", "a/2"}; !equalStrings(want, keys) {
+		t.Errorf("want %v, got %v", want, keys)
+	}
+
+	_, keys = list("prefix=missing/")
+	if len(keys) != 0 {
+		t.Errorf("want no files, got %v", keys)
+	}
+
+	if code, _ := list("sort=+unknown"); code != http.StatusBadRequest {
+		t.Errorf("want code %d, got %d", http.StatusBadRequest, code)
+	}
+}
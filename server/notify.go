@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// A notifier informs the owner of a bucket about conditions requiring their
+// attention.
+type notifier interface {
+	Notify(b *ent.Bucket, subject, body string)
+}
+
+// notifyOptions configures the conditions bucket owners are notified about.
+// There are no notifications about failed scrubs, as ent doesn't record
+// checksums of stored files to scrub them against.
+type notifyOptions struct {
+	notifier notifier
+
+	// quota is the ratio of a quota after which owners are warned. No
+	// warnings are sent if it is zero.
+	quota float64
+
+	// failures is the number of failed uploads within window after which
+	// owners are notified. No notifications are sent if it is zero.
+	failures int
+	window   time.Duration
+}
+
+// smtpNotifier mails notifications to the email address of the bucket owner.
+// The same notification is sent at most once per interval for every bucket,
+// so owners are not flooded while a condition persists.
+type smtpNotifier struct {
+	addr     string
+	auth     smtp.Auth
+	from     mail.Address
+	interval time.Duration
+	send     func(string, smtp.Auth, string, []string, []byte) error
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newSMTPNotifier(
+	addr string,
+	auth smtp.Auth,
+	from mail.Address,
+	interval time.Duration,
+) *smtpNotifier {
+	return &smtpNotifier{
+		addr:     addr,
+		auth:     auth,
+		from:     from,
+		interval: interval,
+		send:     smtp.SendMail,
+		sent:     map[string]time.Time{},
+	}
+}
+
+// Notify sends the notification in the background.
+func (n *smtpNotifier) Notify(b *ent.Bucket, subject, body string) {
+	to := b.Owner.Email
+	if to.Address == "" {
+		return
+	}
+
+	if !n.due(b.Name + "\x00" + subject) {
+		return
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", n.from.String())
+	fmt.Fprintf(msg, "To: %s\r\n", to.String())
+	fmt.Fprintf(msg, "Subject: [ent] %s: %s\r\n", b.Name, subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(msg, "%s\r\n", body)
+
+	go func() {
+		err := n.send(n.addr, n.auth, n.from.Address, []string{to.Address}, msg.Bytes())
+		if err != nil {
+			log.Printf("notifying %s about %s failed: %s", to.Address, b.Name, err)
+		}
+	}()
+}
+
+// due reports if the notification identified by id was not sent within the
+// interval and marks it as sent.
+func (n *smtpNotifier) due(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if last, ok := n.sent[id]; ok && time.Since(last) < n.interval {
+		return false
+	}
+	n.sent[id] = time.Now()
+
+	return true
+}
+
+// failureFS notifies bucket owners once uploads into their bucket failed
+// threshold times within window.
+type failureFS struct {
+	ent.FileSystem
+
+	notifier  notifier
+	threshold int
+	window    time.Duration
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+func newFailureFS(
+	fs ent.FileSystem,
+	n notifier,
+	threshold int,
+	window time.Duration,
+) *failureFS {
+	return &failureFS{
+		FileSystem: fs,
+		notifier:   n,
+		threshold:  threshold,
+		window:     window,
+		failures:   map[string][]time.Time{},
+	}
+}
+
+func (fs *failureFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *failureFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	f, err := fs.FileSystem.Create(ctx, bucket, key, data)
+	if err != nil && !clientFailure(err) {
+		fs.fail(bucket, key, err)
+	}
+	return f, err
+}
+
+// clientFailure reports if err is caused by the client rather than the
+// storage, like invalid, cancelled, dropped or stalled uploads and uploads
+// exceeding a quota or replacing retained files.
+func clientFailure(err error) bool {
+	switch ent.KindOf(err) {
+	case ent.KindInvalid, ent.KindQuotaExceeded, ent.KindForbidden:
+		return true
+	}
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ent.ErrUploadTimeout)
+}
+
+func (fs *failureFS) fail(b *ent.Bucket, key string, err error) {
+	var (
+		now   = time.Now()
+		count int
+	)
+
+	fs.mu.Lock()
+	recent := []time.Time{now}
+	for _, t := range fs.failures[b.Name] {
+		if now.Sub(t) < fs.window {
+			recent = append(recent, t)
+		}
+	}
+	fs.failures[b.Name] = recent
+	count = len(recent)
+	fs.mu.Unlock()
+
+	if count < fs.threshold {
+		return
+	}
+
+	fs.notifier.Notify(b, "upload failures", fmt.Sprintf(
+		"%d uploads into bucket %s failed within %s.\n\nThe latest failure was for %s: %s",
+		count,
+		b.Name,
+		fs.window,
+		key,
+		err,
+	))
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestQuotaWarning(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-quota-warning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = &ent.Bucket{Name: "warn", Quota: 10}
+		n   = &mockNotifier{}
+	)
+
+	fs := newQuotaFS(newMockProvider(b), newDiskFS(tmp), 0, withQuotaWarning(n, 0.8))
+
+	f, err := fs.Create(ctx, b, "small", bytes.NewBufferString("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if want, got := 0, len(n.subjects()); want != got {
+		t.Fatalf("want %d notifications, got %d", want, got)
+	}
+
+	f, err = fs.Create(ctx, b, "large", bytes.NewBufferString("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if want, got := []string{"bucket quota"}, n.subjects(); !equalStrings(want, got) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	_, err = fs.Create(ctx, b, "overflow", bytes.NewBufferString("123"))
+	if !ent.IsQuotaExceeded(err) {
+		t.Errorf("want %v, got %v", ent.ErrQuotaExceeded, err)
+	}
+}
+
+func TestFailureFS(t *testing.T) {
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("failing", ent.Owner{})
+		n   = &mockNotifier{}
+		fs  = newFailureFS(failingFS{}, n, 3, time.Hour)
+	)
+
+	for i := 0; i < 2; i++ {
+		fs.Create(ctx, b, "key", strings.NewReader("data"))
+	}
+	if want, got := 0, len(n.subjects()); want != got {
+		t.Fatalf("want %d notifications, got %d", want, got)
+	}
+
+	// Failures caused by the client are not counted.
+	fs.Create(ctx, b, "key", errorReader{ent.ErrInvalidParam})
+	fs.Create(ctx, b, "key", errorReader{io.ErrUnexpectedEOF})
+	if want, got := 0, len(n.subjects()); want != got {
+		t.Fatalf("want %d notifications, got %d", want, got)
+	}
+
+	fs.Create(ctx, b, "key", strings.NewReader("data"))
+	if want, got := []string{"upload failures"}, n.subjects(); !equalStrings(want, got) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	var (
+		b = ent.NewBucket("mailed", ent.Owner{
+			Email: mail.Address{Name: "owner", Address: "owner@bucket.io"},
+		})
+		from = mail.Address{Address: "ent@bucket.io"}
+		n    = newSMTPNotifier("localhost:25", nil, from, time.Hour)
+		sent = make(chan []byte, 2)
+	)
+
+	n.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		if want, got := []string{"owner@bucket.io"}, to; !equalStrings(want, got) {
+			t.Errorf("want recipients %v, got %v", want, got)
+		}
+		sent <- msg
+		return nil
+	}
+
+	n.Notify(b, "bucket quota", "almost full")
+	n.Notify(b, "bucket quota", "almost full")
+
+	select {
+	case msg := <-sent:
+		if !bytes.Contains(msg, []byte("Subject: [ent] mailed: bucket quota")) {
+			t.Errorf("missing subject in %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification sent")
+	}
+
+	select {
+	case <-sent:
+		t.Error("want repeated notification to be suppressed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Owners without an address are not notified.
+	n.Notify(ent.NewBucket("anonymous", ent.Owner{}), "bucket quota", "almost full")
+
+	select {
+	case <-sent:
+		t.Error("want no notification without address")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type mockNotifier struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (n *mockNotifier) Notify(b *ent.Bucket, subject, body string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, subject)
+}
+
+func (n *mockNotifier) subjects() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string{}, n.messages...)
+}
+
+// failingFS fails all uploads with the error of reading their data or a
+// storage failure.
+type failingFS struct {
+	ent.FileSystem
+}
+
+func (failingFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	_, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.New("disk on fire")
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
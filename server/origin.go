@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+// maxOriginMisses bounds the number of keys remembered as missing at the
+// origin.
+const maxOriginMisses = 10000
+
+var originPulls = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Program,
+		Name:      "origin_pulls_total",
+		Help:      "Total number of files missing locally requested from the origin.",
+	},
+	[]string{"result"},
+)
+
+// originFS turns the FileSystem it wraps into a pull-through cache of an
+// origin, another ent or any store serving files at /{bucket}/{key} below
+// its URL. Files missing locally are fetched from the origin, stored and
+// served locally from then on.
+type originFS struct {
+	ent.FileSystem
+	url    string
+	token  string
+	client *http.Client
+
+	// ttl is the duration files missing at the origin are not asked for
+	// again.
+	ttl time.Duration
+
+	mu     sync.Mutex
+	pulls  map[string]chan struct{}
+	misses map[string]time.Time
+}
+
+// newOriginFS pulls files missing in fs from the origin at url, presenting
+// token as bearer token if it is set. Files missing at the origin are not
+// asked for again for ttl.
+func newOriginFS(fs ent.FileSystem, url, token string, client *http.Client, ttl time.Duration) *originFS {
+	return &originFS{
+		FileSystem: fs,
+		url:        strings.TrimSuffix(url, "/"),
+		token:      token,
+		client:     client,
+		ttl:        ttl,
+		pulls:      map[string]chan struct{}{},
+		misses:     map[string]time.Time{},
+	}
+}
+
+func (fs *originFS) Unwrap() ent.FileSystem {
+	return fs.FileSystem
+}
+
+func (fs *originFS) Open(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) (ent.File, error) {
+	f, err := fs.FileSystem.Open(ctx, bucket, key)
+	if !ent.IsFileNotFound(err) {
+		return f, err
+	}
+
+	err = fs.pull(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.FileSystem.Open(ctx, bucket, key)
+}
+
+// pull stores the file of key fetched from the origin. Concurrent pulls of
+// the same file wait for the first one instead of fetching it again.
+func (fs *originFS) pull(ctx context.Context, bucket *ent.Bucket, key string) error {
+	id := bucket.Name + "/" + key
+
+	fs.mu.Lock()
+	if until, ok := fs.misses[id]; ok {
+		if time.Now().Before(until) {
+			fs.mu.Unlock()
+			originPulls.WithLabelValues("cached").Inc()
+			return ent.ErrFileNotFound
+		}
+		delete(fs.misses, id)
+	}
+
+	done, ok := fs.pulls[id]
+	if ok {
+		fs.mu.Unlock()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	done = make(chan struct{})
+	fs.pulls[id] = done
+	fs.mu.Unlock()
+
+	defer func() {
+		fs.mu.Lock()
+		delete(fs.pulls, id)
+		fs.mu.Unlock()
+		close(done)
+	}()
+
+	err := fs.fetch(ctx, bucket, key)
+	switch {
+	case err == nil:
+		originPulls.WithLabelValues("stored").Inc()
+	case ent.IsFileNotFound(err):
+		originPulls.WithLabelValues("missing").Inc()
+		fs.miss(id)
+	default:
+		originPulls.WithLabelValues("error").Inc()
+	}
+
+	return err
+}
+
+// miss remembers the file id as missing at the origin.
+func (fs *originFS) miss(id string) {
+	if fs.ttl <= 0 {
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := time.Now()
+
+	if len(fs.misses) >= maxOriginMisses {
+		for id, until := range fs.misses {
+			if now.After(until) {
+				delete(fs.misses, id)
+			}
+		}
+	}
+	if len(fs.misses) >= maxOriginMisses {
+		fs.misses = map[string]time.Time{}
+	}
+
+	fs.misses[id] = now.Add(fs.ttl)
+}
+
+// fetch requests the file of key from the origin and stores it in the
+// wrapped FileSystem. Files whose hash doesn't match the one announced by
+// the origin are removed again.
+func (fs *originFS) fetch(ctx context.Context, bucket *ent.Bucket, key string) error {
+	req, err := http.NewRequest("GET", fs.url+"/"+bucket.Name+"/"+escapeKey(key), nil)
+	if err != nil {
+		return err
+	}
+	if fs.token != "" {
+		req.Header.Set("Authorization", "Bearer "+fs.token)
+	}
+
+	res, err := fs.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return ent.Wrap(ent.KindUnavailable, "origin failed", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ent.ErrFileNotFound
+	default:
+		return ent.NewError(
+			ent.KindUnavailable,
+			fmt.Sprintf("origin failed: responded %s", res.Status),
+		)
+	}
+
+	f, err := fs.FileSystem.Create(ctx, bucket, key, &sizedReader{
+		Reader: res.Body,
+		op:     "originFS",
+		size:   res.ContentLength,
+	})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	want := originHash(res.Header)
+	if want == nil {
+		return nil
+	}
+
+	got, err := f.Hash()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(want, got) {
+		err := fs.FileSystem.Delete(ctx, bucket, key)
+		if err != nil {
+			log.Printf("removing mismatching %s/%s failed: %s", bucket.Name, key, err)
+		}
+		return ent.ErrOriginMismatch
+	}
+
+	return nil
+}
+
+// originHash returns the SHA1 announced by an origin in its X-Ent-SHA1 header
+// or as its ETag, or nil if it didn't announce one.
+func originHash(h http.Header) []byte {
+	values := []string{h.Get(headerSHA1)}
+
+	// Weak ETags don't identify the content and are ignored.
+	if etag := h.Get(headerETag); !strings.HasPrefix(etag, "W/") {
+		values = append(values, strings.Trim(etag, `"`))
+	}
+
+	for _, v := range values {
+		hash, err := hex.DecodeString(v)
+		if err == nil && len(hash) == 20 {
+			return hash
+		}
+	}
+
+	return nil
+}
+
+// localOf returns the FileSystem wrapped by the first originFS in the chain of
+// FileSystems wrapped by fs, or fs itself without one, so files can be looked
+// up without pulling them from the origin.
+func localOf(fs ent.FileSystem) ent.FileSystem {
+	for next := fs; ; {
+		if o, ok := next.(*originFS); ok {
+			return o.FileSystem
+		}
+
+		u, ok := next.(unwrapper)
+		if !ok {
+			return fs
+		}
+		next = u.Unwrap()
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestOriginFS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		content = "cached at the edge"
+		hash    = sha1.Sum([]byte(content))
+		asked   = map[string]int{}
+	)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked[r.URL.Path]++
+
+		if want, got := "Bearer secret", r.Header.Get("Authorization"); want != got {
+			t.Errorf("want Authorization %q, got %q", want, got)
+		}
+
+		switch r.URL.Path {
+		case "/edge/assets/app.js":
+			w.Header().Set(headerETag, `"`+hex.EncodeToString(hash[:])+`"`)
+			w.Write([]byte(content))
+		case "/edge/assets/corrupt.js":
+			w.Header().Set(headerSHA1, hex.EncodeToString(hash[:]))
+			w.Write([]byte("corrupted"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("edge", ent.Owner{})
+		fs  = newOriginFS(newDiskFS(tmp), origin.URL+"/", "secret", http.DefaultClient, time.Hour)
+	)
+
+	for i := 0; i < 2; i++ {
+		f, err := fs.Open(ctx, b, "assets/app.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(f)
+		f.Close()
+
+		if want := content; want != string(got) {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+	if want, got := 1, asked["/edge/assets/app.js"]; want != got {
+		t.Errorf("want origin asked %d times, got %d", want, got)
+	}
+
+	// Misses are cached.
+	for i := 0; i < 2; i++ {
+		_, err = fs.Open(ctx, b, "assets/missing.js")
+		if !ent.IsFileNotFound(err) {
+			t.Errorf("want %s, got %v", ent.ErrFileNotFound, err)
+		}
+	}
+	if want, got := 1, asked["/edge/assets/missing.js"]; want != got {
+		t.Errorf("want origin asked %d times, got %d", want, got)
+	}
+
+	_, err = fs.Open(ctx, b, "assets/corrupt.js")
+	if !errors.Is(err, ent.ErrOriginMismatch) {
+		t.Errorf("want %s, got %v", ent.ErrOriginMismatch, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "edge", "assets", "corrupt.js")); !os.IsNotExist(err) {
+		t.Errorf("want mismatching file removed, got %v", err)
+	}
+}
+
+func TestOriginWrites(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = ioutil.WriteFile(
+		filepath.Join(tmp, "edge.entpolicy"),
+		[]byte(`{"name":"edge","retention":3600}`),
+		0644,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asked := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked++
+		w.Write([]byte("stored at the origin"))
+	}))
+	defer origin.Close()
+
+	h, err := NewServer(Config{ProviderDir: tmp, FSRoot: tmp, Origin: origin.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"POST", "/edge/app.js", http.StatusCreated},
+		{"DELETE", "/edge/other.js", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader("uploaded")))
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("%s %s: want code %d, got %d: %s", test.method, test.path, want, got, w.Body)
+		}
+	}
+	if want, got := 0, asked; want != got {
+		t.Errorf("want origin asked %d times, got %d", want, got)
+	}
+}
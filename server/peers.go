@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// headerProxied marks requests proxied from a peer, which are never proxied
+// again so peers don't ask each other in circles.
+const headerProxied = "X-Ent-Proxied"
+
+// maxPeerMisses bounds the number of keys remembered as missing on all peers.
+const maxPeerMisses = 10000
+
+var peerRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Program,
+		Name:      "peer_requests_total",
+		Help:      "Total number of requests for files missing locally answered by peers.",
+	},
+	[]string{"result"},
+)
+
+// peers are the other instances of a replicated deployment. Files which are
+// not found locally, for example as they are not replicated yet, are served
+// from the first peer having them.
+type peers struct {
+	urls   []string
+	client *http.Client
+
+	// ttl is the duration paths missing on all peers are not asked for
+	// again.
+	ttl time.Duration
+
+	mu     sync.Mutex
+	misses map[string]time.Time
+}
+
+// newPeers returns the peers at urls, which are asked for files within
+// timeout. It returns nil if there are no peers.
+func newPeers(urls []string, timeout, ttl time.Duration) *peers {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	ps := &peers{
+		client: &http.Client{Timeout: timeout},
+		ttl:    ttl,
+		misses: map[string]time.Time{},
+	}
+
+	for _, u := range urls {
+		ps.urls = append(ps.urls, strings.TrimSuffix(u, "/"))
+	}
+
+	return ps
+}
+
+// proxyPeers answers requests next answers with 404 Not Found with the
+// response of the first of ps having the file instead. If ps is nil all
+// requests are passed on to next only.
+func proxyPeers(ps *peers, next http.Handler) http.Handler {
+	if ps == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headerProxied) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nw := &notFoundWriter{ResponseWriter: w}
+		next.ServeHTTP(nw, r)
+
+		if !nw.notFound {
+			return
+		}
+
+		if ps.proxy(w, r) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(nw.body.Bytes())
+	})
+}
+
+// peerPath returns the path and query of u as requested by the client. The
+// parameters the router adds to the query, all starting with a colon, are
+// left out, so peers are asked exactly what the client asked.
+func peerPath(u *url.URL) string {
+	params := []string{}
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		name, _ := url.QueryUnescape(strings.SplitN(param, "=", 2)[0])
+		if param != "" && !strings.HasPrefix(name, ":") {
+			params = append(params, param)
+		}
+	}
+
+	path := u.EscapedPath()
+	if len(params) > 0 {
+		path += "?" + strings.Join(params, "&")
+	}
+	return path
+}
+
+// proxy answers r with the response of the first peer having the requested
+// file. It reports false without writing anything if no peer has it.
+func (ps *peers) proxy(w http.ResponseWriter, r *http.Request) bool {
+	path := peerPath(r.URL)
+
+	if ps.missing(path) {
+		peerRequests.WithLabelValues("cached").Inc()
+		return false
+	}
+
+	for _, u := range ps.urls {
+		req, err := http.NewRequest(r.Method, u+path, nil)
+		if err != nil {
+			log.Printf("proxying %s to %s failed: %s", path, u, err)
+			continue
+		}
+
+		for _, h := range []string{"Authorization", "Accept", "Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+			if v := r.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+		req.Header.Set(headerProxied, "1")
+
+		res, err := ps.client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			peerRequests.WithLabelValues("error").Inc()
+			log.Printf("proxying %s to %s failed: %s", path, u, err)
+			continue
+		}
+
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			continue
+		}
+
+		peerRequests.WithLabelValues("hit").Inc()
+
+		for k := range w.Header() {
+			w.Header().Del(k)
+		}
+		for k, vs := range res.Header {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(res.StatusCode)
+
+		_, err = io.Copy(w, res.Body)
+		res.Body.Close()
+		if err != nil {
+			log.Printf("proxying %s from %s failed: %s", path, u, err)
+		}
+
+		return true
+	}
+
+	peerRequests.WithLabelValues("miss").Inc()
+	ps.miss(path)
+
+	return false
+}
+
+// missing reports if path was recently missing on all peers.
+func (ps *peers) missing(path string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	until, ok := ps.misses[path]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(ps.misses, path)
+		return false
+	}
+
+	return true
+}
+
+// miss remembers path as missing on all peers.
+func (ps *peers) miss(path string) {
+	if ps.ttl <= 0 {
+		return
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+
+	if len(ps.misses) >= maxPeerMisses {
+		for p, until := range ps.misses {
+			if now.After(until) {
+				delete(ps.misses, p)
+			}
+		}
+	}
+	if len(ps.misses) >= maxPeerMisses {
+		ps.misses = map[string]time.Time{}
+	}
+
+	ps.misses[path] = now.Add(ps.ttl)
+}
+
+// missingCount returns the number of paths currently remembered as missing
+// on all peers.
+func (ps *peers) missingCount() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var (
+		now   = time.Now()
+		count = 0
+	)
+	for _, until := range ps.misses {
+		if !now.After(until) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// notFoundWriter holds back 404 Not Found responses, so they can be replaced
+// by the response of a peer.
+type notFoundWriter struct {
+	http.ResponseWriter
+
+	notFound bool
+	body     bytes.Buffer
+}
+
+func (w *notFoundWriter) WriteHeader(code int) {
+	if code == http.StatusNotFound {
+		w.notFound = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notFoundWriter) Write(p []byte) (int, error) {
+	if w.notFound {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *notFoundWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
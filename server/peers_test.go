@@ -0,0 +1,128 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+)
+
+func TestProxyPeers(t *testing.T) {
+	var asked int
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked++
+
+		if r.Header.Get(headerProxied) == "" {
+			t.Errorf("want %s header on requests to peers", headerProxied)
+		}
+		if want, got := "Bearer key", r.Header.Get("Authorization"); want != got {
+			t.Errorf("want Authorization %q, got %q", want, got)
+		}
+
+		if r.URL.Path != "/bit/replicated" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"peer"`)
+		w.Write([]byte("from peer"))
+	}))
+	defer peer.Close()
+
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bit/local" {
+			w.Write([]byte("local"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"not found"}`))
+	})
+
+	// The first peer is unreachable and skipped.
+	ps := newPeers([]string{"http://127.0.0.1:1", peer.URL + "/"}, time.Second, time.Hour)
+	h := proxyPeers(ps, local)
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer key")
+		for k, vs := range header {
+			req.Header[k] = vs
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, test := range []struct {
+		path   string
+		header http.Header
+		code   int
+		body   string
+		asked  int
+	}{
+		{path: "/bit/local", code: http.StatusOK, body: "local", asked: 0},
+		{path: "/bit/replicated", code: http.StatusOK, body: "from peer", asked: 1},
+		{path: "/bit/missing", code: http.StatusNotFound, body: `{"error":"not found"}`, asked: 2},
+		// Misses are cached.
+		{path: "/bit/missing", code: http.StatusNotFound, body: `{"error":"not found"}`, asked: 2},
+		// Requests of peers aren't proxied again.
+		{path: "/bit/replicated", header: http.Header{headerProxied: {"1"}}, code: http.StatusNotFound, body: `{"error":"not found"}`, asked: 2},
+	} {
+		w := get(test.path, test.header)
+
+		if want, got := test.code, w.Code; want != got {
+			t.Errorf("%s: want code %d, got %d", test.path, want, got)
+		}
+		body, _ := ioutil.ReadAll(w.Body)
+		if want, got := test.body, string(body); want != got {
+			t.Errorf("%s: want body %q, got %q", test.path, want, got)
+		}
+		if want, got := test.asked, asked; want != got {
+			t.Errorf("%s: want peer asked %d times, got %d", test.path, want, got)
+		}
+	}
+
+	w := get("/bit/replicated", nil)
+	if want, got := `"peer"`, w.Header().Get("ETag"); want != got {
+		t.Errorf("want ETag %s, got %s", want, got)
+	}
+	if got := w.Header().Get("Content-Type"); got == "application/json" {
+		t.Errorf("want headers of local response replaced, got Content-Type %s", got)
+	}
+}
+
+func TestProxyPeersRouted(t *testing.T) {
+	var asked []string
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked = append(asked, r.RequestURI)
+		w.Write([]byte("from peer"))
+	}))
+	defer peer.Close()
+
+	var (
+		ps = newPeers([]string{peer.URL}, time.Second, time.Hour)
+		r  = pat.New()
+	)
+
+	r.Add("GET", routeFile, proxyPeers(ps, http.HandlerFunc(http.NotFound)))
+
+	for _, path := range []string{"/bit/dir/replicated", "/bit/dir/replicated%3F.txt?v=2&x=a%26b"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if want, got := "from peer", w.Body.String(); want != got {
+			t.Errorf("%s: want body %q, got %q", path, want, got)
+		}
+		if want, got := path, asked[len(asked)-1]; want != got {
+			t.Errorf("want peer asked for %s, got %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const policyExt = ".entpolicy"
+
+// bucketName matches the names of buckets which can be created. Names
+// starting with an underscore or a dot are reserved for the routes of the API
+// and the files of the FileSystem.
+var bucketName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-_\.]*$`)
+
+type diskProvider struct {
+	dir string
+
+	mu      sync.RWMutex
+	buckets map[string]*ent.Bucket
+}
+
+func newDiskProvider(dir string) (ent.Provider, error) {
+	p := &diskProvider{
+		buckets: map[string]*ent.Bucket{},
+		dir:     dir,
+	}
+
+	err := filepath.Walk(p.dir, p.walk)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *diskProvider) Get(ctx context.Context, name string) (*ent.Bucket, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	b, ok := p.buckets[name]
+	if !ok {
+		return nil, ent.ErrBucketNotFound
+	}
+	return b, nil
+}
+
+func (p *diskProvider) List(ctx context.Context) ([]*ent.Bucket, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	bs := []*ent.Bucket{}
+	for _, b := range p.buckets {
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+// CreateBucket stores the policy of b in the provider directory.
+func (p *diskProvider) CreateBucket(ctx context.Context, b *ent.Bucket) error {
+	if !bucketName.MatchString(b.Name) {
+		return ent.ErrInvalidBucketName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.buckets[b.Name]; ok {
+		return ent.ErrBucketExists
+	}
+
+	f, err := os.OpenFile(
+		filepath.Join(p.dir, b.Name+policyExt),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		0644,
+	)
+	if os.IsExist(err) {
+		return ent.ErrBucketExists
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(f).Encode(b)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	p.buckets[b.Name] = b
+
+	return nil
+}
+
+func (p *diskProvider) loadBucket(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	b := &ent.Bucket{}
+	err = json.NewDecoder(f).Decode(b)
+	if err != nil {
+		return err
+	}
+
+	// TODO(alx): Validate bucket configuration.
+	p.buckets[b.Name] = b
+
+	return nil
+}
+
+func (p *diskProvider) walk(path string, f os.FileInfo, err error) error {
+	if err != nil {
+		return fmt.Errorf("walking provider dir: %s", err)
+	}
+	if path != p.dir && f.IsDir() {
+		return filepath.SkipDir
+	}
+	if filepath.Ext(path) != policyExt {
+		return nil
+	}
+
+	return p.loadBucket(path)
+}
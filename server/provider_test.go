@@ -1,6 +1,7 @@
-package main
+package server
 
 import (
+	"context"
 	"fmt"
 	"net/mail"
 	"reflect"
@@ -24,7 +25,7 @@ func TestDiskProviderInit(t *testing.T) {
 		}
 
 		expected := ent.NewBucket(name, ent.Owner{Email: *addr})
-		got, err := p.Get(name)
+		got, err := p.Get(context.Background(), name)
 		if err != nil {
 			t.Errorf("error retrieving %s: %s", name, err)
 		}
@@ -37,7 +38,7 @@ func TestDiskProviderInit(t *testing.T) {
 		}
 	}
 
-	bs, err := p.List()
+	bs, err := p.List(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,7 +54,7 @@ func TestDiskProviderBucketNotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = p.Get("fake-bucket")
+	_, err = p.Get(context.Background(), "fake-bucket")
 	if !ent.IsBucketNotFound(err) {
 		t.Errorf("got wrong error: %s", err)
 	}
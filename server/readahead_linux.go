@@ -0,0 +1,24 @@
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readaheadThreshold is the size from which on files are expected to be
+// streamed and the kernel is asked for a larger readahead window.
+const readaheadThreshold = 8 << 20
+
+// adviseReadahead tunes the kernel readahead for a file of the given size
+// which is about to be read sequentially.
+func adviseReadahead(f *os.File, size int64) {
+	if size < readaheadThreshold {
+		return
+	}
+
+	err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+	if err != nil {
+		log.Printf("fadvise %s failed: %s", f.Name(), err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"os"
+)
+
+// adviseReadahead is a no-op on platforms without posix_fadvise.
+func adviseReadahead(f *os.File, size int64) {}
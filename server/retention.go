@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	routeRetention = `/_retention/{bucket}/{key:` + patternKey + `}`
+
+	// retentionDir is the directory below the diskFS root holding the
+	// retentions of individual files of all buckets.
+	retentionDir = ".retention"
+)
+
+func handleRetain(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		rt, ok := retainerOf(fs)
+		if !ok {
+			respondError(w, r, ent.ErrRetentionUnsupported)
+			return
+		}
+
+		req := struct {
+			Until time.Time `json:"until"`
+		}{}
+
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Until.IsZero() {
+			respondError(w, r, ent.ErrInvalidParam)
+			return
+		}
+
+		retention, err := rt.Retain(r.Context(), b, key, req.Until)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseRetention{
+			Duration:  time.Since(start),
+			Retention: retention,
+		})
+	}
+}
+
+func handleRetention(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			start  = time.Now()
+		)
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		rt, ok := retainerOf(fs)
+		if !ok {
+			respondError(w, r, ent.ErrRetentionUnsupported)
+			return
+		}
+
+		retention, err := rt.Retention(r.Context(), b, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseRetention{
+			Duration:  time.Since(start),
+			Retention: retention,
+		})
+	}
+}
+
+// retainerOf returns the first FileSystem implementing ent.Retainer in the
+// chain of FileSystems wrapped by fs.
+func retainerOf(fs ent.FileSystem) (ent.Retainer, bool) {
+	for {
+		if rt, ok := fs.(ent.Retainer); ok {
+			return rt, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
+
+// Retain keeps the file of key from being replaced or deleted until the
+// given time. Retentions can only be extended, never shortened.
+func (fs *diskFS) Retain(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	until time.Time,
+) (*ent.Retention, error) {
+	// Holding the lock for writing keeps files from being replaced or
+	// removed while they are retained.
+	mu := fs.lock(bucket)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := fs.retainedUntil(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if until.Before(current) {
+		return nil, ent.ErrRetentionShortened
+	}
+
+	path, err := pathForRetention(fs, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(dir, pendingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = json.NewEncoder(tmp).Encode(until)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ent.Retention{
+		Bucket: bucket,
+		Key:    key,
+		Until:  until,
+	}, nil
+}
+
+// Retention returns until when the file of key is retained, either by its
+// bucket or by itself.
+func (fs *diskFS) Retention(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) (*ent.Retention, error) {
+	mu := fs.lock(bucket)
+	mu.RLock()
+	until, err := fs.retainedUntil(bucket, key)
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ent.Retention{
+		Bucket: bucket,
+		Key:    key,
+		Until:  until,
+	}, nil
+}
+
+// checkRetention fails with ent.ErrRetained if the file of key may not be
+// replaced or deleted yet. A key without a file is not retained.
+func (fs *diskFS) checkRetention(bucket *ent.Bucket, key string) error {
+	until, err := fs.retainedUntil(bucket, key)
+	if ent.IsFileNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Now().Before(until) {
+		return ent.ErrRetained
+	}
+
+	return nil
+}
+
+// retainedUntil returns the later of the retention given by the bucket of the
+// file of key and its own retention, or the zero time if it is not retained.
+func (fs *diskFS) retainedUntil(bucket *ent.Bucket, key string) (time.Time, error) {
+	path, err := pathForFile(fs, bucket, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && stat.IsDir()) {
+		return time.Time{}, ent.ErrFileNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	until := time.Time{}
+	if bucket.Retention > 0 {
+		until = stat.ModTime().Add(time.Duration(bucket.Retention) * time.Second)
+	}
+
+	path, err = pathForRetention(fs, bucket, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return until, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	retained := time.Time{}
+
+	err = json.NewDecoder(f).Decode(&retained)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if retained.After(until) {
+		until = retained
+	}
+
+	return until, nil
+}
+
+func pathForRetention(fs *diskFS, bucket *ent.Bucket, key string) (string, error) {
+	return joinKey(filepath.Join(fs.root, retentionDir, bucket.Name), key)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestDiskFSRetention(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-diskfs-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx  = context.Background()
+		b    = ent.NewBucket("retention", ent.Owner{})
+		worm = &ent.Bucket{Name: "worm", Retention: 3600}
+		fs   = newDiskFS(tmp).(*diskFS)
+	)
+
+	for _, bucket := range []*ent.Bucket{b, worm} {
+		f, err := fs.Create(ctx, bucket, "file", strings.NewReader("original"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	// Files of buckets with a retention are retained once stored.
+	_, err = fs.Create(ctx, worm, "file", strings.NewReader("changed"))
+	if want, got := ent.ErrRetained, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	err = fs.Delete(ctx, worm, "file")
+	if want, got := ent.ErrRetained, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	_, err = fs.Retain(ctx, b, "missing", time.Now().Add(time.Hour))
+	if !ent.IsFileNotFound(err) {
+		t.Errorf("want %v, got %v", ent.ErrFileNotFound, err)
+	}
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	r, err := fs.Retain(ctx, b, "file", until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Until.Equal(until) {
+		t.Errorf("want %s, got %s", until, r.Until)
+	}
+
+	_, err = fs.Retain(ctx, b, "file", until.Add(-time.Minute))
+	if want, got := ent.ErrRetentionShortened, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	r, err = fs.Retention(ctx, b, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Until.Equal(until) {
+		t.Errorf("want %s, got %s", until, r.Until)
+	}
+
+	_, err = fs.Create(ctx, b, "file", strings.NewReader("changed"))
+	if want, got := ent.ErrRetained, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	err = fs.Delete(ctx, b, "file")
+	if want, got := ent.ErrRetained, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// Once the retention passed the file can be removed along with it.
+	_, err = fs.Retain(ctx, b, "other", time.Now())
+	if !ent.IsFileNotFound(err) {
+		t.Errorf("want %v, got %v", ent.ErrFileNotFound, err)
+	}
+	f, err := fs.Create(ctx, b, "other", strings.NewReader("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	_, err = fs.Retain(ctx, b, "other", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Delete(ctx, b, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(tmp, retentionDir, b.Name, "other"))
+	if !os.IsNotExist(err) {
+		t.Errorf("want retention removed, got %v", err)
+	}
+}
+
+func TestHandleRetain(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-handle-retain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("retain", ent.Owner{})
+		p  = newMockProvider(b)
+		fs = newDiskFS(tmp)
+		r  = pat.New()
+	)
+
+	r.Add("PUT", routeRetention, handleRetain(p, fs))
+	r.Add("GET", routeRetention, handleRetention(p, fs))
+	r.Add("DELETE", routeFile, handleDelete(p, fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	f, err := fs.Create(context.Background(), b, "audit/log", strings.NewReader("entry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"PUT", "/_retention/retain/audit/log", `{"until": "invalid"}`, http.StatusBadRequest},
+		{"PUT", "/_retention/retain/audit/missing", fmt.Sprintf(`{"until": %q}`, until.Format(time.RFC3339)), http.StatusNotFound},
+		{"PUT", "/_retention/retain/audit/log", fmt.Sprintf(`{"until": %q}`, until.Format(time.RFC3339)), http.StatusOK},
+		{"PUT", "/_retention/retain/audit/log", fmt.Sprintf(`{"until": %q}`, until.Add(-time.Hour).Format(time.RFC3339)), http.StatusConflict},
+		{"GET", "/_retention/retain/audit/log", "", http.StatusOK},
+		{"DELETE", "/retain/audit/log", "", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(test.method, ts.URL+test.path, bytes.NewBufferString(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := ent.ResponseRetention{}
+		err = json.NewDecoder(res.Body).Decode(&resp)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.code, res.StatusCode; want != got {
+			t.Errorf("%s %s: want %d, got %d", test.method, test.path, want, got)
+		}
+		if res.StatusCode == http.StatusOK && !resp.Retention.Until.Equal(until) {
+			t.Errorf("%s %s: want %s, got %s", test.method, test.path, until, resp.Retention.Until)
+		}
+	}
+}
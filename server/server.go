@@ -0,0 +1,1383 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	logpkg "log"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	keyBucket   = ":bucket"
+	keyBlob     = ":key"
+	routeBucket = `/{bucket}`
+	routeFile   = `/{bucket}/{key:` + patternKey + `}`
+
+	// DefaultKeyPattern are the characters allowed in keys if not
+	// configured otherwise.
+	DefaultKeyPattern = `[a-zA-Z0-9\-_\.~\+\/]+`
+
+	// patternKey matches keys up to the end of the path, so they aren't
+	// truncated at unexpected characters but validated by the keyPolicy.
+	patternKey = `[\s\S]+`
+
+	paramDryRun = "dryRun"
+	paramLimit  = "limit"
+	paramPrefix = "prefix"
+	paramSort   = "sort"
+
+	orderKey          = "key"
+	orderLastModified = "lastModified"
+	orderAscending    = "+"
+	orderDescending   = "-"
+
+	defaultLimit uint64 = math.MaxUint64
+
+	headerETag         = "ETag"
+	headerSHA1         = "X-Ent-SHA1"
+	headerLastModified = "Last-Modified"
+)
+
+// Program is the name of ent, used as namespace of its metrics.
+const Program = "ent"
+
+// Telemetry
+var (
+	labelNames = []string{"bucket", "method", "operation", "status"}
+
+	requestDurations = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace: Program,
+			Name:      "requests_duration_nanoseconds",
+			Help:      "Amounts of time ent has spent answering requests in nanoseconds.",
+		},
+		labelNames,
+	)
+	// Note that the summary 'requestDurations' above will result in metrics
+	// 'ent_requests_duration_nanoseconds_count' and
+	// 'ent_requests_duration_nanoseconds_sum', counting the total number of
+	// requests made and summing up the total amount of time ent has spent
+	// to answer requests, respectively.
+	requestBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Program,
+			Name:      "request_bytes_total",
+			Help:      "Total volume of request payloads emitted in bytes.",
+		},
+		labelNames,
+	)
+	responseBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Program,
+			Name:      "response_bytes_total",
+			Help:      "Total volume of response payloads emitted in bytes.",
+		},
+		labelNames,
+	)
+	uploadAborts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Program,
+			Name:      "upload_aborts_total",
+			Help:      "Total number of uploads aborted before they were stored.",
+		},
+		[]string{"operation", "reason"},
+	)
+
+	log = logpkg.New(os.Stdout, "", logpkg.LstdFlags|logpkg.Lmicroseconds)
+)
+
+// Config configures the server. The zero value of every setting disables the
+// feature it belongs to.
+type Config struct {
+	// ProviderDir is the directory of the bucket policies and FSRoot the
+	// directory the files of the buckets are stored in.
+	ProviderDir string
+	FSRoot      string
+
+	// Provider and FileSystem replace the disk based implementations read
+	// from ProviderDir and FSRoot, if set.
+	Provider   ent.Provider
+	FileSystem ent.FileSystem
+
+	// Keys are required to be presented as bearer token, if any are given.
+	Keys []string
+
+	// TenantDir holds one subdirectory per tenant, with its configuration
+	// and bucket policies. If set ent serves several tenants below FSRoot
+	// instead of the buckets of ProviderDir.
+	TenantDir string
+
+	// MmapMaxSize is the size up to which files are served from memory
+	// mappings, taking up MmapCacheSize in total.
+	MmapMaxSize   int64
+	MmapCacheSize int64
+
+	// Fetch enables uploads to be fetched from the URL in the
+	// X-Ent-Fetch-URL header, limiting a fetch to FetchTimeout if set.
+	// Only public addresses are fetched from, and only FetchHosts if given,
+	// see newFetchClient.
+	Fetch        bool
+	FetchTimeout time.Duration
+	FetchHosts   []string
+
+	// UploadTimeout is the maximum duration of uploads and imports.
+	UploadTimeout time.Duration
+
+	// Uploads and Downloads limit the concurrent uploads and downloads in
+	// total and per bucket. Requests beyond a limit wait for up to
+	// LimitWait for a free slot.
+	Uploads         int
+	UploadsBucket   int
+	Downloads       int
+	DownloadsBucket int
+	LimitWait       time.Duration
+
+	// ListCacheTTL is the duration listings are cached for, keeping at most
+	// ListCacheSize listings.
+	ListCacheTTL  time.Duration
+	ListCacheSize int
+
+	// Peers are the base URLs of the other instances of a replicated
+	// deployment. Files not found locally are proxied from the first peer
+	// having them, asking it for at most PeerTimeout. Files missing on all
+	// peers are not asked for again for PeerMissTTL.
+	Peers       []string
+	PeerTimeout time.Duration
+	PeerMissTTL time.Duration
+
+	// AdminKeys enables the admin dashboard at /_admin/ for callers
+	// presenting one of them, showing the latest AdminUploads uploads.
+	AdminKeys    []string
+	AdminUploads int
+
+	// SMTPAddr enables notifications of bucket owners, sent from SMTPFrom
+	// and authenticated with PLAIN if SMTPUser is set. Owners are notified
+	// once NotifyQuota of a quota is used and after NotifyFailures failed
+	// uploads within NotifyWindow, at most once per NotifyInterval.
+	SMTPAddr       string
+	SMTPFrom       string
+	SMTPUser       string
+	SMTPPassword   string
+	NotifyQuota    float64
+	NotifyFailures int
+	NotifyWindow   time.Duration
+	NotifyInterval time.Duration
+
+	// Origin turns ent into a pull-through cache of another ent at this
+	// URL, or of any store serving files at /{bucket}/{key} below it. Files
+	// missing locally are fetched from the origin within OriginTimeout,
+	// presenting OriginToken as bearer token if set, and stored locally.
+	// Files missing at the origin are not asked for again for
+	// OriginMissTTL. With tenants, the origin is asked below /{tenant}.
+	Origin        string
+	OriginToken   string
+	OriginTimeout time.Duration
+	OriginMissTTL time.Duration
+
+	// UsageInterval enables tracking the number and size of the files of
+	// every bucket, reconciled with the FileSystem every UsageInterval.
+	// Until the first scan completed, usage is determined by listing.
+	UsageInterval time.Duration
+
+	// KeyMaxLength limits keys of new files to this many bytes, no limit if
+	// 0, and KeyPattern all keys to the characters matched by the regular
+	// expression, DefaultKeyPattern if empty. Keys are normalized to Unicode
+	// NFC if KeyNFC is set. Keys with empty, "." or ".." segments or control
+	// characters are always rejected.
+	KeyMaxLength int
+	KeyPattern   string
+	KeyNFC       bool
+
+	// DiskWatermark rejects uploads while less than this many bytes are
+	// free on the disk of FSRoot, reporting ent as not ready at /readyz.
+	DiskWatermark int64
+
+	// IdempotencyTTL is the duration uploads carrying an Idempotency-Key
+	// are remembered, so retries are answered with the original response.
+	// Idempotency keys are ignored if it is 0.
+	IdempotencyTTL time.Duration
+
+	// UploadResumeTTL is the duration resumable uploads are kept without
+	// being continued. Older uploads are removed along with the partial
+	// files of uploads interrupted by a crash when the server is created,
+	// and every half of UploadResumeTTL while it is running.
+	UploadResumeTTL time.Duration
+
+	// Hooks are called for the files stored, removed and served in all
+	// buckets, in order.
+	Hooks []Hook
+
+	// Middlewares are the names of the middlewares wrapping all handlers,
+	// outermost first. DefaultMiddlewares are used if it is empty. With keys
+	// configured, "auth" has to be among them.
+	Middlewares []string
+	// Context bounds the work done in the background of the handlers, like
+	// reconciling the usage and expiring uploads, which stops once it is
+	// done. Servers created for a limited time, like in tests, should
+	// cancel it when they are discarded. context.Background() is used if
+	// it is nil.
+	Context context.Context
+}
+
+var registerMetrics sync.Once
+
+// NewServer returns the handler serving the API of ent as configured by
+// config, along with its metrics at /metrics.
+func NewServer(config Config) (http.Handler, error) {
+	hs, err := NewHandlers(config, Listener{Role: RoleAll})
+	if err != nil {
+		return nil, err
+	}
+
+	return hs[0], nil
+}
+
+// NewHandlers returns a handler for each of ls, serving the part of the API
+// of ent allowed by its Role as configured by config. All handlers share
+// their buckets, files, limits and caches.
+func NewHandlers(config Config, ls ...Listener) ([]http.Handler, error) {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(requestDurations)
+		prometheus.MustRegister(requestBytes)
+		prometheus.MustRegister(responseBytes)
+		prometheus.MustRegister(mmapRequests)
+		prometheus.MustRegister(copyBytes)
+		prometheus.MustRegister(copyDurations)
+		prometheus.MustRegister(limitRejections)
+		prometheus.MustRegister(listCacheRequests)
+		prometheus.MustRegister(uploadAborts)
+		prometheus.MustRegister(peerRequests)
+		prometheus.MustRegister(bucketFiles)
+		prometheus.MustRegister(bucketBytes)
+		prometheus.MustRegister(originPulls)
+		prometheus.MustRegister(diskFreeBytes)
+		prometheus.MustRegister(diskHeadroomBytes)
+		prometheus.MustRegister(idempotentRequests)
+	})
+
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	fsOpts := []diskFSOption{}
+
+	if config.MmapMaxSize > 0 {
+		fsOpts = append(fsOpts, withMmap(newMmapCache(config.MmapMaxSize, config.MmapCacheSize)))
+	}
+
+	var notify *notifyOptions
+	if config.SMTPAddr != "" {
+		from, err := mail.ParseAddress(config.SMTPFrom)
+		if err != nil {
+			return nil, err
+		}
+
+		var auth smtp.Auth
+		if config.SMTPUser != "" {
+			host, _, err := net.SplitHostPort(config.SMTPAddr)
+			if err != nil {
+				return nil, err
+			}
+			auth = smtp.PlainAuth("", config.SMTPUser, config.SMTPPassword, host)
+		}
+
+		notify = &notifyOptions{
+			notifier: newSMTPNotifier(config.SMTPAddr, auth, *from, config.NotifyInterval),
+			quota:    config.NotifyQuota,
+			failures: config.NotifyFailures,
+			window:   config.NotifyWindow,
+		}
+	}
+
+	var (
+		uploads   = newLimiter("uploads", config.Uploads, config.UploadsBucket, config.LimitWait)
+		downloads = newLimiter("downloads", config.Downloads, config.DownloadsBucket, config.LimitWait)
+	)
+
+	var fetchClient *http.Client
+	if config.Fetch {
+		fetchClient = newFetchClient(config.FetchTimeout, config.FetchHosts)
+	}
+
+	ps := newPeers(config.Peers, config.PeerTimeout, config.PeerMissTTL)
+
+	routers := listeners{}
+
+	for i, l := range ls {
+		if l.Role == 0 {
+			return nil, fmt.Errorf("listener %d has no role", i)
+		}
+
+		names := l.Middlewares
+		if len(names) == 0 {
+			names = config.Middlewares
+		}
+		if len(names) == 0 {
+			names = DefaultMiddlewares
+		}
+
+		c, err := newChain(names...)
+		if err != nil {
+			return nil, err
+		}
+
+		auth := false
+		for _, name := range names {
+			auth = auth || name == "auth"
+		}
+
+		routers = append(routers, listener{r: pat.New(), c: c, role: l.Role, auth: auth})
+	}
+
+	var (
+		wm  *watermark
+		err error
+	)
+	if config.DiskWatermark > 0 {
+		wm, err = newWatermark(config.FSRoot, config.DiskWatermark)
+		if err != nil {
+			return nil, err
+		}
+		go wm.run(ctx, diskRefreshInterval)
+	}
+
+	// GET /metrics
+	routers.handle("/metrics", RoleAdmin, prometheus.Handler())
+
+	// GET /readyz
+	routers.handle(routeReady, RoleAll, handleReady(wm))
+
+	spaces := []namespace{}
+
+	if config.TenantDir == "" {
+		p := config.Provider
+		if p == nil {
+			p, err = newDiskProvider(config.ProviderDir)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		fs := config.FileSystem
+		if fs == nil {
+			fs = newDiskFS(config.FSRoot, fsOpts...)
+		}
+
+		fs, err = guardFS(ctx, p, fs, 0, notify)
+		if err != nil {
+			return nil, err
+		}
+
+		spaces = append(spaces, namespace{keys: config.Keys, p: p, fs: fs})
+	} else {
+		ts, err := loadTenants(ctx, config.TenantDir, config.FSRoot, notify, fsOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range ts {
+			spaces = append(spaces, namespace{
+				name: t.Name,
+				keys: t.Keys,
+				p:    t.p,
+				fs:   t.fs,
+			})
+		}
+	}
+
+	keyed := false
+	for _, ns := range spaces {
+		keyed = keyed || len(ns.keys) > 0
+	}
+
+	err = routers.checkAuth(len(config.AdminKeys) > 0, keyed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range spaces {
+		rc, ok := recovererOf(ns.fs)
+		if !ok {
+			continue
+		}
+
+		removed, kept, err := rc.recoverUploads(config.UploadResumeTTL)
+		if err != nil {
+			return nil, err
+		}
+		if removed > 0 || kept > 0 {
+			log.Printf("recovered uploads of %q: %d removed, %d resumable", ns.name, removed, kept)
+		}
+
+		if config.UploadResumeTTL > 0 {
+			go expireUploads(ctx, rc, ns.name, config.UploadResumeTTL)
+		}
+	}
+
+	pattern := config.KeyPattern
+	if pattern == "" {
+		pattern = DefaultKeyPattern
+	}
+
+	kp, err := newKeyPolicy(config.KeyMaxLength, pattern, config.KeyNFC)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ns := range spaces {
+		spaces[i].fs = &keyPolicyFS{FileSystem: ns.fs, kp: kp}
+	}
+
+	if wm != nil {
+		for i, ns := range spaces {
+			spaces[i].fs = &watermarkFS{FileSystem: ns.fs, wm: wm}
+		}
+	}
+
+	if len(config.Hooks) > 0 {
+		for i, ns := range spaces {
+			spaces[i].fs = &hookFS{FileSystem: ns.fs, tenant: ns.name, hooks: config.Hooks}
+		}
+	}
+
+	if config.UsageInterval > 0 {
+		for i, ns := range spaces {
+			u := newUsageFS(ns.p, ns.fs, ns.name)
+			go u.run(ctx, config.UsageInterval)
+
+			spaces[i].fs = u
+		}
+	}
+
+	if config.ListCacheTTL > 0 {
+		for i, ns := range spaces {
+			spaces[i].fs = newListCacheFS(ns.fs, config.ListCacheTTL, config.ListCacheSize)
+		}
+	}
+
+	if config.Origin != "" {
+		client := &http.Client{Timeout: config.OriginTimeout}
+
+		for i, ns := range spaces {
+			origin := config.Origin
+			if ns.name != "" {
+				origin = strings.TrimSuffix(origin, "/") + "/" + ns.name
+			}
+
+			spaces[i].fs = newOriginFS(ns.fs, origin, config.OriginToken, client, config.OriginMissTTL)
+		}
+	}
+
+	if len(config.AdminKeys) > 0 {
+		uploads := newUploadLog(config.AdminUploads)
+
+		for i, ns := range spaces {
+			spaces[i].fs = &recordFS{
+				FileSystem: ns.fs,
+				tenant:     ns.name,
+				uploads:    uploads,
+			}
+		}
+
+		// GET /_admin/
+		registerAdminRoutes(routers, config.AdminKeys, spaces, uploads, ps, config.UploadResumeTTL)
+	}
+
+	// GET /$tenant/...
+	for _, ns := range spaces {
+		prefix := ""
+		if ns.name != "" {
+			prefix = "/" + ns.name
+		}
+
+		registerRoutes(
+			routers,
+			prefix,
+			ns.keys,
+			ns.p,
+			ns.fs,
+			fetchClient,
+			ps,
+			newIdempotency(config.IdempotencyTTL),
+			config.UploadTimeout,
+			uploads,
+			downloads,
+		)
+	}
+
+	routers.register(Route{
+		Method:  "OPTIONS",
+		Path:    "/{.*}",
+		Op:      "handleOptions",
+		Handler: handleOptions(),
+		cors:    true,
+		role:    RoleAll,
+	})
+
+	hs := []http.Handler{}
+	for _, l := range routers {
+		hs = append(hs, l.r)
+	}
+
+	return hs, nil
+}
+
+// registerRoutes adds the routes of the API for the buckets of p and their
+// files in fs to the listeners whose role allows them. All routes are prefixed
+// by prefix and require one of keys to be presented, if any are given.
+// Files missing locally are read from ps. Retried uploads are answered by
+// id. Uploads have to complete within uploadTimeout. Transfers of blobs are
+// limited by uploads and downloads, which are shared by all callers.
+func registerRoutes(
+	ls listeners,
+	prefix string,
+	keys []string,
+	p ent.Provider,
+	fs ent.FileSystem,
+	fetchClient *http.Client,
+	ps *peers,
+	id *idempotency,
+	uploadTimeout time.Duration,
+	uploads, downloads *limiter,
+) {
+	routes := []Route{
+		// GET /_export/$bucket
+		{
+			Method:  "GET",
+			Path:    prefix + routeExport,
+			Op:      "handleExport",
+			Handler: handleExport(p, fs),
+			cors:    true,
+			limit:   downloads,
+		},
+		// POST /_import/$bucket
+		{
+			Method:  "POST",
+			Path:    prefix + routeImport,
+			Op:      "handleImport",
+			Handler: handleImport(p, fs),
+			cors:    true,
+			limit:   uploads,
+			timeout: uploadTimeout,
+		},
+
+		// GET /_stats
+		{
+			Method:  "GET",
+			Path:    prefix + routeStats,
+			Op:      "handleStats",
+			Handler: handleStats(p, fs),
+			cors:    true,
+		},
+
+		// POST /_snapshots/$bucket/$snapshot
+		{
+			Method:  "POST",
+			Path:    prefix + routeSnapshot,
+			Op:      "handleCreateSnapshot",
+			Handler: handleCreateSnapshot(p, fs),
+			cors:    true,
+		},
+		// GET /_snapshots/$bucket
+		{
+			Method:  "GET",
+			Path:    prefix + routeSnapshots,
+			Op:      "handleSnapshotList",
+			Handler: handleSnapshotList(p, fs),
+			cors:    true,
+		},
+
+		// PUT /_retention/$bucket/$file
+		{
+			Method:  "PUT",
+			Path:    prefix + routeRetention,
+			Op:      "handleRetain",
+			Handler: handleRetain(p, fs),
+		},
+		// GET /_retention/$bucket/$file
+		{
+			Method:  "GET",
+			Path:    prefix + routeRetention,
+			Op:      "handleRetention",
+			Handler: handleRetention(p, fs),
+			cors:    true,
+		},
+
+		// POST /_uploads/$bucket/$file
+		{
+			Method:  "POST",
+			Path:    prefix + routeUpload,
+			Op:      "handleStartUpload",
+			Handler: handleStartUpload(p, fs),
+			cors:    true,
+		},
+		// PATCH /_uploads/$bucket/$file?upload=$id
+		{
+			Method:  "PATCH",
+			Path:    prefix + routeUpload,
+			Op:      "handleAppendUpload",
+			Handler: handleAppendUpload(p, fs),
+			cors:    true,
+			limit:   uploads,
+			timeout: uploadTimeout,
+		},
+		// PUT /_uploads/$bucket/$file?upload=$id
+		{
+			Method:  "PUT",
+			Path:    prefix + routeUpload,
+			Op:      "handleFinishUpload",
+			Handler: handleFinishUpload(p, fs),
+			cors:    true,
+			limit:   uploads,
+			timeout: uploadTimeout,
+		},
+		// GET /_uploads/$bucket/$file?upload=$id
+		{
+			Method:  "GET",
+			Path:    prefix + routeUpload,
+			Op:      "handleUpload",
+			Handler: handleUpload(p, fs),
+			cors:    true,
+		},
+		// DELETE /_uploads/$bucket/$file?upload=$id
+		{
+			Method:  "DELETE",
+			Path:    prefix + routeUpload,
+			Op:      "handleAbortUpload",
+			Handler: handleAbortUpload(p, fs),
+			cors:    true,
+		},
+
+		// DELETE /$bucket/$file
+		{
+			Method:  "DELETE",
+			Path:    prefix + routeFile,
+			Op:      "handleDelete",
+			Handler: handleDelete(p, fs),
+		},
+		// GET /$bucket/$file
+		{
+			Method:  "GET",
+			Path:    prefix + routeFile,
+			Op:      "handleGet",
+			Handler: proxyPeers(ps, handleGet(p, fs)),
+			cors:    true,
+			limit:   downloads,
+		},
+		// HEAD /$bucket/$file
+		{
+			Method:  "HEAD",
+			Path:    prefix + routeFile,
+			Op:      "handleExists",
+			Handler: proxyPeers(ps, handleExists(p, fs)),
+			cors:    true,
+		},
+	}
+
+	// POST /$bucket/$file
+	// PUT /$bucket/$file
+	for _, method := range []string{"POST", "PUT"} {
+		routes = append(routes, Route{
+			Method: method,
+			Path:   prefix + routeFile,
+			Op:     "handleCreate",
+			Handler: idempotent(
+				id,
+				p,
+				fs,
+				fetchRemote(
+					fetchClient,
+					acceptForm(
+						p,
+						fs,
+						handleCreate(p, fs),
+					),
+				),
+			),
+			cors:    true,
+			limit:   uploads,
+			timeout: uploadTimeout,
+		})
+	}
+
+	routes = append(
+		routes,
+		// DELETE /$bucket?prefix=$prefix
+		Route{
+			Method:  "DELETE",
+			Path:    prefix + routeBucket,
+			Op:      "handleDeletePrefix",
+			Handler: handleDeletePrefix(p, fs),
+		},
+		// GET /$bucket
+		Route{
+			Method:  "GET",
+			Path:    prefix + routeBucket,
+			Op:      "handleFileList",
+			Handler: handleFileList(p, fs),
+			cors:    true,
+		},
+
+		// GET /
+		Route{
+			Method:  "GET",
+			Path:    prefix + "/",
+			Op:      "handleBucketList",
+			Handler: handleBucketList(p),
+			cors:    true,
+		},
+	)
+
+	for i := range routes {
+		routes[i].Keys = keys
+		routes[i].scope = prefix
+	}
+
+	ls.register(routes...)
+}
+
+func handleCreate(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(":bucket")
+			key    = r.URL.Query().Get(":key")
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		key, err := checkKey(fs, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		existed, err := fileExists(r.Context(), fs, b, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		f, err := fs.Create(r.Context(), b, key, &sizedReader{
+			Reader: r.Body,
+			op:     "handleCreate",
+			size:   r.ContentLength,
+		})
+		if err != nil {
+			recordAbort("handleCreate", err)
+			respondError(w, r, err)
+			return
+		}
+
+		defer f.Close()
+
+		err = writeBlobHeaders(w, f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		code := http.StatusCreated
+		if existed {
+			code = http.StatusOK
+		}
+
+		respondJSON(w, code, ent.ResponseCreated{
+			Duration: time.Since(start),
+			File: ent.ResponseFile{
+				Key:          key,
+				Bucket:       b,
+				LastModified: f.LastModified(),
+			},
+		})
+	}
+}
+
+// fileExists reports if a file is stored for key in the bucket. Files only
+// available from an origin don't count, as they would have to be pulled just
+// to be replaced.
+func fileExists(
+	ctx context.Context,
+	fs ent.FileSystem,
+	b *ent.Bucket,
+	key string,
+) (bool, error) {
+	f, err := localOf(fs).Open(ctx, b, key)
+	if ent.IsFileNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, f.Close()
+}
+
+func handleDelete(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		key, err := lookupKey(fs, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// Files only available from an origin aren't pulled just to be
+		// removed again.
+		f, err := localOf(fs).Open(r.Context(), b, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		err = fs.Delete(r.Context(), b, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseCreated{
+			Duration: time.Since(start),
+			File: ent.ResponseFile{
+				Bucket:       b,
+				Key:          key,
+				LastModified: f.LastModified(),
+			},
+		})
+	}
+}
+
+// handleDeletePrefix removes all files of a bucket with the given prefix. An
+// empty prefix is rejected, so a bucket is never emptied by accident. With
+// dryRun set the files are only listed. Files failing to be removed don't
+// stop the others from being removed, they are reported along with them and
+// the status of the first failure.
+func handleDeletePrefix(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket      = r.URL.Query().Get(keyBucket)
+			prefix      = r.URL.Query().Get(paramPrefix)
+			dryRunValue = r.URL.Query().Get(paramDryRun)
+			dryRun      = false
+			start       = time.Now()
+		)
+		defer r.Body.Close()
+
+		if prefix == "" {
+			respondError(w, r, ent.ErrInvalidParam)
+			return
+		}
+
+		if dryRunValue != "" {
+			var err error
+			dryRun, err = strconv.ParseBool(dryRunValue)
+			if err != nil {
+				respondError(w, r, ent.ErrInvalidParam)
+				return
+			}
+		}
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		files, err := fs.List(r.Context(), b, prefix, defaultLimit, ent.ByKeyStrategy(true))
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		var (
+			deleted = []ent.ResponseFile{}
+			failed  = []ent.ResponseFailedFile{}
+			code    = http.StatusOK
+		)
+
+		for _, f := range files {
+			f.Close()
+
+			if !dryRun {
+				err := fs.Delete(r.Context(), b, f.Key())
+				// Files removed in the meantime are gone just as well.
+				if ent.IsFileNotFound(err) {
+					continue
+				}
+				if err != nil {
+					if len(failed) == 0 {
+						code = ent.StatusCode(ent.KindOf(err))
+					}
+					failed = append(failed, ent.ResponseFailedFile{
+						Key:   f.Key(),
+						Error: err.Error(),
+					})
+					continue
+				}
+			}
+
+			deleted = append(deleted, ent.ResponseFile{
+				Bucket:       b,
+				Key:          f.Key(),
+				LastModified: f.LastModified(),
+			})
+		}
+
+		respondJSON(w, code, ent.ResponseDeletedList{
+			Count:    len(deleted),
+			DryRun:   dryRun,
+			Duration: time.Since(start),
+			Bucket:   b,
+			Files:    deleted,
+			Failed:   failed,
+		})
+	}
+}
+
+func handleExists(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket   = r.URL.Query().Get(keyBucket)
+			key      = r.URL.Query().Get(keyBlob)
+			snapshot = r.URL.Query().Get(paramSnapshot)
+		)
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		f, err := openFile(r.Context(), fs, b, key, snapshot)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		err = writeBlobHeaders(w, f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		size, err := fileSize(f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+}
+
+func handleGet(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket   = r.URL.Query().Get(keyBucket)
+			key      = r.URL.Query().Get(keyBlob)
+			snapshot = r.URL.Query().Get(paramSnapshot)
+		)
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// Keys with a trailing slash are directories, browsable as index.
+		if strings.HasSuffix(key, "/") && wantsHTML(r) {
+			files, err := listFiles(
+				r.Context(),
+				fs,
+				b,
+				snapshot,
+				key,
+				indexLimit+1,
+				ent.ByKeyStrategy(true),
+			)
+			if err != nil {
+				respondError(w, r, err)
+				return
+			}
+
+			respondIndex(w, r, b, strings.TrimSuffix(r.URL.Path, key), key, files)
+			return
+		}
+
+		key, err = lookupKey(fs, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		f, err := openFile(r.Context(), fs, b, key, snapshot)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		err = writeBlobHeaders(w, f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		size, err := fileSize(f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// With the quoted ETag and the modification time of the file in
+		// place ServeContent evaluates conditional requests, so a resumed
+		// download which raced with a replacement of the blob is answered
+		// with the whole new blob instead of a range of it.
+		rc := &responseRecorder{ResponseWriter: w}
+		http.ServeContent(
+			&sizedResponseWriter{ResponseWriter: rc, op: "handleGet", size: size},
+			r,
+			key,
+			f.LastModified(),
+			f,
+		)
+
+		if h, ok := hooksOf(fs); ok && rc.served() {
+			h.afterGet(r.Context(), b, key, f)
+		}
+	}
+}
+
+func handleBucketList(p ent.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			start = time.Now()
+		)
+
+		bs, err := p.List(r.Context())
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// Providers list their buckets in no particular order, the same
+		// buckets have to result in the same ETag.
+		sort.Slice(bs, func(i, j int) bool {
+			return bs[i].Name < bs[j].Name
+		})
+
+		etag, err := listETag(bs)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		if notModified(w, r, etag) {
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseBucketList{
+			Count:    len(bs),
+			Duration: time.Since(start),
+			ETag:     etag,
+			Buckets:  bs,
+		})
+	}
+}
+
+func handleFileList(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			start      = time.Now()
+			limit      = defaultLimit
+			bucket     = r.URL.Query().Get(keyBucket)
+			limitValue = r.URL.Query().Get(paramLimit)
+			prefix     = r.URL.Query().Get(paramPrefix)
+			sortValue  = r.URL.Query().Get(paramSort)
+			snapshot   = r.URL.Query().Get(paramSnapshot)
+			html       = wantsHTML(r)
+		)
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		if html && sortValue == "" {
+			sortValue = orderAscending + orderKey
+		}
+
+		if limitValue != "" {
+			limit, err = strconv.ParseUint(limitValue, 10, 64)
+			if err != nil {
+				respondError(w, r, ent.ErrInvalidParam)
+				return
+			}
+		}
+
+		// One file more than shown tells if the index is truncated.
+		if html && limit > indexLimit {
+			limit = indexLimit + 1
+		}
+
+		sortStrategy, err := createSortStrategy(sortValue)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		if !html && wantsNDJSON(r) {
+			respondFileStream(w, r, fs, b, snapshot, prefix, limit, sortStrategy)
+			return
+		}
+
+		files, err := listFiles(r.Context(), fs, b, snapshot, prefix, limit, sortStrategy)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		if html {
+			respondIndex(
+				w,
+				r,
+				b,
+				strings.TrimSuffix(r.URL.Path, "/")+"/",
+				prefixDir(prefix),
+				files,
+			)
+			return
+		}
+
+		responseFiles, err := createResponseFiles(files, b)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		for _, file := range files {
+			defer file.Close()
+		}
+
+		etag, err := listETag(responseFiles)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		if notModified(w, r, etag) {
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseFileList{
+			Count:    len(responseFiles),
+			Duration: time.Since(start),
+			ETag:     etag,
+			Bucket:   b,
+			Files:    responseFiles,
+		})
+	}
+}
+
+func handleOptions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func addCORSHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Idempotency-Key, Origin, X-Ent-Offset")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Ent-SHA1")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func metrics(op string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			start = time.Now()
+			rd    = &readerDelegator{ReadCloser: r.Body}
+			rc    = &responseRecorder{ResponseWriter: w}
+		)
+
+		r.Body = rd
+
+		next.ServeHTTP(rc, r)
+
+		d := time.Since(start)
+		labels := map[string]string{
+			"bucket":    r.URL.Query().Get(keyBucket),
+			"method":    strings.ToLower(r.Method),
+			"operation": op,
+			"status":    strconv.Itoa(rc.status),
+		}
+
+		requestBytes.With(labels).Add(float64(rd.BytesRead))
+		requestDurations.With(labels).Observe(float64(d))
+		responseBytes.With(labels).Add(float64(rc.size))
+	})
+}
+
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	code := ent.StatusCode(ent.KindOf(err))
+
+	respondJSON(w, code, ent.ResponseError{
+		Code:        code,
+		Error:       err.Error(),
+		Description: http.StatusText(code),
+	})
+}
+
+func respondJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+type readerDelegator struct {
+	io.ReadCloser
+	BytesRead int
+}
+
+func (r *readerDelegator) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.BytesRead += n
+	return n, err
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// served reports if a blob or a range of it was sent completely, unlike
+// answers to conditional requests like 304 Not Modified or bodies cut short
+// by clients going away.
+func (r *responseRecorder) served() bool {
+	if r.status != http.StatusOK && r.status != http.StatusPartialContent {
+		return false
+	}
+
+	length, err := strconv.Atoi(r.Header().Get("Content-Length"))
+	return err == nil && r.size == length
+}
+
+func createResponseFiles(files ent.Files, bucket *ent.Bucket) ([]ent.ResponseFile, error) {
+	responseFiles := make([]ent.ResponseFile, len(files))
+	for i, file := range files {
+		responseFiles[i] = ent.ResponseFile{
+			Key:          file.Key(),
+			LastModified: file.LastModified(),
+			Bucket:       bucket,
+		}
+	}
+	return responseFiles, nil
+}
+
+func writeBlobHeaders(w http.ResponseWriter, f ent.File) error {
+	h, err := f.Hash()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add(headerETag, `"`+hex.EncodeToString(h)+`"`)
+	w.Header().Add(headerSHA1, hex.EncodeToString(h))
+	w.Header().Add(headerLastModified, f.LastModified().Format(time.RFC3339Nano))
+	return nil
+}
+
+// sizedFile is implemented by Files knowing their size without being read,
+// like the files of listings.
+type sizedFile interface {
+	Size() (int64, error)
+}
+
+// fileSize returns the size of f and rewinds it to the start.
+func fileSize(f ent.File) (int64, error) {
+	if s, ok := f.(sizedFile); ok {
+		return s.Size()
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// recordAbort counts an upload of op which failed with err by the reason it
+// was aborted for.
+func recordAbort(op string, err error) {
+	reason := "error"
+
+	switch {
+	case ent.KindOf(err) == ent.KindQuotaExceeded:
+		reason = "quota"
+	case errors.Is(err, ent.ErrUploadTimeout), errors.Is(err, context.DeadlineExceeded):
+		reason = "timeout"
+	case errors.Is(err, context.Canceled), errors.Is(err, io.ErrUnexpectedEOF):
+		reason = "disconnect"
+	case ent.KindOf(err) == ent.KindInvalid, ent.KindOf(err) == ent.KindForbidden:
+		reason = "rejected"
+	}
+
+	uploadAborts.WithLabelValues(op, reason).Inc()
+}
@@ -1,11 +1,13 @@
-package main
+package server
 
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -16,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,6 +26,68 @@ import (
 	"github.com/soundcloud/ent/lib"
 )
 
+func TestNewServer(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	_, err = NewServer(Config{Middlewares: []string{"unknown"}})
+	if err == nil {
+		t.Error("want error for unknown middleware")
+	}
+
+	var (
+		b  = ent.NewBucket("embedded", ent.Owner{})
+		fs = newDiskFS(tmp)
+	)
+
+	h, err := NewServer(Config{
+		Provider:   newMockProvider(b),
+		FileSystem: fs,
+		Keys:       []string{"secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	for _, test := range []struct {
+		token string
+		code  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"secret", http.StatusCreated},
+	} {
+		req, err := http.NewRequest("POST", ts.URL+"/embedded/key", strings.NewReader("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if want, got := test.code, res.StatusCode; want != got {
+			t.Errorf("token %q: want %d, got %d", test.token, want, got)
+		}
+	}
+
+	f, err := fs.Open(context.Background(), b, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
 func TestHandleCreate(t *testing.T) {
 	fs := newMockFileSystem()
 	b := ent.NewBucket("ent", ent.Owner{})
@@ -68,6 +133,36 @@ func TestHandleCreate(t *testing.T) {
 	}
 }
 
+func TestHandleCreatePut(t *testing.T) {
+	fs := newMockFileSystem()
+	b := ent.NewBucket("ent", ent.Owner{})
+
+	r := pat.New()
+	r.Put(routeFile, handleCreate(newMockProvider(b), fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	ep := fmt.Sprintf("%s/%s/%s", ts.URL, b.Name, "put.file")
+
+	for _, want := range []int{http.StatusCreated, http.StatusOK} {
+		req, err := http.NewRequest("PUT", ep, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if got := res.StatusCode; want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+	}
+}
+
 func TestHandleCreateInvalidBucket(t *testing.T) {
 	fs := newMockFileSystem()
 	r := pat.New()
@@ -149,11 +244,145 @@ func TestHandleDelete(t *testing.T) {
 		t.Errorf("want %v, got %v", want, got)
 	}
 
-	if _, have := fs.Open(b, key); !ent.IsFileNotFound(have) {
+	if _, have := fs.Open(context.Background(), b, key); !ent.IsFileNotFound(have) {
 		t.Errorf("want %s, have %s", ent.ErrFileNotFound, have)
 	}
 }
 
+func TestHandleDeletePrefix(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-delete-prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("handle-delete-prefix", ent.Owner{})
+		fs = newDiskFS(tmp)
+		r  = pat.New()
+	)
+
+	r.Delete(routeBucket, handleDeletePrefix(newMockProvider(b), fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, key := range []string{"exp/1", "exp/2/a", "exp/2/b", "other/1"} {
+		_, err := fs.Create(context.Background(), b, key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		query string
+		code  int
+		count int
+		left  int
+	}{
+		{"", http.StatusBadRequest, 0, 4},
+		{"?prefix=exp/&dryRun=maybe", http.StatusBadRequest, 0, 4},
+		{"?prefix=exp/&dryRun=true", http.StatusOK, 3, 4},
+		{"?prefix=exp/", http.StatusOK, 3, 1},
+		{"?prefix=exp/", http.StatusOK, 0, 1},
+	} {
+		req, err := http.NewRequest(
+			"DELETE",
+			fmt.Sprintf("%s/%s%s", ts.URL, b.Name, test.query),
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := ent.ResponseDeletedList{}
+		err = json.NewDecoder(res.Body).Decode(&resp)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.code, res.StatusCode; want != got {
+			t.Errorf("%s: want %d, got %d", test.query, want, got)
+		}
+		if want, got := test.count, resp.Count; want != got {
+			t.Errorf("%s: want %d deleted, got %d", test.query, want, got)
+		}
+
+		files, err := fs.List(context.Background(), b, "", defaultLimit, ent.NoOpStrategy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			f.Close()
+		}
+		if want, got := test.left, len(files); want != got {
+			t.Errorf("%s: want %d files left, got %d", test.query, want, got)
+		}
+	}
+}
+
+func TestHandleDeletePrefixPartial(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-delete-prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("handle-delete-prefix", ent.Owner{})
+		fs  = newDiskFS(tmp)
+		r   = pat.New()
+	)
+
+	r.Delete(routeBucket, handleDeletePrefix(newMockProvider(b), fs))
+
+	for _, key := range []string{"exp/1", "exp/2", "exp/3"} {
+		_, err := fs.Create(ctx, b, key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rt, _ := retainerOf(fs)
+	_, err = rt.Retain(ctx, b, "exp/2", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/"+b.Name+"?prefix=exp/", nil))
+
+	if want, got := http.StatusForbidden, w.Code; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	resp := ent.ResponseDeletedList{}
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 2, resp.Count; want != got {
+		t.Errorf("want %d deleted, got %d", want, got)
+	}
+	if want, got := 1, len(resp.Failed); want != got {
+		t.Fatalf("want %d failed, got %d", want, got)
+	}
+	if want, got := "exp/2", resp.Failed[0].Key; want != got {
+		t.Errorf("want %q failed, got %q", want, got)
+	}
+	if _, err := fs.Open(ctx, b, "exp/3"); !ent.IsFileNotFound(err) {
+		t.Errorf("want file after failure removed, got %v", err)
+	}
+}
+
 func TestHandleGet(t *testing.T) {
 	fs := newMockFileSystem()
 
@@ -189,6 +418,14 @@ func TestHandleGet(t *testing.T) {
 		t.Errorf("HTTP %d", res.StatusCode)
 	}
 
+	sum, err := f.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := hex.EncodeToString(sum), res.Header.Get(headerSHA1); want != got {
+		t.Errorf("want %s %q, got %q", headerSHA1, want, got)
+	}
+
 	h := sha1.New()
 	_, err = io.Copy(h, res.Body)
 	if err != nil {
@@ -203,6 +440,81 @@ func TestHandleGet(t *testing.T) {
 	}
 }
 
+func TestHandleGetIfRange(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-if-range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("if-range", ent.Owner{})
+		fs = newDiskFS(tmp)
+		r  = pat.New()
+	)
+
+	r.Get(routeFile, handleGet(newMockProvider(b), fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	f, err := fs.Create(context.Background(), b, "blob", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := f.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var (
+		etag     = `"` + hex.EncodeToString(h) + `"`
+		modified = f.LastModified().UTC()
+	)
+
+	for _, test := range []struct {
+		ifRange string
+		code    int
+		body    string
+	}{
+		{"", http.StatusPartialContent, "56789"},
+		{etag, http.StatusPartialContent, "56789"},
+		{`"0000000000000000000000000000000000000000"`, http.StatusOK, "0123456789"},
+		{modified.Add(time.Second).Format(http.TimeFormat), http.StatusOK, "0123456789"},
+		{modified.Format(http.TimeFormat), http.StatusPartialContent, "56789"},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+"/if-range/blob", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=5-")
+		if test.ifRange != "" {
+			req.Header.Set("If-Range", test.ifRange)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.code, res.StatusCode; want != got {
+			t.Errorf("If-Range %q: want %d, got %d", test.ifRange, want, got)
+		}
+		if want, got := test.body, string(body); want != got {
+			t.Errorf("If-Range %q: want %q, got %q", test.ifRange, want, got)
+		}
+		if want, got := etag, res.Header.Get(headerETag); want != got {
+			t.Errorf("want %s %s, got %s", headerETag, want, got)
+		}
+	}
+}
+
 func TestHandleBucketList(t *testing.T) {
 	names := []string{"peer", "nxt", "master"}
 	bs := createBuckets(names, t)
@@ -315,9 +627,10 @@ func TestAddCORSHeaders(t *testing.T) {
 	defer res.Body.Close()
 
 	for key, want := range map[string]string{
-		"Access-Control-Allow-Headers": "Accept, Authorization, Content-Type, Origin",
-		"Access-Control-Allow-Methods": "GET, POST, DELETE",
-		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers":  "Accept, Authorization, Content-Type, Idempotency-Key, Origin, X-Ent-Offset",
+		"Access-Control-Allow-Methods":  "GET, POST, PUT, PATCH, DELETE",
+		"Access-Control-Allow-Origin":   "*",
+		"Access-Control-Expose-Headers": "ETag, X-Ent-SHA1",
 	} {
 		if have := res.Header.Get(key); have != want {
 			t.Errorf("want %s, have %s", want, have)
@@ -325,6 +638,41 @@ func TestAddCORSHeaders(t *testing.T) {
 	}
 }
 
+func TestRespondError(t *testing.T) {
+	for _, input := range []struct {
+		err  error
+		code int
+	}{
+		{ent.ErrBucketNotFound, http.StatusNotFound},
+		{fmt.Errorf("open: %w", ent.ErrFileNotFound), http.StatusNotFound},
+		{ent.ErrInvalidParam, http.StatusBadRequest},
+		{ent.ErrSnapshotExists, http.StatusConflict},
+		{ent.Wrap(ent.KindQuotaExceeded, "bucket full", errors.New("no space")), http.StatusInsufficientStorage},
+		{fmt.Errorf("storing failed: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+		{errors.New("unexpected"), http.StatusInternalServerError},
+	} {
+		var (
+			w = httptest.NewRecorder()
+			r = &http.Request{}
+		)
+
+		respondError(w, r, input.err)
+
+		if want, got := input.code, w.Code; want != got {
+			t.Errorf("%q: want %d, got %d", input.err, want, got)
+		}
+
+		resp := ent.ResponseError{}
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := input.err.Error(), resp.Error; want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
+
 type mockFile struct {
 	buffer *bytes.Buffer
 	data   []byte
@@ -393,7 +741,7 @@ func newMockFileSystem() *mockFileSystem {
 	}
 }
 
-func (fs *mockFileSystem) Create(bucket *ent.Bucket, key string, src io.Reader) (ent.File, error) {
+func (fs *mockFileSystem) Create(ctx context.Context, bucket *ent.Bucket, key string, src io.Reader) (ent.File, error) {
 	f := newMockFile(nil)
 	_, err := io.Copy(f, src)
 	if err != nil {
@@ -405,13 +753,13 @@ func (fs *mockFileSystem) Create(bucket *ent.Bucket, key string, src io.Reader)
 	return f, nil
 }
 
-func (fs *mockFileSystem) Delete(bucket *ent.Bucket, key string) error {
+func (fs *mockFileSystem) Delete(ctx context.Context, bucket *ent.Bucket, key string) error {
 	delete(fs.files, fmt.Sprintf("%s/%s", bucket.Name, key))
 
 	return nil
 }
 
-func (fs *mockFileSystem) Open(bucket *ent.Bucket, key string) (ent.File, error) {
+func (fs *mockFileSystem) Open(ctx context.Context, bucket *ent.Bucket, key string) (ent.File, error) {
 	f, ok := fs.files[filepath.Join(bucket.Name, key)]
 	if !ok {
 		return nil, ent.ErrFileNotFound
@@ -419,7 +767,7 @@ func (fs *mockFileSystem) Open(bucket *ent.Bucket, key string) (ent.File, error)
 	return f, nil
 }
 
-func (fs *mockFileSystem) List(bucket *ent.Bucket, prefix string, limit uint64, sort ent.SortStrategy) (ent.Files, error) {
+func (fs *mockFileSystem) List(ctx context.Context, bucket *ent.Bucket, prefix string, limit uint64, sort ent.SortStrategy) (ent.Files, error) {
 	if prefix == "list/files" {
 		f, _ := os.Open("fixture/test.zip")
 		files := []ent.File{}
@@ -450,7 +798,7 @@ func newMockProvider(buckets ...*ent.Bucket) ent.Provider {
 	return p
 }
 
-func (p *mockProvider) Get(name string) (*ent.Bucket, error) {
+func (p *mockProvider) Get(ctx context.Context, name string) (*ent.Bucket, error) {
 	b, ok := p.buckets[name]
 	if !ok {
 		return nil, ent.ErrBucketNotFound
@@ -462,7 +810,7 @@ func (p *mockProvider) Init() error {
 	return nil
 }
 
-func (p *mockProvider) List() ([]*ent.Bucket, error) {
+func (p *mockProvider) List(ctx context.Context) ([]*ent.Bucket, error) {
 	bs := []*ent.Bucket{}
 	for _, b := range p.buckets {
 		bs = append(bs, b)
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	keySnapshot    = ":snapshot"
+	paramSnapshot  = "snapshot"
+	routeSnapshots = "/_snapshots/{bucket}"
+	routeSnapshot  = `/_snapshots/{bucket}/{snapshot:[a-zA-Z0-9\-_\.]+}`
+
+	// snapshotDir is the directory below the diskFS root holding the
+	// snapshots of all buckets.
+	snapshotDir = ".snapshots"
+)
+
+var snapshotName = regexp.MustCompile(`^[a-zA-Z0-9\-_\.]+$`)
+
+func handleCreateSnapshot(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			name   = r.URL.Query().Get(keySnapshot)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		s, ok := snapshotterOf(fs)
+		if !ok {
+			respondError(w, r, ent.ErrSnapshotsUnsupported)
+			return
+		}
+
+		if !validSnapshotName(name) {
+			respondError(w, r, ent.ErrInvalidParam)
+			return
+		}
+
+		snapshot, err := s.CreateSnapshot(r.Context(), b, name)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, ent.ResponseSnapshot{
+			Duration: time.Since(start),
+			Snapshot: snapshot,
+		})
+	}
+}
+
+func handleSnapshotList(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			start  = time.Now()
+		)
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		s, ok := snapshotterOf(fs)
+		if !ok {
+			respondError(w, r, ent.ErrSnapshotsUnsupported)
+			return
+		}
+
+		snapshots, err := s.ListSnapshots(r.Context(), b)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseSnapshotList{
+			Count:     len(snapshots),
+			Duration:  time.Since(start),
+			Bucket:    b,
+			Snapshots: snapshots,
+		})
+	}
+}
+
+// openFile opens the file for key in the bucket, or in the named snapshot of
+// the bucket if snapshot is not empty.
+func openFile(
+	ctx context.Context,
+	fs ent.FileSystem,
+	b *ent.Bucket,
+	key string,
+	snapshot string,
+) (ent.File, error) {
+	if snapshot == "" {
+		return fs.Open(ctx, b, key)
+	}
+
+	s, ok := snapshotterOf(fs)
+	if !ok {
+		return nil, ent.ErrSnapshotsUnsupported
+	}
+	if !validSnapshotName(snapshot) {
+		return nil, ent.ErrInvalidParam
+	}
+
+	return s.OpenSnapshot(ctx, b, snapshot, key)
+}
+
+// listFiles lists the files of the bucket, or of the named snapshot of the
+// bucket if snapshot is not empty.
+func listFiles(
+	ctx context.Context,
+	fs ent.FileSystem,
+	b *ent.Bucket,
+	snapshot string,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	if snapshot == "" {
+		return fs.List(ctx, b, prefix, limit, sortStrategy)
+	}
+
+	s, ok := snapshotterOf(fs)
+	if !ok {
+		return nil, ent.ErrSnapshotsUnsupported
+	}
+	if !validSnapshotName(snapshot) {
+		return nil, ent.ErrInvalidParam
+	}
+
+	return s.ListSnapshot(ctx, b, snapshot, prefix, limit, sortStrategy)
+}
+
+// snapshotterOf returns the first FileSystem implementing ent.Snapshotter
+// in the chain of FileSystems wrapped by fs. Snapshots are immutable and
+// therefore don't need any of the behaviour added by the wrapping FileSystems.
+func snapshotterOf(fs ent.FileSystem) (ent.Snapshotter, bool) {
+	for {
+		if s, ok := fs.(ent.Snapshotter); ok {
+			return s, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
+
+func validSnapshotName(name string) bool {
+	return snapshotName.MatchString(name) && name != "." && name != ".."
+}
+
+// CreateSnapshot captures the current state of the bucket by hard linking
+// all of its files into the snapshot directory. As stored files are never
+// modified in place, but replaced by renames, the links keep pointing to the
+// content at the time of the snapshot.
+func (fs *diskFS) CreateSnapshot(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	name string,
+) (*ent.Snapshot, error) {
+	var (
+		bucketDir = filepath.Join(fs.root, bucket.Name)
+		dir       = filepath.Join(fs.root, snapshotDir, bucket.Name)
+		dst       = filepath.Join(dir, name)
+	)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(dst)
+	if err == nil {
+		return nil, ent.ErrSnapshotExists
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// The snapshot is assembled in a temporary directory and only renamed to
+	// its name once complete, so it is never visible partially.
+	tmp, err := ioutil.TempDir(dir, pendingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Only the bucket being captured waits for the snapshot to complete.
+	mu := fs.lock(bucket)
+	mu.Lock()
+	err = filepath.Walk(bucketDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == bucketDir && os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("error walking tree: %s", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(path, bucketDir+string(filepath.Separator))
+		if info.IsDir() || isPendingFile(bucketDir, path) {
+			return nil
+		}
+
+		link := filepath.Join(tmp, rel)
+
+		err = os.MkdirAll(filepath.Dir(link), 0755)
+		if err != nil {
+			return err
+		}
+
+		return os.Link(path, link)
+	})
+	mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		if _, serr := os.Stat(dst); serr == nil {
+			return nil, ent.ErrSnapshotExists
+		}
+		return nil, fmt.Errorf("rename failed: %s", err)
+	}
+
+	stat, err := os.Stat(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ent.Snapshot{
+		Name:    name,
+		Bucket:  bucket,
+		Created: stat.ModTime(),
+	}, nil
+}
+
+func (fs *diskFS) ListSnapshots(
+	ctx context.Context,
+	bucket *ent.Bucket,
+) ([]*ent.Snapshot, error) {
+	var (
+		dir       = filepath.Join(fs.root, snapshotDir, bucket.Name)
+		snapshots = []*ent.Snapshot{}
+	)
+
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return snapshots, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if !info.IsDir() || isPendingFile(dir, filepath.Join(dir, info.Name())) {
+			continue
+		}
+
+		snapshots = append(snapshots, &ent.Snapshot{
+			Name:    info.Name(),
+			Bucket:  bucket,
+			Created: info.ModTime(),
+		})
+	}
+
+	return snapshots, nil
+}
+
+func (fs *diskFS) OpenSnapshot(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	snapshot string,
+	key string,
+) (ent.File, error) {
+	view, b, err := fs.snapshotView(bucket, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return view.Open(ctx, b, key)
+}
+
+func (fs *diskFS) ListSnapshot(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	snapshot string,
+	prefix string,
+	limit uint64,
+	sortStrategy ent.SortStrategy,
+) (ent.Files, error) {
+	view, b, err := fs.snapshotView(bucket, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return view.List(ctx, b, prefix, limit, sortStrategy)
+}
+
+// snapshotView returns a diskFS rooted in the snapshot directory of the
+// bucket, in which the snapshot can be accessed like a regular bucket.
+func (fs *diskFS) snapshotView(
+	bucket *ent.Bucket,
+	snapshot string,
+) (*diskFS, *ent.Bucket, error) {
+	view := &diskFS{
+		mmap: fs.mmap,
+		root: filepath.Join(fs.root, snapshotDir, bucket.Name),
+	}
+
+	stat, err := os.Stat(filepath.Join(view.root, snapshot))
+	if os.IsNotExist(err) || (err == nil && !stat.IsDir()) {
+		return nil, nil, ent.ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return view, ent.NewBucket(snapshot, bucket.Owner), nil
+}
+
+// isPendingFile reports if path is a temporary file of an operation in
+// progress in dir.
+func isPendingFile(dir, path string) bool {
+	return filepath.Dir(path) == dir &&
+		strings.HasPrefix(filepath.Base(path), pendingPrefix)
+}
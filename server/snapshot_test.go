@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestDiskFSSnapshot(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-diskfs-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("snapshot", ent.Owner{})
+		fs = newDiskFS(tmp).(*diskFS)
+	)
+
+	for _, key := range []string{"kept", "nested/removed"} {
+		f, err := fs.Create(context.Background(), b, key, bytes.NewBufferString("original "+key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	s, err := fs.CreateSnapshot(context.Background(), b, "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "before", s.Name; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	_, err = fs.CreateSnapshot(context.Background(), b, "before")
+	if want, got := ent.ErrSnapshotExists, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	f, err := fs.Create(context.Background(), b, "kept", bytes.NewBufferString("changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	err = fs.Delete(context.Background(), b, "nested/removed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"kept", "nested/removed"} {
+		f, err := fs.OpenSnapshot(context.Background(), b, "before", key)
+		if err != nil {
+			t.Fatalf("open %s: %s", key, err)
+		}
+		raw, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "original "+key, string(raw); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+
+	files, err := fs.ListSnapshot(context.Background(), b, "before", "", defaultLimit, ent.ByKeyStrategy(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(files); want != got {
+		t.Fatalf("want %d files, got %d", want, got)
+	}
+	for _, f := range files {
+		f.Close()
+	}
+
+	snapshots, err := fs.ListSnapshots(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(snapshots); want != got {
+		t.Fatalf("want %d snapshots, got %d", want, got)
+	}
+
+	_, err = fs.OpenSnapshot(context.Background(), b, "missing", "kept")
+	if want, got := ent.ErrSnapshotNotFound, err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestHandleSnapshots(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-handle-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("snapshot", ent.Owner{})
+		fs = newDiskFS(tmp)
+		p  = newMockProvider(b)
+		r  = pat.New()
+	)
+
+	f, err := fs.Create(context.Background(), b, "file", bytes.NewBufferString("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r.Post(routeSnapshot, handleCreateSnapshot(p, fs))
+	r.Get(routeSnapshots, handleSnapshotList(p, fs))
+	r.Get(routeFile, handleGet(p, fs))
+	r.Get(routeBucket, handleFileList(p, fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/_snapshots/snapshot/v1", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	f, err = fs.Create(context.Background(), b, "file", bytes.NewBufferString("changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for query, want := range map[string]string{
+		"":             "changed",
+		"?snapshot=v1": "original",
+	} {
+		res, err := http.Get(fmt.Sprintf("%s/%s/file%s", ts.URL, b.Name, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(raw); want != got {
+			t.Errorf("%q: want %q, got %q", query, want, got)
+		}
+	}
+
+	res, err = http.Get(ts.URL + "/_snapshots/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resp := ent.ResponseSnapshotList{}
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, resp.Count; want != got {
+		t.Errorf("want %d snapshots, got %d", want, got)
+	}
+
+	getFiles(ts.URL+"/snapshot?snapshot=v1", t, 1)
+
+	res, err = http.Get(ts.URL + "/snapshot?snapshot=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want, got := http.StatusNotFound, res.StatusCode; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
+
+func TestDiskFSSnapshotLocksBucket(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-diskfs-snapshot-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		captured = ent.NewBucket("captured", ent.Owner{})
+		other    = ent.NewBucket("other", ent.Owner{})
+		fs       = newDiskFS(tmp).(*diskFS)
+	)
+
+	// A snapshot in progress holds the lock of its bucket.
+	mu := fs.lock(captured)
+	mu.Lock()
+	defer mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		f, err := fs.Create(context.Background(), other, "key", bytes.NewBufferString("data"))
+		if err == nil {
+			f.Close()
+			err = fs.Delete(context.Background(), other, "key")
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes to other buckets wait for the snapshot")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/soundcloud/ent/lib"
+)
+
+// orderVersion sorts by key name, comparing numbers in keys by their value.
+const orderVersion = "version"
+
+// A SortStrategyFunc returns the ent.SortStrategy of an ordering in ascending
+// or descending order.
+type SortStrategyFunc func(ascending bool) ent.SortStrategy
+
+// sortStrategies are the orderings listings can be sorted by with the sort
+// parameter, prefixed by + or - for ascending or descending order.
+var sortStrategies = map[string]SortStrategyFunc{
+	orderKey:          ent.ByKeyStrategy,
+	orderLastModified: ent.ByLastModifiedStrategy,
+	orderVersion:      ent.ByVersionStrategy,
+}
+
+// RegisterSortStrategy makes the ordering of f available to the sort
+// parameter of listings under name, replacing any ordering registered under
+// the same name before. Limited listings of strategies implementing
+// ent.Comparer hold fewer files while listing. It has to be called before NewServer, usually from an
+// init function.
+func RegisterSortStrategy(name string, f SortStrategyFunc) {
+	sortStrategies[name] = f
+}
+
+func createSortStrategy(value string) (ent.SortStrategy, error) {
+	if value == "" {
+		return ent.NoOpStrategy(), nil
+	}
+	if len(value) == 1 {
+		return nil, ent.ErrInvalidParam
+	}
+
+	var (
+		asc       = true
+		order     = value[:1]
+		criterion = value[1:]
+	)
+
+	// check if the sort param starts the "+" or "-"
+	switch order {
+	case orderAscending:
+		// nothing to do
+	case orderDescending:
+		asc = false
+	default:
+		return nil, ent.ErrInvalidParam
+	}
+
+	f, ok := sortStrategies[criterion]
+	if !ok {
+		return nil, ent.ErrInvalidParam
+	}
+
+	return f(asc), nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestCreateSortStrategyVersion(t *testing.T) {
+	keys := []string{"v1.10.0", "v1.9.2", "v1.9.10", "v2.0.0", "v1.09.3", "v1.9"}
+
+	for _, test := range []struct {
+		value string
+		want  []string
+	}{
+		{"+version", []string{"v1.9", "v1.9.2", "v1.09.3", "v1.9.10", "v1.10.0", "v2.0.0"}},
+		{"-version", []string{"v2.0.0", "v1.10.0", "v1.9.10", "v1.09.3", "v1.9.2", "v1.9"}},
+		{"+key", []string{"v1.09.3", "v1.10.0", "v1.9", "v1.9.10", "v1.9.2", "v2.0.0"}},
+	} {
+		s, err := createSortStrategy(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files := keyedFiles(keys...)
+		s.Sort(files)
+
+		if want, got := test.want, fileKeys(files); !equalStrings(want, got) {
+			t.Errorf("%s: want %v, got %v", test.value, want, got)
+		}
+	}
+}
+
+func TestRegisterSortStrategy(t *testing.T) {
+	_, err := createSortStrategy("+length")
+	if err != ent.ErrInvalidParam {
+		t.Fatalf("want %s, got %v", ent.ErrInvalidParam, err)
+	}
+
+	RegisterSortStrategy("length", func(ascending bool) ent.SortStrategy {
+		return byLength(ascending)
+	})
+	defer delete(sortStrategies, "length")
+
+	s, err := createSortStrategy("-length")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := keyedFiles("a", "ccc", "bb")
+	s.Sort(files)
+
+	if want, got := []string{"ccc", "bb", "a"}, fileKeys(files); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+type byLength bool
+
+func (s byLength) Before(a, b ent.File) bool {
+	if s {
+		return len(a.Key()) < len(b.Key())
+	}
+	return len(a.Key()) > len(b.Key())
+}
+
+func (s byLength) Sort(files ent.Files) {
+	for i := 1; i < len(files); i++ {
+		for j := i; j > 0 && s.Before(files[j], files[j-1]); j-- {
+			files[j], files[j-1] = files[j-1], files[j]
+		}
+	}
+}
+
+type keyedFile struct {
+	*mockFile
+	key string
+}
+
+func (f keyedFile) Key() string {
+	return f.key
+}
+
+func keyedFiles(keys ...string) ent.Files {
+	files := ent.Files{}
+	for _, key := range keys {
+		files = append(files, keyedFile{mockFile: newMockFile(nil), key: key})
+	}
+	return files
+}
+
+func fileKeys(files ent.Files) []string {
+	keys := []string{}
+	for _, f := range files {
+		keys = append(keys, f.Key())
+	}
+	return keys
+}
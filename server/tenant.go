@@ -0,0 +1,584 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// tenantConfig is the name of the file configuring a tenant inside of its
+// directory.
+const tenantConfig = "tenant.json"
+
+var (
+	// tenantName matches the names of tenants, which prefix the routes of
+	// their buckets. Names starting with an underscore are left to the
+	// routes of the server.
+	tenantName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-_\.]*$`)
+
+	// reservedTenantNames are the routes of the server outside of any
+	// tenant. As routes match by prefix, tenants starting with them would
+	// be shadowed.
+	reservedTenantNames = []string{"metrics", strings.TrimPrefix(routeReady, "/")}
+)
+
+// A tenant isolates a team's buckets from all others. Every tenant has its own
+// bucket policies, its own namespace in the FileSystem, an optional quota for
+// the total size of its files and the API keys granting access to it.
+type tenant struct {
+	Name  string   `json:"-"`
+	Keys  []string `json:"keys"`
+	Quota int64    `json:"quota"`
+
+	p  ent.Provider
+	fs ent.FileSystem
+}
+
+// loadTenants reads all tenants from the subdirectories of dir. Every
+// subdirectory is named after its tenant and holds its tenant.json along with
+// the bucket policies of the tenant. Names of tenants have to be valid route
+// prefixes not clashing with the routes of the server. The files of a tenant are stored below
+// its own directory in fsRoot.
+func loadTenants(
+	ctx context.Context,
+	dir, fsRoot string,
+	n *notifyOptions,
+	fsOpts ...diskFSOption,
+) ([]*tenant, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := []*tenant{}
+
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+
+		if !validTenantName(info.Name()) {
+			return nil, fmt.Errorf("invalid tenant name %q", info.Name())
+		}
+
+		t, err := loadTenant(
+			ctx,
+			filepath.Join(dir, info.Name()),
+			filepath.Join(fsRoot, info.Name()),
+			n,
+			fsOpts...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("loading tenant %s: %s", info.Name(), err)
+		}
+
+		ts = append(ts, t)
+	}
+
+	return ts, nil
+}
+
+func validTenantName(name string) bool {
+	if !tenantName.MatchString(name) {
+		return false
+	}
+
+	for _, reserved := range reservedTenantNames {
+		if strings.HasPrefix(name, reserved) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func loadTenant(
+	ctx context.Context,
+	dir, fsRoot string,
+	n *notifyOptions,
+	fsOpts ...diskFSOption,
+) (*tenant, error) {
+	f, err := os.Open(filepath.Join(dir, tenantConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &tenant{
+		Name: filepath.Base(dir),
+	}
+
+	err = json.NewDecoder(f).Decode(t)
+	if err != nil {
+		return nil, err
+	}
+
+	t.p, err = newDiskProvider(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t.fs, err = guardFS(
+		ctx,
+		t.p,
+		newDiskFS(fsRoot, fsOpts...),
+		t.Quota,
+		n,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// guardFS enforces limit on the total size of the files of all buckets of p
+// in fs, next to the quotas of the buckets themselves, and notifies the owners
+// of the buckets as configured by n. Quotas are only tracked if any is set and
+// no notifications are sent if n is nil. The usage of the files stored before
+// is loaded in the background, as long as ctx isn't done.
+func guardFS(
+	ctx context.Context,
+	p ent.Provider,
+	fs ent.FileSystem,
+	limit int64,
+	n *notifyOptions,
+) (ent.FileSystem, error) {
+	bs, err := p.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quota := limit > 0
+	for _, b := range bs {
+		quota = quota || b.Quota > 0
+	}
+
+	if quota {
+		opts := []quotaFSOption{}
+		if n != nil && n.quota > 0 {
+			opts = append(opts, withQuotaWarning(n.notifier, n.quota))
+		}
+
+		// Loading the usage reads all buckets, which mustn't hold up the
+		// start of the server.
+		q := newQuotaFS(p, fs, limit, opts...)
+		go func() {
+			err := q.load(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("loading quota usage failed: %s", err)
+			}
+		}()
+		fs = q
+	}
+
+	if n != nil && n.failures > 0 {
+		fs = newFailureFS(fs, n.notifier, n.failures, n.window)
+	}
+
+	return fs, nil
+}
+
+// authenticate only passes requests on to next which present one of keys as
+// bearer token or, for browsers, as password of basic authentication. If no
+// keys are given all requests are passed on.
+func authenticate(keys []string, next http.Handler) http.Handler {
+	if len(keys) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			header = r.Header.Get("Authorization")
+			token  = strings.TrimPrefix(header, "Bearer ")
+		)
+
+		if token == header {
+			_, password, ok := r.BasicAuth()
+			if !ok {
+				token = ""
+			} else {
+				token = password
+			}
+		}
+
+		if token != "" {
+			for _, key := range keys {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		w.Header().Add("WWW-Authenticate", `Bearer realm="ent"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="ent", charset="UTF-8"`)
+		respondError(w, r, ent.ErrUnauthorized)
+	})
+}
+
+// quotaFS limits the total size of all files stored in a FileSystem as well as
+// the size of every bucket with a quota of its own. Uploads are aborted as soon
+// as they would exceed either quota.
+type quotaFS struct {
+	ent.FileSystem
+
+	limit int64
+
+	// notifier is told once a bucket is filled to warn of its quota or of
+	// the total limit.
+	notifier notifier
+	warn     float64
+
+	// uploads counts the data of resumable uploads in progress against
+	// the quotas, if the FileSystem supports them.
+	uploads recoverer
+
+	// p lists the buckets whose files are loaded into the usage.
+	p ent.Provider
+
+	mu      sync.Mutex
+	used    int64
+	buckets map[string]int64
+}
+
+// quotaFSOption configures optional behaviour of the quotaFS.
+type quotaFSOption func(*quotaFS)
+
+// withQuotaWarning notifies the owner of a bucket through n after an upload
+// filled the bucket or the FileSystem to more than ratio of its quota.
+func withQuotaWarning(n notifier, ratio float64) quotaFSOption {
+	return func(q *quotaFS) {
+		q.notifier = n
+		q.warn = ratio
+	}
+}
+
+// newQuotaFS limits the size of the files of all buckets of p in fs to limit
+// bytes, unless limit is zero, and the size of every bucket to its own quota.
+// Files stored before are only counted once the usage was loaded, see load.
+func newQuotaFS(
+	p ent.Provider,
+	fs ent.FileSystem,
+	limit int64,
+	opts ...quotaFSOption,
+) *quotaFS {
+	q := &quotaFS{
+		FileSystem: fs,
+		p:          p,
+		limit:      limit,
+		buckets:    map[string]int64{},
+	}
+	q.uploads, _ = recovererOf(fs)
+
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+// load adds the size of the files stored in all buckets to the usage. Files
+// changed since loading started are already counted as they are stored.
+func (q *quotaFS) load(ctx context.Context) error {
+	var (
+		start   = time.Now()
+		used    = int64(0)
+		buckets = map[string]int64{}
+	)
+
+	bs, err := q.p.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bs {
+		files, err := q.FileSystem.List(ctx, b, "", defaultLimit, ent.NoOpStrategy())
+		if err != nil {
+			return err
+		}
+
+		for i, f := range files {
+			if f.LastModified().After(start) {
+				f.Close()
+				continue
+			}
+
+			size, err := fileSize(f)
+			f.Close()
+			if err != nil {
+				for _, f := range files[i+1:] {
+					f.Close()
+				}
+				return err
+			}
+			used += size
+			buckets[b.Name] += size
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.used += used
+	for name, size := range buckets {
+		q.buckets[name] += size
+	}
+
+	return nil
+}
+
+func (q *quotaFS) Unwrap() ent.FileSystem {
+	return q.FileSystem
+}
+
+func (q *quotaFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	// The space of a replaced file is credited right away and only charged
+	// again if the replacement fails.
+	previous, err := q.size(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	q.adjust(bucket, -previous)
+
+	// Uploads announcing their size are rejected before any of their data
+	// is read if they can't fit.
+	if s, ok := data.(sizer); ok && s.Size() > 0 {
+		q.mu.Lock()
+		fits := q.fits(bucket, s.Size())
+		q.mu.Unlock()
+
+		if !fits {
+			q.adjust(bucket, previous)
+			return nil, ent.ErrQuotaExceeded
+		}
+	}
+
+	qr := &quotaReader{q: q, b: bucket, r: data}
+
+	f, err := q.FileSystem.Create(ctx, bucket, key, qr)
+	if err != nil {
+		q.adjust(bucket, previous-qr.reserved)
+		return nil, err
+	}
+
+	q.check(bucket)
+
+	return f, nil
+}
+
+func (q *quotaFS) Delete(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) error {
+	size, err := q.size(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	err = q.FileSystem.Delete(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	q.adjust(bucket, -size)
+
+	return nil
+}
+
+// size returns the size of the file stored for key or zero if there is none.
+func (q *quotaFS) size(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) (int64, error) {
+	f, err := q.FileSystem.Open(ctx, bucket, key)
+	if ent.IsFileNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return fileSize(f)
+}
+
+// appendReader charges the data appended to a resumable upload of bucket
+// against the quotas while it is received, rejecting it right away if it
+// announces a size which can't fit. The returned release has to be called
+// once the data was appended, as it is counted with the upload from then on.
+func (q *quotaFS) appendReader(bucket *ent.Bucket, data io.Reader, size int64) (io.Reader, func(), error) {
+	if size > 0 {
+		q.mu.Lock()
+		fits := q.fits(bucket, size)
+		q.mu.Unlock()
+
+		if !fits {
+			return nil, nil, ent.ErrQuotaExceeded
+		}
+	}
+
+	qr := &quotaReader{q: q, b: bucket, r: data}
+	return qr, func() { q.adjust(bucket, -qr.reserved) }, nil
+}
+
+// quotaOf returns the first quotaFS in the chain of FileSystems wrapped by
+// fs.
+func quotaOf(fs ent.FileSystem) (*quotaFS, bool) {
+	for {
+		if q, ok := fs.(*quotaFS); ok {
+			return q, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
+
+// reserve charges n bytes of bucket against the quotas. It fails without
+// charging anything if either quota would be exceeded.
+func (q *quotaFS) reserve(bucket *ent.Bucket, n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.fits(bucket, n) {
+		return ent.ErrQuotaExceeded
+	}
+	q.used += n
+	q.buckets[bucket.Name] += n
+
+	return nil
+}
+
+// fits reports if n more bytes of bucket fit into the quotas. It has to be
+// called with q.mu held.
+func (q *quotaFS) fits(bucket *ent.Bucket, n int64) bool {
+	var inBucket, total int64
+	if q.uploads != nil {
+		inBucket, total = q.uploads.pendingUploads(bucket.Name)
+	}
+
+	if q.limit > 0 && q.used+total+n > q.limit {
+		return false
+	}
+	if bucket.Quota > 0 && q.buckets[bucket.Name]+inBucket+n > bucket.Quota {
+		return false
+	}
+
+	return true
+}
+
+// adjust corrects the usage of bucket by n bytes regardless of the quotas.
+func (q *quotaFS) adjust(bucket *ent.Bucket, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.used += n
+	q.buckets[bucket.Name] += n
+}
+
+// check notifies the owner of bucket if it or the FileSystem is filled beyond
+// the warning ratio of its quota.
+func (q *quotaFS) check(bucket *ent.Bucket) {
+	if q.notifier == nil {
+		return
+	}
+
+	q.mu.Lock()
+	var (
+		used  = q.used
+		total = q.buckets[bucket.Name]
+	)
+	q.mu.Unlock()
+
+	if bucket.Quota > 0 && float64(total) >= q.warn*float64(bucket.Quota) {
+		q.notifier.Notify(bucket, "bucket quota", fmt.Sprintf(
+			"Bucket %s uses %d of its %d bytes quota. Uploads are rejected once it is exhausted.",
+			bucket.Name,
+			total,
+			bucket.Quota,
+		))
+	}
+
+	if q.limit > 0 && float64(used) >= q.warn*float64(q.limit) {
+		q.notifier.Notify(bucket, "storage quota", fmt.Sprintf(
+			"Bucket %s shares a quota of %d bytes of which %d are used. Uploads are rejected once it is exhausted.",
+			bucket.Name,
+			q.limit,
+			used,
+		))
+	}
+}
+
+// sizer is implemented by readers knowing the size of their data up front.
+type sizer interface {
+	Size() int64
+}
+
+// quotaReader charges all data read from r against the quotas of q.
+type quotaReader struct {
+	q        *quotaFS
+	b        *ent.Bucket
+	r        io.Reader
+	reserved int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if qerr := r.q.reserve(r.b, int64(n)); qerr != nil {
+			return 0, qerr
+		}
+		r.reserved += int64(n)
+	}
+	return n, err
+}
+
+// WriteTo keeps the io.WriterTo of the underlying reader in use, which
+// decides on the buffer size of the copy.
+func (r *quotaReader) WriteTo(w io.Writer) (int64, error) {
+	wt, ok := r.r.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, readerOnly{r})
+	}
+	return wt.WriteTo(&quotaWriter{r: r, w: w})
+}
+
+// quotaWriter charges all data written to w against the quota of the
+// quotaReader it originates from.
+type quotaWriter struct {
+	r *quotaReader
+	w io.Writer
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	err := w.r.q.reserve(w.r.b, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	w.r.reserved += int64(len(p))
+
+	return w.w.Write(p)
+}
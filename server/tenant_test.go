@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestLoadTenants(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		dir    = filepath.Join(tmp, "tenants")
+		fsRoot = filepath.Join(tmp, "fs")
+	)
+
+	for name, files := range map[string]map[string]string{
+		"acme": {
+			tenantConfig:       `{"keys": ["acme-key"], "quota": 10}`,
+			"assets.entpolicy": `{"name": "assets", "owner": {"email": {"Address": "ops@acme.io"}}}`,
+		},
+		"globex": {
+			tenantConfig:       `{}`,
+			"assets.entpolicy": `{"name": "assets", "owner": {"email": {"Address": "ops@globex.io"}}}`,
+		},
+	} {
+		err := os.MkdirAll(filepath.Join(dir, name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for file, content := range files {
+			err := ioutil.WriteFile(filepath.Join(dir, name, file), []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ts, err := loadTenants(context.Background(), dir, fsRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 2, len(ts); want != got {
+		t.Fatalf("want %d tenants, got %d", want, got)
+	}
+
+	r := pat.New()
+	for _, tenant := range ts {
+		registerRoutes(testListeners(t, r), "/"+tenant.Name, tenant.Keys, tenant.p, tenant.fs, nil, nil, nil, 0, nil, nil)
+	}
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, input := range []struct {
+		path string
+		key  string
+		body string
+		code int
+	}{
+		{"/acme/assets/logo.png", "", "logo", http.StatusUnauthorized},
+		{"/acme/assets/logo.png", "wrong", "logo", http.StatusUnauthorized},
+		{"/acme/assets/logo.png", "acme-key", "logo", http.StatusCreated},
+		{"/acme/assets/huge.png", "acme-key", "way beyond the quota", http.StatusInsufficientStorage},
+		{"/globex/assets/logo.png", "", "same key, other tenant", http.StatusCreated},
+	} {
+		req, err := http.NewRequest("POST", srv.URL+input.path, bytes.NewBufferString(input.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input.key != "" {
+			req.Header.Set("Authorization", "Bearer "+input.key)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if want, got := input.code, res.StatusCode; want != got {
+			t.Errorf("%s with key %q: want %d, got %d", input.path, input.key, want, got)
+		}
+	}
+
+	for tenant, want := range map[string]string{
+		"acme":   "logo",
+		"globex": "same key, other tenant",
+	} {
+		raw, err := ioutil.ReadFile(filepath.Join(fsRoot, tenant, "assets", "logo.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(raw); want != got {
+			t.Errorf("%s: want %q, got %q", tenant, want, got)
+		}
+	}
+}
+
+func TestLoadTenantsInvalidName(t *testing.T) {
+	for _, name := range []string{"_admin", ".hidden", "metrics", "metrics2", "readyz"} {
+		tmp, err := ioutil.TempDir("", "ent-tenants-invalid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+
+		dir := filepath.Join(tmp, "tenants")
+
+		err = os.MkdirAll(filepath.Join(dir, name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name, tenantConfig), []byte(`{}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = loadTenants(context.Background(), dir, filepath.Join(tmp, "fs"), nil)
+		if err == nil {
+			t.Errorf("%s: want error, got none", name)
+		}
+	}
+}
+
+func TestQuotaFS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-quotafs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("quota", ent.Owner{})
+		p   = newMockProvider(b)
+	)
+
+	f, err := newDiskFS(tmp).Create(ctx, b, "existing", bytes.NewBufferString("12345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fs := newQuotaFS(p, newDiskFS(tmp), 10)
+
+	// Files stored before are counted once loaded.
+	if want, got := int64(0), fs.used; want != got {
+		t.Errorf("want usage %d, got %d", want, got)
+	}
+
+	err = fs.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := int64(5), fs.used; want != got {
+		t.Errorf("want usage %d, got %d", want, got)
+	}
+
+	_, err = fs.Create(ctx, b, "too-large", bytes.NewBufferString("123456"))
+	if !ent.IsQuotaExceeded(err) {
+		t.Errorf("want %v, got %v", ent.ErrQuotaExceeded, err)
+	}
+	if want, got := int64(5), fs.used; want != got {
+		t.Errorf("want usage %d after failed upload, got %d", want, got)
+	}
+
+	// Uploads of a known size are rejected before their data is read.
+	_, err = fs.Create(ctx, b, "too-large", &sizedReader{
+		Reader: errorReader{io.ErrUnexpectedEOF},
+		size:   6,
+	})
+	if !ent.IsQuotaExceeded(err) {
+		t.Errorf("want %v, got %v", ent.ErrQuotaExceeded, err)
+	}
+	if want, got := int64(5), fs.used; want != got {
+		t.Errorf("want usage %d after rejected upload, got %d", want, got)
+	}
+
+	// Replacing a file only charges the difference.
+	f, err = fs.Create(ctx, b, "existing", bytes.NewBufferString("1234567890"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	err = fs.Delete(ctx, b, "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int64(0), fs.used; want != got {
+		t.Errorf("want usage %d after delete, got %d", want, got)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+// limitUpload gives the request handled by next at most d to complete. The
+// body of a client sending slower stops being read once the time is up, so
+// stalled uploads can't hold on to goroutines and files forever. The deadline
+// is only enforced on the connection if w is the ResponseWriter of the
+// server, which requires limitUpload to wrap all other middlewares. No limit
+// applies if d is zero.
+func limitUpload(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			deadline    = time.Now().Add(d)
+			rc          = http.NewResponseController(w)
+			ctx, cancel = context.WithDeadline(r.Context(), deadline)
+		)
+		defer cancel()
+
+		err := rc.SetReadDeadline(deadline)
+		if err == nil {
+			// The deadline of the connection outlives the request and
+			// would otherwise hit the next request on it.
+			defer rc.SetReadDeadline(time.Time{})
+		} else if !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("setting read deadline failed: %s", err)
+		}
+
+		r.Body = &deadlineBody{ReadCloser: r.Body}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// deadlineBody reports reads hitting the deadline of the connection as
+// ent.ErrUploadTimeout.
+type deadlineBody struct {
+	io.ReadCloser
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ent.ErrUploadTimeout
+	}
+	return n, err
+}
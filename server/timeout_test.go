@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestLimitUpload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-limit-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("slow", ent.Owner{})
+		fs = newDiskFS(tmp)
+		r  = pat.New()
+	)
+
+	r.Add("POST", routeFile, limitUpload(100*time.Millisecond, handleCreate(newMockProvider(b), fs)))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s/stalled", ts.URL, b.Name), pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = 1024
+
+	go pw.Write([]byte("only the beginning"))
+
+	done := make(chan *http.Response)
+	go func() {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+
+	select {
+	case res := <-done:
+		if res == nil {
+			return
+		}
+		defer res.Body.Close()
+
+		if want, got := http.StatusServiceUnavailable, res.StatusCode; want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled upload was not aborted")
+	}
+}
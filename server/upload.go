@@ -0,0 +1,905 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/ent/lib"
+)
+
+const (
+	routeUpload = `/_uploads/{bucket}/{key:` + patternKey + `}`
+
+	paramUpload = "upload"
+
+	// headerOffset carries the offset at which the data of a request
+	// continues a resumable upload.
+	headerOffset = "X-Ent-Offset"
+
+	// journalDir is the directory below the diskFS root holding the journal
+	// of all uploads in progress.
+	journalDir = ".journal"
+)
+
+func handleStartUpload(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		key, err := checkKey(fs, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		b, err := p.Get(r.Context(), bucket)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		rs, ok := resumerOf(fs)
+		if !ok {
+			respondError(w, r, ent.ErrUploadsUnsupported)
+			return
+		}
+
+		up, err := rs.StartUpload(r.Context(), b, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, ent.ResponseUploadSession{
+			Duration: time.Since(start),
+			Upload:   up,
+		})
+	}
+}
+
+func handleAppendUpload(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			id     = r.URL.Query().Get(paramUpload)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		offset, err := strconv.ParseInt(r.Header.Get(headerOffset), 10, 64)
+		if err != nil || offset < 0 {
+			respondError(w, r, ent.ErrInvalidParam)
+			return
+		}
+
+		b, rs, err := openUpload(r.Context(), p, fs, bucket, key, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// The data of resumable uploads bypasses the FileSystems wrapping
+		// the resumer, so the disk watermark and quotas are checked here.
+		if wm, ok := watermarkOf(fs); ok {
+			err := wm.check(r.ContentLength)
+			if err != nil {
+				recordAbort("handleAppendUpload", err)
+				respondError(w, r, err)
+				return
+			}
+		}
+
+		var data io.Reader = r.Body
+		if q, ok := quotaOf(fs); ok {
+			qr, release, err := q.appendReader(b, r.Body, r.ContentLength)
+			if err != nil {
+				recordAbort("handleAppendUpload", err)
+				respondError(w, r, err)
+				return
+			}
+			defer release()
+			data = qr
+		}
+
+		up, err := rs.AppendUpload(r.Context(), b, id, offset, &sizedReader{
+			Reader: data,
+			op:     "handleAppendUpload",
+			size:   r.ContentLength,
+		})
+		if err != nil {
+			recordAbort("handleAppendUpload", err)
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseUploadSession{
+			Duration: time.Since(start),
+			Upload:   up,
+		})
+	}
+}
+
+func handleUpload(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			id     = r.URL.Query().Get(paramUpload)
+			start  = time.Now()
+		)
+
+		b, rs, err := openUpload(r.Context(), p, fs, bucket, key, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		up, err := rs.Upload(r.Context(), b, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseUploadSession{
+			Duration: time.Since(start),
+			Upload:   up,
+		})
+	}
+}
+
+// handleFinishUpload stores the data received by a resumable upload as file
+// through fs, so it is subject to the same quotas and retentions as any other
+// upload, and removes the upload afterwards.
+func handleFinishUpload(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			id     = r.URL.Query().Get(paramUpload)
+			start  = time.Now()
+		)
+		defer r.Body.Close()
+
+		b, rs, err := openUpload(r.Context(), p, fs, bucket, key, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		up, err := rs.Upload(r.Context(), b, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		existed, err := fileExists(r.Context(), fs, b, key)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		data, err := rs.ReadUpload(r.Context(), b, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		f, err := fs.Create(r.Context(), b, key, &sizedReader{
+			Reader: data,
+			op:     "handleFinishUpload",
+			size:   up.Offset,
+		})
+		data.Close()
+		if err != nil {
+			recordAbort("handleFinishUpload", err)
+			respondError(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		err = rs.RemoveUpload(r.Context(), b, id)
+		if err != nil {
+			log.Printf("removing upload %s of %s/%s failed: %s", id, b.Name, key, err)
+		}
+
+		err = writeBlobHeaders(w, f)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		code := http.StatusCreated
+		if existed {
+			code = http.StatusOK
+		}
+
+		respondJSON(w, code, ent.ResponseCreated{
+			Duration: time.Since(start),
+			File: ent.ResponseFile{
+				Key:          key,
+				Bucket:       b,
+				LastModified: f.LastModified(),
+			},
+		})
+	}
+}
+
+func handleAbortUpload(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bucket = r.URL.Query().Get(keyBucket)
+			key    = r.URL.Query().Get(keyBlob)
+			id     = r.URL.Query().Get(paramUpload)
+			start  = time.Now()
+		)
+
+		b, rs, err := openUpload(r.Context(), p, fs, bucket, key, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		up, err := rs.Upload(r.Context(), b, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		err = rs.RemoveUpload(r.Context(), b, id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseUploadSession{
+			Duration: time.Since(start),
+			Upload:   up,
+		})
+	}
+}
+
+// openUpload returns the bucket and the ent.Resumer of the upload id, which
+// has to be an upload of key.
+func openUpload(
+	ctx context.Context,
+	p ent.Provider,
+	fs ent.FileSystem,
+	bucket, key, id string,
+) (*ent.Bucket, ent.Resumer, error) {
+	b, err := p.Get(ctx, bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rs, ok := resumerOf(fs)
+	if !ok {
+		return nil, nil, ent.ErrUploadsUnsupported
+	}
+
+	up, err := rs.Upload(ctx, b, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if k, err := checkKey(fs, key); err != nil || up.Key != k {
+		return nil, nil, ent.ErrUploadNotFound
+	}
+
+	return b, rs, nil
+}
+
+// resumerOf returns the first FileSystem implementing ent.Resumer in the
+// chain of FileSystems wrapped by fs. Finished uploads are stored through fs,
+// so the data of uploads in progress is kept by the resumer alone.
+func resumerOf(fs ent.FileSystem) (ent.Resumer, bool) {
+	for {
+		if rs, ok := fs.(ent.Resumer); ok {
+			return rs, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
+
+// recoverer is implemented by FileSystems journaling uploads in progress.
+type recoverer interface {
+	// recoverUploads removes the partial files of uploads interrupted by a
+	// crash and of resumable uploads not continued within ttl. It has to be
+	// called before any uploads are received and returns the number of
+	// removed and kept uploads.
+	recoverUploads(ttl time.Duration) (removed, kept int, err error)
+
+	// expireUploads removes the resumable uploads not continued within
+	// ttl while the server is running.
+	expireUploads(ttl time.Duration) (removed int, err error)
+
+	// pendingUploads returns the bytes received by the resumable uploads
+	// to bucket and to all buckets, which aren't stored as files yet.
+	pendingUploads(bucket string) (inBucket, total int64)
+}
+
+// recovererOf returns the first FileSystem implementing recoverer in the
+// chain of FileSystems wrapped by fs.
+func recovererOf(fs ent.FileSystem) (recoverer, bool) {
+	for {
+		if rc, ok := fs.(recoverer); ok {
+			return rc, true
+		}
+
+		u, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = u.Unwrap()
+	}
+}
+
+// expireUploads removes the resumable uploads of rc, belonging to tenant,
+// not continued within ttl. It checks every half of ttl until ctx is done.
+func expireUploads(ctx context.Context, rc recoverer, tenant string, ttl time.Duration) {
+	t := time.NewTicker(ttl / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		removed, err := rc.expireUploads(ttl)
+		if err != nil {
+			log.Printf("expiring uploads of %q failed: %s", tenant, err)
+		}
+		if removed > 0 {
+			log.Printf("expired %d uploads of %q", removed, tenant)
+		}
+	}
+}
+
+// journalEntry records a resumable upload in progress, written ahead of its
+// data, so the upload is found again after a crash or restart.
+type journalEntry struct {
+	ID        string    `json:"id"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Path      string    `json:"path"`
+	Offset    int64     `json:"offset"`
+	Resumable bool      `json:"resumable"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+}
+
+// StartUpload starts a resumable upload of key.
+func (fs *diskFS) StartUpload(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) (*ent.UploadSession, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(fs.root, bucket.Name), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e := journalEntry{
+		ID:        id,
+		Bucket:    bucket.Name,
+		Key:       key,
+		Path:      pendingPrefix + "upload-" + id,
+		Resumable: true,
+		Started:   now,
+		Updated:   now,
+	}
+
+	err = fs.writeJournal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(pathForPending(fs, e), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		fs.removeJournal(id)
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return e.upload(bucket), nil
+}
+
+// AppendUpload appends data to the upload id, which has to continue at the
+// durable offset of the upload. Data received by requests failing halfway is
+// kept and the upload is continued after it.
+func (fs *diskFS) AppendUpload(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	id string,
+	offset int64,
+	data io.Reader,
+) (*ent.UploadSession, error) {
+	err := fs.acquireUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.releaseUpload(id)
+
+	e, err := fs.readJournal(bucket, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != e.Offset {
+		return nil, ent.ErrUploadOffset
+	}
+
+	f, err := os.OpenFile(pathForPending(fs, e), os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, ent.ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Data past the journaled offset was never acknowledged, for example
+	// as the server crashed while receiving it, and is written again.
+	err = f.Truncate(e.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Seek(e.Offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	n, copyErr := io.Copy(&ctxWriter{ctx: ctx, w: f}, data)
+
+	err = f.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	e.Offset += n
+	e.Updated = time.Now()
+
+	err = fs.writeJournal(e)
+	if err != nil {
+		return nil, err
+	}
+	fs.addPending(bucket.Name, n)
+
+	if copyErr != nil {
+		return nil, fmt.Errorf("storing failed: %w", copyErr)
+	}
+
+	return e.upload(bucket), nil
+}
+
+// Upload returns the resumable upload id.
+func (fs *diskFS) Upload(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	id string,
+) (*ent.UploadSession, error) {
+	e, err := fs.readJournal(bucket, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.upload(bucket), nil
+}
+
+// ReadUpload returns the data received by the upload id so far. The upload
+// can't be continued until the data is closed.
+func (fs *diskFS) ReadUpload(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	id string,
+) (io.ReadCloser, error) {
+	err := fs.acquireUpload(id)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := fs.readJournal(bucket, id)
+	if err != nil {
+		fs.releaseUpload(id)
+		return nil, err
+	}
+
+	f, err := os.Open(pathForPending(fs, e))
+	if err != nil {
+		fs.releaseUpload(id)
+		if os.IsNotExist(err) {
+			err = ent.ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	// The data is usually read to be stored as file, which is charged
+	// against the quotas on its own.
+	fs.addPending(bucket.Name, -e.Offset)
+
+	return &uploadReader{
+		Reader: io.LimitReader(f, e.Offset),
+		f:      f,
+		e:      e,
+		fs:     fs,
+	}, nil
+}
+
+// RemoveUpload removes the upload id and the data received by it.
+func (fs *diskFS) RemoveUpload(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	id string,
+) error {
+	err := fs.acquireUpload(id)
+	if err != nil {
+		return err
+	}
+	defer fs.releaseUpload(id)
+
+	e, err := fs.readJournal(bucket, id)
+	if err != nil {
+		return err
+	}
+
+	return fs.removeUpload(e)
+}
+
+// removeUpload removes the upload of e, which has to be acquired.
+func (fs *diskFS) removeUpload(e journalEntry) error {
+	err := os.Remove(pathForPending(fs, e))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = fs.removeJournal(e.ID)
+	if err != nil {
+		return err
+	}
+
+	fs.addPending(e.Bucket, -e.Offset)
+	return nil
+}
+
+func (fs *diskFS) recoverUploads(ttl time.Duration) (int, int, error) {
+	dir := filepath.Join(fs.root, journalDir)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+
+	var (
+		removed, kept = 0, 0
+		resumed       = map[string]bool{}
+	)
+
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+
+		// Entries interrupted while they were written are of no use.
+		if !info.IsDir() && strings.HasPrefix(info.Name(), pendingPrefix) {
+			os.Remove(path)
+			continue
+		}
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+
+		e := journalEntry{}
+
+		// An unreadable entry must not keep the server from starting, the
+		// partial file it refers to is removed with all others below.
+		err := readJSONFile(path, &e)
+		if err != nil {
+			log.Printf("removing broken journal entry %s: %s", info.Name(), err)
+			os.Remove(path)
+			removed++
+			continue
+		}
+
+		if e.Resumable && time.Since(e.Updated) < ttl {
+			_, err := os.Stat(pathForPending(fs, e))
+			if err == nil {
+				resumed[pathForPending(fs, e)] = true
+				fs.addPending(e.Bucket, e.Offset)
+				kept++
+				continue
+			}
+			if !os.IsNotExist(err) {
+				return removed, kept, err
+			}
+		}
+
+		err = os.Remove(pathForPending(fs, e))
+		if err != nil && !os.IsNotExist(err) {
+			return removed, kept, err
+		}
+
+		err = fs.removeJournal(e.ID)
+		if err != nil {
+			return removed, kept, err
+		}
+		removed++
+	}
+
+	// Plain uploads aren't journaled, the partial files of those interrupted
+	// are all files in progress not belonging to a resumable upload.
+	buckets, err := ioutil.ReadDir(fs.root)
+	if err != nil && !os.IsNotExist(err) {
+		return removed, kept, err
+	}
+
+	for _, b := range buckets {
+		if !b.IsDir() || strings.HasPrefix(b.Name(), ".") {
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(filepath.Join(fs.root, b.Name()))
+		if err != nil {
+			return removed, kept, err
+		}
+
+		for _, info := range infos {
+			path := filepath.Join(fs.root, b.Name(), info.Name())
+			if info.IsDir() || !strings.HasPrefix(info.Name(), pendingPrefix) || resumed[path] {
+				continue
+			}
+
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return removed, kept, err
+			}
+			removed++
+		}
+	}
+
+	return removed, kept, nil
+}
+
+// expireUploads removes the resumable uploads not continued within ttl,
+// skipping those currently written or read. It returns the number of removed
+// uploads.
+func (fs *diskFS) expireUploads(ttl time.Duration) (int, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(fs.root, journalDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, info := range infos {
+		id := strings.TrimSuffix(info.Name(), ".json")
+		if info.IsDir() || !validUploadID(id) || fs.acquireUpload(id) != nil {
+			continue
+		}
+
+		e := journalEntry{}
+
+		err := readJSONFile(pathForJournal(fs, id), &e)
+		if err == nil && e.Resumable && time.Since(e.Updated) >= ttl {
+			err = fs.removeUpload(e)
+			if err == nil {
+				removed++
+			}
+		}
+		fs.releaseUpload(id)
+
+		if err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// addPending adds n bytes to the data received by resumable uploads to
+// bucket.
+func (fs *diskFS) addPending(bucket string, n int64) {
+	fs.uploadsMu.Lock()
+	defer fs.uploadsMu.Unlock()
+
+	if fs.pending == nil {
+		fs.pending = map[string]int64{}
+	}
+	fs.pending[bucket] += n
+}
+
+// pendingUploads sums up the data received by the resumable uploads in
+// progress, except for those currently read.
+func (fs *diskFS) pendingUploads(bucket string) (int64, int64) {
+	fs.uploadsMu.Lock()
+	defer fs.uploadsMu.Unlock()
+
+	total := int64(0)
+	for _, n := range fs.pending {
+		total += n
+	}
+
+	return fs.pending[bucket], total
+}
+
+// acquireUpload marks the upload id as busy, failing with ent.ErrUploadBusy
+// if it already is.
+func (fs *diskFS) acquireUpload(id string) error {
+	fs.uploadsMu.Lock()
+	defer fs.uploadsMu.Unlock()
+
+	if fs.uploads == nil {
+		fs.uploads = map[string]bool{}
+	}
+	if fs.uploads[id] {
+		return ent.ErrUploadBusy
+	}
+	fs.uploads[id] = true
+
+	return nil
+}
+
+func (fs *diskFS) releaseUpload(id string) {
+	fs.uploadsMu.Lock()
+	defer fs.uploadsMu.Unlock()
+
+	delete(fs.uploads, id)
+}
+
+// writeJournal durably records e, replacing any previous entry of the same
+// upload.
+func (fs *diskFS) writeJournal(e journalEntry) error {
+	dir := filepath.Join(fs.root, journalDir)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, pendingPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = json.NewEncoder(tmp).Encode(e)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), pathForJournal(fs, e.ID))
+}
+
+// readJournal returns the journal entry of the resumable upload id into
+// bucket.
+func (fs *diskFS) readJournal(bucket *ent.Bucket, id string) (journalEntry, error) {
+	e := journalEntry{}
+
+	if !validUploadID(id) {
+		return e, ent.ErrUploadNotFound
+	}
+
+	err := readJSONFile(pathForJournal(fs, id), &e)
+	if os.IsNotExist(err) {
+		return e, ent.ErrUploadNotFound
+	}
+	if err != nil {
+		return e, err
+	}
+
+	if !e.Resumable || e.Bucket != bucket.Name {
+		return journalEntry{}, ent.ErrUploadNotFound
+	}
+
+	return e, nil
+}
+
+func (fs *diskFS) removeJournal(id string) error {
+	err := os.Remove(pathForJournal(fs, id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (e journalEntry) upload(bucket *ent.Bucket) *ent.UploadSession {
+	return &ent.UploadSession{
+		ID:      e.ID,
+		Bucket:  bucket,
+		Key:     e.Key,
+		Offset:  e.Offset,
+		Started: e.Started,
+		Updated: e.Updated,
+	}
+}
+
+// uploadReader reads the data of a resumable upload and releases the upload
+// once it is closed.
+type uploadReader struct {
+	io.Reader
+	f  *os.File
+	e  journalEntry
+	fs *diskFS
+}
+
+func (r *uploadReader) Close() error {
+	defer r.fs.releaseUpload(r.e.ID)
+	r.fs.addPending(r.e.Bucket, r.e.Offset)
+	return r.f.Close()
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// validUploadID reports if id could have been returned by newUploadID, so it
+// is safe to be used in paths.
+func validUploadID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 16
+}
+
+func readJSONFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+func pathForJournal(fs *diskFS, id string) string {
+	return filepath.Join(fs.root, journalDir, id+".json")
+}
+
+func pathForPending(fs *diskFS, e journalEntry) string {
+	return filepath.Join(fs.root, e.Bucket, filepath.Base(e.Path))
+}
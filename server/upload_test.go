@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestDiskFSUploadRecovery(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-diskfs-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("upload", ent.Owner{})
+		fs  = newDiskFS(tmp).(*diskFS)
+	)
+
+	up, err := fs.StartUpload(ctx, b, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.AppendUpload(ctx, b, up.ID, 0, strings.NewReader("first "))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.AppendUpload(ctx, b, up.ID, 0, strings.NewReader("again"))
+	if !errors.Is(err, ent.ErrUploadOffset) {
+		t.Errorf("want %s, got %v", ent.ErrUploadOffset, err)
+	}
+
+	// A crash while receiving data leaves unacknowledged data behind, and an
+	// interrupted plain upload its partial file and journal entry.
+	data, err := os.OpenFile(filepath.Join(tmp, "upload", pendingPrefix+"upload-"+up.ID), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.WriteString("lost")
+	data.Close()
+
+	crashed := journalEntry{
+		ID:      strings.Repeat("ab", 16),
+		Bucket:  b.Name,
+		Key:     "crashed",
+		Path:    pendingPrefix + "123",
+		Started: time.Now(),
+		Updated: time.Now(),
+	}
+	err = fs.writeJournal(crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(pathForPending(fs, crashed), []byte("partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain uploads leave only their partial files, broken journal entries
+	// and entries interrupted while written must not stop the recovery.
+	plain := filepath.Join(tmp, b.Name, pendingPrefix+"456")
+	for path, data := range map[string]string{
+		plain: "partial",
+		pathForJournal(fs, strings.Repeat("cd", 16)):        `{"id":`,
+		filepath.Join(tmp, journalDir, pendingPrefix+"789"): `{}`,
+	} {
+		err = ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs = newDiskFS(tmp).(*diskFS)
+
+	removed, kept, err := fs.recoverUploads(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, removed; want != got {
+		t.Errorf("want %d removed uploads, got %d", want, got)
+	}
+	if want, got := 1, kept; want != got {
+		t.Errorf("want %d kept uploads, got %d", want, got)
+	}
+	if _, err := os.Stat(pathForPending(fs, crashed)); !os.IsNotExist(err) {
+		t.Errorf("want partial file of crashed upload removed, got %v", err)
+	}
+	if _, err := os.Stat(plain); !os.IsNotExist(err) {
+		t.Errorf("want partial file of plain upload removed, got %v", err)
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(tmp, journalDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(infos); want != got {
+		t.Errorf("want %d journal entries, got %d", want, got)
+	}
+
+	up, err = fs.AppendUpload(ctx, b, up.ID, 6, strings.NewReader("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int64(12), up.Offset; want != got {
+		t.Errorf("want offset %d, got %d", want, got)
+	}
+
+	r, err := fs.ReadUpload(ctx, b, up.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.AppendUpload(ctx, b, up.ID, 12, strings.NewReader("busy")); !errors.Is(err, ent.ErrUploadBusy) {
+		t.Errorf("want %s, got %v", ent.ErrUploadBusy, err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	r.Close()
+	if want := "first second"; want != string(got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// Uploads not continued within the TTL are removed.
+	removed, kept, err = fs.recoverUploads(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || kept != 0 {
+		t.Errorf("want 1 removed and 0 kept uploads, got %d and %d", removed, kept)
+	}
+	if _, err := fs.Upload(ctx, b, up.ID); !errors.Is(err, ent.ErrUploadNotFound) {
+		t.Errorf("want %s, got %v", ent.ErrUploadNotFound, err)
+	}
+
+	// Plain uploads aren't journaled.
+	f, err := fs.Create(ctx, b, "plain", strings.NewReader("plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	infos, err = ioutil.ReadDir(filepath.Join(tmp, journalDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 0 {
+		t.Errorf("want empty journal, got %d entries", len(infos))
+	}
+}
+
+func TestHandleUpload(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-handle-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		b  = ent.NewBucket("upload", ent.Owner{})
+		p  = newMockProvider(b)
+		fs = newDiskFS(tmp)
+		r  = pat.New()
+	)
+
+	r.Add("POST", routeUpload, handleStartUpload(p, fs))
+	r.Add("PATCH", routeUpload, handleAppendUpload(p, fs))
+	r.Add("PUT", routeUpload, handleFinishUpload(p, fs))
+	r.Add("GET", routeUpload, handleUpload(p, fs))
+	r.Add("DELETE", routeUpload, handleAbortUpload(p, fs))
+	r.Add("GET", routeFile, handleGet(p, fs))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	do := func(method, path string, offset int64, body string) (*http.Response, ent.ResponseUploadSession) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset >= 0 {
+			req.Header.Set(headerOffset, strconv.FormatInt(offset, 10))
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		resp := ent.ResponseUploadSession{}
+		json.NewDecoder(res.Body).Decode(&resp)
+
+		return res, resp
+	}
+
+	res, started := do("POST", "/_uploads/upload/video.mp4", -1, "")
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Fatalf("want code %d, got %d", want, got)
+	}
+
+	path := "/_uploads/upload/video.mp4?upload=" + started.Upload.ID
+
+	for _, test := range []struct {
+		method string
+		path   string
+		offset int64
+		body   string
+		code   int
+		want   int64
+	}{
+		{"PATCH", path, -1, "part1", http.StatusBadRequest, 0},
+		{"PATCH", path, 0, "part1", http.StatusOK, 5},
+		{"PATCH", path, 0, "part1", http.StatusConflict, 0},
+		{"PATCH", path, 5, "part2", http.StatusOK, 10},
+		{"GET", path, -1, "", http.StatusOK, 10},
+		{"GET", "/_uploads/upload/other.mp4?upload=" + started.Upload.ID, -1, "", http.StatusNotFound, 0},
+		{"GET", "/_uploads/upload/video.mp4?upload=../../etc", -1, "", http.StatusNotFound, 0},
+	} {
+		res, resp := do(test.method, test.path, test.offset, test.body)
+		if want, got := test.code, res.StatusCode; want != got {
+			t.Errorf("%s %s: want code %d, got %d", test.method, test.path, want, got)
+			continue
+		}
+		if res.StatusCode == http.StatusOK {
+			if want, got := test.want, resp.Upload.Offset; want != got {
+				t.Errorf("%s %s: want offset %d, got %d", test.method, test.path, want, got)
+			}
+		}
+	}
+
+	res, _ = do("PUT", path, -1, "")
+	if want, got := http.StatusCreated, res.StatusCode; want != got {
+		t.Errorf("want code %d, got %d", want, got)
+	}
+
+	res, err = http.Get(ts.URL + "/upload/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want, got := "part1part2", string(body); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	res, _ = do("GET", path, -1, "")
+	if want, got := http.StatusNotFound, res.StatusCode; want != got {
+		t.Errorf("want finished upload gone with %d, got %d", want, got)
+	}
+
+	_, aborted := do("POST", "/_uploads/upload/aborted", -1, "")
+	res, _ = do("DELETE", "/_uploads/upload/aborted?upload="+aborted.Upload.ID, -1, "")
+	if want, got := http.StatusOK, res.StatusCode; want != got {
+		t.Errorf("want code %d, got %d", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "upload", pendingPrefix+"upload-"+aborted.Upload.ID)); !os.IsNotExist(err) {
+		t.Errorf("want data of aborted upload removed, got %v", err)
+	}
+}
+
+func TestHandleUploadQuota(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-handle-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	b := ent.NewBucket("upload", ent.Owner{})
+	b.Quota = 10
+
+	p := newMockProvider(b)
+
+	fs := newQuotaFS(p, newDiskFS(tmp), 0)
+
+	r := pat.New()
+	r.Add("POST", routeUpload, handleStartUpload(p, fs))
+	r.Add("PATCH", routeUpload, handleAppendUpload(p, fs))
+	r.Add("PUT", routeUpload, handleFinishUpload(p, fs))
+
+	do := func(method, path string, offset int64, body string) (int, ent.ResponseUploadSession) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if offset >= 0 {
+			req.Header.Set(headerOffset, strconv.FormatInt(offset, 10))
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		resp := ent.ResponseUploadSession{}
+		json.NewDecoder(w.Body).Decode(&resp)
+
+		return w.Code, resp
+	}
+
+	_, first := do("POST", "/_uploads/upload/first", -1, "")
+	_, second := do("POST", "/_uploads/upload/second", -1, "")
+
+	var (
+		firstPath  = "/_uploads/upload/first?upload=" + first.Upload.ID
+		secondPath = "/_uploads/upload/second?upload=" + second.Upload.ID
+		exceeded   = ent.StatusCode(ent.KindOf(ent.ErrQuotaExceeded))
+	)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		offset int64
+		body   string
+		code   int
+	}{
+		{"PATCH", firstPath, 0, "123456", http.StatusOK},
+		// The data of uploads in progress counts against the quota.
+		{"PATCH", secondPath, 0, "123456", exceeded},
+		// Finishing an upload doesn't count its data twice.
+		{"PUT", firstPath, -1, "", http.StatusCreated},
+		{"PATCH", secondPath, 0, "123456", exceeded},
+		{"PATCH", secondPath, 0, "1234", http.StatusOK},
+		{"PATCH", secondPath, 4, "5", exceeded},
+	} {
+		if code, _ := do(test.method, test.path, test.offset, test.body); test.code != code {
+			t.Errorf("%s %s %q: want code %d, got %d", test.method, test.path, test.body, test.code, code)
+		}
+	}
+}
+
+func TestDiskFSExpireUploads(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-diskfs-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("upload", ent.Owner{})
+		fs  = newDiskFS(tmp).(*diskFS)
+		ids = []string{}
+	)
+
+	for _, key := range []string{"stale", "busy", "fresh"} {
+		up, err := fs.StartUpload(ctx, b, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fs.AppendUpload(ctx, b, up.ID, 0, strings.NewReader("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, up.ID)
+	}
+
+	for _, id := range ids[:2] {
+		e, err := fs.readJournal(b, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Updated = time.Now().Add(-2 * time.Hour)
+
+		err = fs.writeJournal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	busy, err := fs.ReadUpload(ctx, b, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	removed, err := fs.expireUploads(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, removed; want != got {
+		t.Errorf("want %d removed uploads, got %d", want, got)
+	}
+	if _, err := fs.Upload(ctx, b, ids[0]); !errors.Is(err, ent.ErrUploadNotFound) {
+		t.Errorf("want %s, got %v", ent.ErrUploadNotFound, err)
+	}
+
+	// The busy upload is being read and doesn't count.
+	if inBucket, total := fs.pendingUploads(b.Name); inBucket != 4 || total != 4 {
+		t.Errorf("want 4 bytes pending, got %d in bucket and %d in total", inBucket, total)
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/ent/lib"
+)
+
+const routeStats = "/_stats"
+
+var (
+	bucketFiles = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Program,
+			Name:      "bucket_files",
+			Help:      "Number of files stored in a bucket.",
+		},
+		[]string{"tenant", "bucket"},
+	)
+	bucketBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Program,
+			Name:      "bucket_bytes",
+			Help:      "Total size in bytes of the files stored in a bucket.",
+		},
+		[]string{"tenant", "bucket"},
+	)
+)
+
+func handleStats(p ent.Provider, fs ent.FileSystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		usage, err := bucketUsage(r.Context(), []namespace{{p: p, fs: fs}}, true)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, ent.ResponseBucketUsage{
+			Count:    len(usage),
+			Duration: time.Since(start),
+			Buckets:  knownUsage(usage),
+		})
+	}
+}
+
+// usageFS keeps track of the number and total size of the files of every
+// bucket of p. The usage is updated as files are created and deleted and
+// reconciled with the FileSystem by scanning all buckets, to correct for
+// changes made outside of ent.
+type usageFS struct {
+	ent.FileSystem
+	p      ent.Provider
+	tenant string
+
+	mu      sync.Mutex
+	buckets map[string]*ent.BucketUsage
+	scanned bool
+}
+
+// newUsageFS tracks the usage of the buckets of p in fs, which belong to
+// tenant. The usage is only known once all buckets were scanned, see run.
+func newUsageFS(p ent.Provider, fs ent.FileSystem, tenant string) *usageFS {
+	return &usageFS{
+		FileSystem: fs,
+		p:          p,
+		tenant:     tenant,
+		buckets:    map[string]*ent.BucketUsage{},
+	}
+}
+
+func (u *usageFS) Unwrap() ent.FileSystem {
+	return u.FileSystem
+}
+
+func (u *usageFS) Create(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+	data io.Reader,
+) (ent.File, error) {
+	previous, existed, err := u.size(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := u.FileSystem.Create(ctx, bucket, key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := fileSize(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	files := 1
+	if existed {
+		files = 0
+	}
+	u.adjust(bucket, files, size-previous)
+
+	return f, nil
+}
+
+func (u *usageFS) Delete(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) error {
+	size, _, err := u.size(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	err = u.FileSystem.Delete(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	u.adjust(bucket, -1, -size)
+
+	return nil
+}
+
+// run scans all buckets right away and reconciles the usage with the
+// FileSystem every interval after, until ctx is done.
+func (u *usageFS) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		err := u.scan(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("scanning usage of %q failed: %s", u.tenant, err)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scan replaces the usage of all buckets by the files currently stored.
+func (u *usageFS) scan(ctx context.Context) error {
+	bs, err := u.p.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	buckets := map[string]*ent.BucketUsage{}
+
+	for _, b := range bs {
+		files, err := u.FileSystem.List(ctx, b, "", defaultLimit, ent.NoOpStrategy())
+		if err != nil {
+			return err
+		}
+
+		bu := &ent.BucketUsage{
+			Tenant: u.tenant,
+			Bucket: b,
+			Files:  len(files),
+		}
+
+		for _, f := range files {
+			size, err := fileSize(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			bu.Size += size
+		}
+
+		buckets[b.Name] = bu
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for name := range u.buckets {
+		if _, ok := buckets[name]; !ok {
+			bucketFiles.DeleteLabelValues(u.tenant, name)
+			bucketBytes.DeleteLabelValues(u.tenant, name)
+		}
+	}
+
+	u.buckets = buckets
+	u.scanned = true
+	for _, bu := range buckets {
+		u.export(bu)
+	}
+
+	return nil
+}
+
+// usage returns the current usage of bucket, and false if it isn't known
+// before the first scan completed.
+func (u *usageFS) usage(bucket *ent.Bucket) (ent.BucketUsage, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	bu, ok := u.buckets[bucket.Name]
+	if !ok {
+		return ent.BucketUsage{Tenant: u.tenant, Bucket: bucket}, u.scanned
+	}
+
+	return ent.BucketUsage{
+		Tenant: u.tenant,
+		Bucket: bucket,
+		Files:  bu.Files,
+		Size:   bu.Size,
+	}, u.scanned
+}
+
+// size returns the size of the file stored for key and if there is one.
+func (u *usageFS) size(
+	ctx context.Context,
+	bucket *ent.Bucket,
+	key string,
+) (int64, bool, error) {
+	f, err := u.FileSystem.Open(ctx, bucket, key)
+	if ent.IsFileNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	size, err := fileSize(f)
+	return size, err == nil, err
+}
+
+// adjust corrects the usage of bucket by the given number of files and
+// bytes.
+func (u *usageFS) adjust(bucket *ent.Bucket, files int, size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	bu, ok := u.buckets[bucket.Name]
+	if !ok {
+		bu = &ent.BucketUsage{Tenant: u.tenant, Bucket: bucket}
+		u.buckets[bucket.Name] = bu
+	}
+
+	bu.Files += files
+	bu.Size += size
+	u.export(bu)
+}
+
+// export sets the gauges of bu. It has to be called with u.mu held.
+func (u *usageFS) export(bu *ent.BucketUsage) {
+	bucketFiles.WithLabelValues(u.tenant, bu.Bucket.Name).Set(float64(bu.Files))
+	bucketBytes.WithLabelValues(u.tenant, bu.Bucket.Name).Set(float64(bu.Size))
+}
+
+// usageOf returns the first usageFS in the chain of FileSystems wrapped by
+// fs.
+func usageOf(fs ent.FileSystem) (*usageFS, bool) {
+	for {
+		if u, ok := fs.(*usageFS); ok {
+			return u, true
+		}
+
+		w, ok := fs.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		fs = w.Unwrap()
+	}
+}
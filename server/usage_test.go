@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/ent/lib"
+)
+
+func TestUsageFS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx  = context.Background()
+		a    = ent.NewBucket("a", ent.Owner{})
+		b    = ent.NewBucket("b", ent.Owner{})
+		p    = newMockProvider(a, b)
+		disk = newDiskFS(tmp)
+	)
+
+	f, err := disk.Create(ctx, a, "existing", strings.NewReader("12345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fs := newUsageFS(p, disk, "tenant")
+
+	if _, scanned := fs.usage(a); scanned {
+		t.Error("want usage unknown before the first scan")
+	}
+
+	err = fs.scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(bucket *ent.Bucket, files int, size int64) {
+		t.Helper()
+
+		u, scanned := fs.usage(bucket)
+		if !scanned {
+			t.Errorf("%s: want usage known", bucket.Name)
+		}
+		if want, got := files, u.Files; want != got {
+			t.Errorf("%s: want %d files, got %d", bucket.Name, want, got)
+		}
+		if want, got := size, u.Size; want != got {
+			t.Errorf("%s: want %d bytes, got %d", bucket.Name, want, got)
+		}
+		if want, got := "tenant", u.Tenant; want != got {
+			t.Errorf("%s: want tenant %q, got %q", bucket.Name, want, got)
+		}
+	}
+
+	check(a, 1, 5)
+	check(b, 0, 0)
+
+	for _, data := range []string{"123", "1234567"} {
+		f, err := fs.Create(ctx, b, "file", strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	check(b, 1, 7)
+
+	err = fs.Delete(ctx, a, "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(a, 0, 0)
+
+	// Files stored outside of ent are found by the next scan.
+	err = ioutil.WriteFile(filepath.Join(tmp, "a", "outside"), []byte("12"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(a, 0, 0)
+
+	err = fs.scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(a, 1, 2)
+	check(b, 1, 7)
+}
+
+func TestUsageFSRun(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		b           = ent.NewBucket("b", ent.Owner{})
+		fs          = newUsageFS(newMockProvider(b), newDiskFS(tmp), "")
+		done        = make(chan struct{})
+	)
+
+	go func() {
+		fs.run(ctx, time.Hour)
+		close(done)
+	}()
+
+	for {
+		if _, scanned := fs.usage(b); scanned {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("want run stopped once its context is done")
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ent-handle-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		ctx = context.Background()
+		b   = ent.NewBucket("stats", ent.Owner{})
+		p   = newMockProvider(b)
+		r   = pat.New()
+	)
+
+	fs := newUsageFS(p, newDiskFS(tmp), "")
+
+	f, err := fs.Create(ctx, b, "file", strings.NewReader("stats"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r.Add("GET", routeStats, handleStats(p, fs))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", routeStats, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ServeHTTP(w, req)
+
+	if want, got := http.StatusOK, w.Code; want != got {
+		t.Fatalf("want code %d, got %d", want, got)
+	}
+
+	resp := ent.ResponseBucketUsage{}
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, resp.Count; want != got {
+		t.Fatalf("want %d buckets, got %d", want, got)
+	}
+	if u := resp.Buckets[0]; u.Bucket.Name != "stats" || u.Files != 1 || u.Size != 5 {
+		t.Errorf("want stats with 1 file of 5 bytes, got %s with %d files of %d bytes", u.Bucket.Name, u.Files, u.Size)
+	}
+}